@@ -6,27 +6,35 @@ import (
 )
 
 // ErrInvalidSize is the error returned when the data provided is too long or too short.
-var ErrInvalidSize = fmt.Errorf("amiibo: data must be >= %d and <= %d", AmiiboSize, NTAG215Size)
+var ErrInvalidSize = fmt.Errorf("amiibo: data must be >= %d and <= %d bytes or exactly %d bytes", AmiiboSize, NTAG215Size, NTAG215ExtendedSize)
 
 // Amiibo embeds NTAG215 which in turn contains binary amiibo data. Amiibo allows easy amiibo
 // manipulation.
 type Amiibo struct{ NTAG215 }
 
 // NewAmiibo builds a new Amiibo structure based on the given raw NTAG215 data or by converting it
-// from a given Amiitool struct.
+// from a given Amiitool struct. Extended dumps of NTAG215ExtendedSize bytes are accepted as well,
+// use Extended to get them back. PWD and PACK are zero for an extended dump since it does not hold
+// them.
 func NewAmiibo(data []byte, amiibo *Amiitool) (*Amiibo, error) {
 	if (data == nil && amiibo == nil) || (data != nil && amiibo != nil) {
 		return nil, errors.New("amiibo: provide either amiibo structured data or an Amiitool struct")
 	}
 
 	if data != nil {
-		if len(data) > NTAG215Size || len(data) < AmiiboSize {
+		extended := len(data) == NTAG215ExtendedSize
+		if !extended && (len(data) > NTAG215Size || len(data) < AmiiboSize) {
 			return nil, ErrInvalidSize
 		}
 
 		d := [NTAG215Size]byte{}
-		copy(d[:], data)
+		if extended {
+			copy(d[:], data[:NTAG215ReadableSize])
+		} else {
+			copy(d[:], data)
+		}
 		a := &Amiibo{NTAG215{data: d}}
+		a.setExtended(data)
 
 		if len(data) < NTAG215Size {
 			a.ResetSecurity()
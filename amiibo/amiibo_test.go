@@ -103,6 +103,50 @@ func TestAmiibo_NewAmiiboFilePerfect(t *testing.T) {
 	}
 }
 
+func TestAmiibo_NewAmiiboExtended(t *testing.T) {
+	sig := bytes.Repeat([]byte{0x5a}, SignatureSize)
+	version := []byte{0x00, 0x04, 0x04, 0x02, 0x01, 0x00, 0x11, 0x03}
+	readable := dummyAmiibo()[:NTAG215ReadableSize]
+
+	want := append(append(append([]byte{}, readable...), version...), sig...)
+	if len(want) != NTAG215ExtendedSize {
+		t.Fatalf("got %d bytes, want %d", len(want), NTAG215ExtendedSize)
+	}
+	dmp, err := NewAmiibo(want, nil)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	raw := append(append([]byte{}, readable...), make([]byte, NTAG215Size-NTAG215ReadableSize)...)
+	if got := dmp.Raw(); !bytes.Equal(got, raw) {
+		t.Errorf("got:\n%s want:\n%s", hex.Dump(got), hex.Dump(raw))
+	}
+	if got := dmp.Version(); !bytes.Equal(got, version) {
+		t.Errorf("got %x, want %x", got, version)
+	}
+	if got := dmp.Signature(); !bytes.Equal(got, sig) {
+		t.Errorf("got %x, want %x", got, sig)
+	}
+	if got := dmp.Extended(); !bytes.Equal(got, want) {
+		t.Errorf("got:\n%s want:\n%s", hex.Dump(got), hex.Dump(want))
+	}
+
+	// A zero filled version is unknown.
+	dmp, _ = NewAmiibo(append(append(append([]byte{}, readable...), make([]byte, VersionSize)...), sig...), nil)
+	if got := dmp.Version(); got != nil {
+		t.Errorf("got %x, want nil", got)
+	}
+	if got := dmp.Signature(); !bytes.Equal(got, sig) {
+		t.Errorf("got %x, want %x", got, sig)
+	}
+
+	for _, size := range []int{NTAG215Size + 1, NTAG215Size + SignatureSize + VersionSize} {
+		if _, err := NewAmiibo(make([]byte, size), nil); err != ErrInvalidSize {
+			t.Errorf("got %v, want %s for %d bytes", err, ErrInvalidSize, size)
+		}
+	}
+}
+
 func TestAmiibo_NewAmiiboFromAmiitool(t *testing.T) {
 	amiitool, err := NewAmiitool(readFile(t, testDummyAmiitool), nil)
 	if amiitool == nil || err != nil {
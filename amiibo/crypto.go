@@ -205,6 +205,12 @@ func Crypt(key *DerivedKey, amiibo Amiidump) Amiidump {
 	c, _ := NewAmiidump(amiibo.Raw(), amiibo.Type())
 	c.SetRegisterInfo(dataOut[:32])
 	c.SetSettings(dataOut[32:])
+	// Keep the signature and version of an extended amiibo dump.
+	if a, ok := amiibo.(*Amiibo); ok {
+		e := c.(*Amiibo)
+		e.SetSignature(a.Signature())
+		e.SetVersion(a.Version())
+	}
 
 	return c
 }
@@ -0,0 +1,138 @@
+package amiibo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NTAG215 tokens can be made (partially) read-only using the static and dynamic lock bytes and the
+// CFGLCK bit of the ACCESS byte in CFG1. Lock bits are one time programmable: once set, they can
+// never be cleared. On top of that, the pages from AUTH0 onwards can only be written after a
+// successful PWD_AUTH. Nintendo initialises a retail amiibo by writing the password and locking the
+// configuration and the pages holding the character identity, leaving only the user data area
+// writable.
+
+const (
+	// NTAG215Pages is the number of pages of an NTAG215.
+	NTAG215Pages = NTAG215Size / 4
+	// cfgLock is the CFGLCK bit of the ACCESS byte in CFG1 which permanently locks both
+	// configuration pages.
+	cfgLock = 0x40
+)
+
+// WriteAnalysis holds the write access of an NTAG215 token as derived from its lock bytes and
+// configuration pages.
+type WriteAnalysis struct {
+	// Locked holds the pages that can no longer be changed, in ascending order. The UID pages 0x00
+	// and 0x01 are not included: they are factory programmed on genuine NXP tags.
+	Locked []int
+	// Protected holds the pages that can only be written after password authentication, in
+	// ascending order.
+	Protected []int
+	// Initialised is true when the token has been locked like a retail amiibo, meaning only the user
+	// data area can still be written.
+	Initialised bool
+	// FullWrite is true when none of the pages written by a full write are locked, so any dump can
+	// still be written to the token.
+	FullWrite bool
+}
+
+// AnalyseWrite analyses the lock bytes and configuration pages of the NTAG215 to find out what can
+// still be written to the token. The NTAG215 is expected to hold the data read from the token.
+func (n *NTAG215) AnalyseWrite() *WriteAnalysis {
+	wa := &WriteAnalysis{}
+	locked := n.LockedPages()
+	for page, l := range locked {
+		if l {
+			wa.Locked = append(wa.Locked, page)
+		}
+	}
+
+	// AUTH0 holds the first page that is protected, a value beyond the last page disables the
+	// password protection.
+	for page := int(n.CFG0()[3]); page < NTAG215Pages; page++ {
+		wa.Protected = append(wa.Protected, page)
+	}
+
+	wa.FullWrite = len(wa.Locked) == 0
+	// A retail amiibo has the capability container, the tag HMAC and the character identity up to
+	// page 0x1f as well as its configuration locked.
+	wa.Initialised = locked[0x03] && locked[0x0d] && locked[0x0f] && locked[0x10] && locked[0x1f] && locked[0x83]
+
+	return wa
+}
+
+// CheckWrite returns an error when the given amiibo data cannot be written to the token holding
+// the NTAG215 data. A write is impossible when a locked page would have to change or when the
+// token is password protected and the given data holds no password. When userdataOnly is true,
+// only pages 0x04 up to and including 0x81 are checked. Locked pages that already hold the data to
+// write are accepted since restoring a backup on the same amiibo rewrites them as well.
+func (n *NTAG215) CheckWrite(data []byte, userdataOnly bool) error {
+	if len(data) != NTAG215Size {
+		return ErrInvalidSize
+	}
+
+	first, last := 0x02, NTAG215Pages-1
+	if userdataOnly {
+		first, last = 0x04, 0x81
+	}
+	var pages []int
+	for page := first; page <= last; page++ {
+		pages = append(pages, page)
+	}
+
+	var changed []string
+	for _, page := range n.LockedChanges(data, pages) {
+		changed = append(changed, fmt.Sprintf("%#02x", page))
+	}
+	if changed != nil {
+		if !userdataOnly && n.AnalyseWrite().Initialised {
+			return errors.New("amiibo: the token is an initialised amiibo, only its user data can be written")
+		}
+		return fmt.Errorf("amiibo: locked pages %s of the token would have to change", strings.Join(changed, ", "))
+	}
+
+	if int(n.CFG0()[3]) <= last && bytes.Equal(data[532:536], make([]byte, 4)) {
+		return errors.New("amiibo: the token is password protected but the data holds no password")
+	}
+
+	return nil
+}
+
+// LockedChanges returns the given pages that are locked on the token holding the NTAG215 data while
+// the given data differs from it, in the same order. Locked pages that already hold the data to
+// write are accepted since restoring a backup on the same amiibo rewrites them as well.
+func (n *NTAG215) LockedChanges(data []byte, pages []int) []int {
+	locked := n.LockedPages()
+	var changes []int
+	for _, page := range pages {
+		i := page * 4
+		if locked[page] && !bytes.Equal(n.data[i:i+4], data[i:i+4]) {
+			changes = append(changes, page)
+		}
+	}
+
+	return changes
+}
+
+// LockedPages returns which pages of the NTAG215 are locked. The static lock bytes lock pages 0x03
+// up to and including 0x0f individually, the first dynamic lock byte locks the user memory from
+// page 0x10 onwards in blocks of 16 pages and CFGLCK locks both configuration pages.
+func (n *NTAG215) LockedPages() [NTAG215Pages]bool {
+	var locked [NTAG215Pages]bool
+	static := uint16(n.Lock0()) | uint16(n.Lock1())<<8
+	for page := 0x03; page <= 0x0f; page++ {
+		locked[page] = static&(1<<page) != 0
+	}
+	for page := 0x10; page <= 0x81; page++ {
+		locked[page] = n.DLock0()&(1<<((page-0x10)/16)) != 0
+	}
+	if n.CFG1()[0]&cfgLock != 0 {
+		locked[0x83] = true
+		locked[0x84] = true
+	}
+
+	return locked
+}
@@ -0,0 +1,102 @@
+package amiibo
+
+import (
+	"reflect"
+	"testing"
+)
+
+// loadRetailNtag returns an NTAG215 locked like a retail amiibo: pages 0x03, 0x0d up to and
+// including 0x1f and the configuration pages are locked. The nfcptl tests share the same file.
+func loadRetailNtag(t *testing.T) *NTAG215 {
+	data := [540]byte{}
+	copy(data[:], readFile(t, testRetailNtag))
+	return NewNTAG215(data)
+}
+
+// pageRange returns the pages first up to and including last.
+func pageRange(first, last int) []int {
+	var pages []int
+	for page := first; page <= last; page++ {
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+func TestNTAG215_AnalyseWrite(t *testing.T) {
+	blank := NewNTAG215([540]byte{})
+	blank.data[527] = 0xff
+	got := blank.AnalyseWrite()
+	want := &WriteAnalysis{FullWrite: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	got = loadRetailNtag(t).AnalyseWrite()
+	want = &WriteAnalysis{
+		Locked:      append(append([]int{0x03, 0x0d, 0x0e, 0x0f}, pageRange(0x10, 0x1f)...), 0x83, 0x84),
+		Protected:   pageRange(0x04, 0x86),
+		Initialised: true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	got = loadDummyNtag(t).AnalyseWrite()
+	wantLocked := append(append([]int{0x05, 0x06, 0x07, 0x0b, 0x0c, 0x0d, 0x0e}, pageRange(0x10, 0x1f)...), 0x83, 0x84)
+	if !reflect.DeepEqual(got.Locked, wantLocked) {
+		t.Errorf("got %#02x, want %#02x", got.Locked, wantLocked)
+	}
+	if got.Initialised || got.FullWrite {
+		t.Errorf("got initialised %t and full write %t, want false and false", got.Initialised, got.FullWrite)
+	}
+}
+
+func TestNTAG215_CheckWrite(t *testing.T) {
+	tag := loadRetailNtag(t)
+
+	tests := []struct {
+		name         string
+		page         int
+		userdataOnly bool
+		want         string
+	}{
+		{"unlocked user data", 0x20, true, ""},
+		{"locked user data", 0x10, true, "amiibo: locked pages 0x10 of the token would have to change"},
+		{"locked outside user data", 0x03, true, ""},
+		{"full write", 0x03, false, "amiibo: the token is an initialised amiibo, only its user data can be written"},
+	}
+
+	for _, test := range tests {
+		data := append([]byte{}, tag.Raw()...)
+		data[test.page*4] ^= 0xff
+		err := tag.CheckWrite(data, test.userdataOnly)
+		if got := errString(err); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	data := append([]byte{}, tag.Raw()...)
+	copy(data[532:536], make([]byte, 4))
+	want := "amiibo: the token is password protected but the data holds no password"
+	if got := errString(tag.CheckWrite(data, true)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := tag.CheckWrite(data[:10], true); err != ErrInvalidSize {
+		t.Errorf("got %v, want %v", err, ErrInvalidSize)
+	}
+
+	blank := NewNTAG215([540]byte{})
+	blank.data[527] = 0xff
+	if err := blank.CheckWrite(tag.Raw(), false); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+}
+
+// errString returns the error message of the given error or an empty string for a nil error.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -1,6 +1,9 @@
 package amiibo
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // No attempt was made to add NTAG213 or NTAG216 support as this is out of the scope for Amiibo
 // compatibility.
@@ -11,12 +14,24 @@ const (
 
 	// NTAG215Size defines the maximum amount of bytes for an NTAG215 dump.
 	NTAG215Size = 540
+	// NTAG215ReadableSize defines the amount of bytes that can be read from an NTAG215: pages 0x00
+	// up to and including 0x84. PWD and PACK in the last two pages always read as zero.
+	NTAG215ReadableSize = 532
+	// NTAG215ExtendedSize defines the amount of bytes for a full NTAG215 dump as produced by tools
+	// like Tagmo and Proxmark: the readable pages followed by the 8 byte GET_VERSION response and
+	// the 32 byte originality signature.
+	NTAG215ExtendedSize = NTAG215ReadableSize + VersionSize + SignatureSize
+
+	// VersionSize defines the amount of bytes returned by the NTAG21x GET_VERSION command.
+	VersionSize = 8
 )
 
 // NTAG215 implements the NTAG215 part of the NXP Semiconductors NTAG213/215/216 specification
 // publicly available on the NXP website: https://www.nxp.com/docs/en/data-sheet/NTAG213_215_216.pdf
 type NTAG215 struct {
-	data [540]byte
+	data      [540]byte
+	signature []byte // The originality signature, nil when unknown.
+	version   []byte // The GET_VERSION response, nil when unknown.
 }
 
 func NewNTAG215(data [540]byte) *NTAG215 {
@@ -251,3 +266,102 @@ func (n *NTAG215) RFUI() []byte {
 	copy(rfui[:], n.data[538:540])
 	return rfui
 }
+
+// Signature returns the 32 byte originality signature of the tag as read using the READ_SIG
+// command or nil when it is unknown.
+func (n *NTAG215) Signature() []byte {
+	if n.signature == nil {
+		return nil
+	}
+	sig := make([]byte, SignatureSize)
+	copy(sig, n.signature)
+	return sig
+}
+
+// SetSignature sets the originality signature of the tag. Passing nil clears the signature.
+func (n *NTAG215) SetSignature(sig []byte) error {
+	if sig == nil {
+		n.signature = nil
+		return nil
+	}
+	if len(sig) != SignatureSize {
+		return fmt.Errorf("amiibo: signature must be %d bytes", SignatureSize)
+	}
+	n.signature = append([]byte{}, sig...)
+	return nil
+}
+
+// VerifySignature verifies the originality signature of the tag against its UID. It returns false
+// when the signature is unknown.
+func (n *NTAG215) VerifySignature() bool {
+	return n.signature != nil && VerifySignature(n.UID(), n.signature)
+}
+
+// Version returns the 8 byte response to the GET_VERSION command identifying the chip or nil when
+// it is unknown. For an NTAG215 this is 00 04 04 02 01 00 11 03.
+func (n *NTAG215) Version() []byte {
+	if n.version == nil {
+		return nil
+	}
+	v := make([]byte, VersionSize)
+	copy(v, n.version)
+	return v
+}
+
+// SetVersion sets the GET_VERSION response of the tag. Passing nil clears the version.
+func (n *NTAG215) SetVersion(v []byte) error {
+	if v == nil {
+		n.version = nil
+		return nil
+	}
+	if len(v) != VersionSize {
+		return fmt.Errorf("amiibo: version must be %d bytes", VersionSize)
+	}
+	n.version = append([]byte{}, v...)
+	return nil
+}
+
+// Extended returns the tag data in the NTAG215ExtendedSize format when the signature or version is
+// known, the raw tag data otherwise. The extended format holds the readable pages followed by the
+// version and the signature, so PWD and PACK are not part of it. An unknown version or signature
+// is zero filled.
+func (n *NTAG215) Extended() []byte {
+	data := n.Raw()
+	if n.signature == nil && n.version == nil {
+		return data
+	}
+
+	ext := make([]byte, NTAG215ExtendedSize)
+	copy(ext, data[:NTAG215ReadableSize])
+	copy(ext[NTAG215ReadableSize:], n.version)
+	copy(ext[NTAG215ReadableSize+VersionSize:], n.signature)
+
+	return ext
+}
+
+// setExtended sets the version and signature from the given extended dump. A zero filled version
+// or signature is considered unknown.
+func (n *NTAG215) setExtended(data []byte) {
+	if len(data) != NTAG215ExtendedSize {
+		return
+	}
+
+	version := data[NTAG215ReadableSize : NTAG215ReadableSize+VersionSize]
+	if !isZero(version) {
+		n.SetVersion(version)
+	}
+	sig := data[NTAG215ReadableSize+VersionSize:]
+	if !isZero(sig) {
+		n.SetSignature(sig)
+	}
+}
+
+// isZero returns true when all bytes of the given slice are zero.
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
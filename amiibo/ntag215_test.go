@@ -295,3 +295,50 @@ func TestNTAG215_RFUI(t *testing.T) {
 		t.Errorf("got %#02x, want %#02x", got, want)
 	}
 }
+
+func TestNTAG215_Signature(t *testing.T) {
+	tag := loadDummyNtag(t)
+	if tag.Signature() != nil || tag.Version() != nil {
+		t.Errorf("got %x %x, want nil nil", tag.Signature(), tag.Version())
+	}
+	if got := tag.Extended(); len(got) != NTAG215Size {
+		t.Errorf("got %d bytes, want %d", len(got), NTAG215Size)
+	}
+	if tag.VerifySignature() {
+		t.Error("got true, want false")
+	}
+
+	if err := tag.SetSignature(make([]byte, 16)); err == nil {
+		t.Error("got nil, want error")
+	}
+	if err := tag.SetVersion(make([]byte, 4)); err == nil {
+		t.Error("got nil, want error")
+	}
+
+	version := []byte{0x00, 0x04, 0x04, 0x02, 0x01, 0x00, 0x11, 0x03}
+	if err := tag.SetVersion(version); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	got := tag.Extended()
+	want := append(append(dummyAmiibo()[:NTAG215ReadableSize], version...), make([]byte, SignatureSize)...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("got:\n%s want:\n%s", hex.Dump(got), hex.Dump(want))
+	}
+
+	sig := bytes.Repeat([]byte{0x5a}, SignatureSize)
+	if err := tag.SetSignature(sig); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if got := tag.Signature(); !bytes.Equal(got, sig) {
+		t.Errorf("got %x, want %x", got, sig)
+	}
+	if tag.VerifySignature() {
+		t.Error("got true, want false")
+	}
+
+	tag.SetSignature(nil)
+	tag.SetVersion(nil)
+	if got := tag.Extended(); len(got) != NTAG215Size {
+		t.Errorf("got %d bytes, want %d", len(got), NTAG215Size)
+	}
+}
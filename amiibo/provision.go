@@ -0,0 +1,50 @@
+package amiibo
+
+import "errors"
+
+// retailLockBytes returns the static lock bytes followed by the capability container of a retail
+// amiibo, being bytes 0x0a up to and including 0x0f of the NTAG215.
+func retailLockBytes() []byte {
+	return []byte{0x0f, 0xe0, 0xf1, 0x10, 0xff, 0xee}
+}
+
+// Provision turns the given amiibo dump into a proper amiibo for the blank NTAG215 token holding
+// the given data. The dump can be encrypted or decrypted. Its UID is replaced by the UID of the
+// blank token after which it is signed and encrypted again using the given retail key. The lock
+// bytes, capability container and configuration pages are set to those of a retail amiibo and the
+// password is generated from the new UID. It returns a NEW amiibo struct, the given dump remains
+// unaltered.
+// The blank token must be written in the right order to end up with a working amiibo: the data
+// pages first, then the password, then the configuration pages and the lock bytes last. Use the
+// WriteProvision mode of the nfcptl package to do so.
+func Provision(key *RetailKey, dump Amiidump, blank *NTAG215) (*Amiibo, error) {
+	dec := dump
+	if !Verify(dump, NewDerivedKey(&key.Tag, dump), NewDerivedKey(&key.Data, dump)) {
+		var err error
+		if dec, err = Decrypt(key, dump); err != nil {
+			return nil, err
+		}
+	}
+
+	var a *Amiibo
+	switch d := dec.(type) {
+	case *Amiitool:
+		a = AmiitoolToAmiibo(d)
+	case *Amiibo:
+		a = &Amiibo{NTAG215{data: d.data}}
+	}
+
+	// Take over the UID, BCC1 and the internal byte of the blank token: these are factory
+	// programmed.
+	copy(a.data[:10], blank.data[:10])
+	if !a.ValidateUID() {
+		return nil, errors.New("amiibo: the blank token holds an invalid UID")
+	}
+	copy(a.data[10:16], retailLockBytes())
+	a.ResetSecurity()
+
+	enc := Encrypt(key, a).(*Amiibo)
+	enc.GeneratePassword()
+
+	return enc, nil
+}
@@ -0,0 +1,80 @@
+package amiibo
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testKey returns a made up retail key so that the crypto can be tested without the real key.
+func testKey() *RetailKey {
+	key := &RetailKey{}
+	for i := range key.Data.HmacKey {
+		key.Data.HmacKey[i] = byte(i)
+		key.Tag.HmacKey[i] = byte(0xff - i)
+	}
+	copy(key.Data.Type[:], "unfixed infos\x00")
+	copy(key.Tag.Type[:], "locked secret\x00")
+	key.Data.MagicBytesSize = 14
+	key.Tag.MagicBytesSize = 16
+	for i := range key.Data.XorPad {
+		key.Data.XorPad[i] = byte(i * 3)
+		key.Tag.XorPad[i] = byte(i * 7)
+	}
+	return key
+}
+
+func TestProvision(t *testing.T) {
+	key := testKey()
+	dec, _ := NewAmiibo(loadDummyNtag(t).Raw(), nil)
+	enc := Encrypt(key, dec)
+	orig := append([]byte{}, enc.Raw()...)
+
+	blank := NewNTAG215([540]byte{})
+	uid := validFullUid()
+	blank.SetUID(uid)
+	blank.data[9] = 0x48
+
+	for _, dump := range []Amiidump{enc, dec, AmiiboToAmiitool(enc.(*Amiibo))} {
+		a, err := Provision(key, dump, blank)
+		if err != nil {
+			t.Fatalf("Provision returned error %s", err)
+		}
+
+		if !bytes.Equal(a.FullUID(), uid[:]) || a.Int() != 0x48 {
+			t.Errorf("got UID %#02x and int %#02x, want %#02x and 0x48", a.FullUID(), a.Int(), uid)
+		}
+		if got, want := a.Raw()[10:16], retailLockBytes(); !bytes.Equal(got, want) {
+			t.Errorf("got lock bytes %#02x, want %#02x", got, want)
+		}
+		if got, want := a.Raw()[520:532], defaultSecurity()[:12]; !bytes.Equal(got, want) {
+			t.Errorf("got security %#02x, want %#02x", got, want)
+		}
+		if got, want := a.Password(), generatePassword(blank.UID()); !bytes.Equal(got, want[:]) {
+			t.Errorf("got password %#02x, want %#02x", got, want)
+		}
+		if got, want := a.PasswordAcknowledge(), []byte{0x80, 0x80}; !bytes.Equal(got, want) {
+			t.Errorf("got password acknowledge %#02x, want %#02x", got, want)
+		}
+
+		d, err := Decrypt(key, a)
+		if err != nil {
+			t.Fatalf("Decrypt returned error %s", err)
+		}
+		if got, want := d.SettingsRaw(), dec.SettingsRaw(); !bytes.Equal(got, want) {
+			t.Errorf("got settings %#02x, want %#02x", got, want)
+		}
+	}
+
+	if !bytes.Equal(enc.Raw(), orig) {
+		t.Error("Provision altered the given dump")
+	}
+
+	if _, err := Provision(testKey(), dec, NewNTAG215([540]byte{0x04})); err == nil {
+		t.Error("expected an error for a blank token with an invalid UID")
+	}
+
+	key.Data.HmacKey[0] ^= 0xff
+	if _, err := Provision(key, enc, blank); err == nil {
+		t.Error("expected an error for a dump that cannot be decrypted")
+	}
+}
@@ -0,0 +1,121 @@
+package amiibo
+
+import "math/big"
+
+// NTAG21x tags carry a 32 byte originality signature programmed by NXP which can be read using the
+// READ_SIG command. It is an ECDSA signature over the 7 byte UID of the tag using the secp128r1
+// curve, with the UID itself used as the message, without hashing it first. The signature is
+// verified using the public key published by NXP in application note AN11350.
+
+const (
+	// SignatureSize is the size of the NTAG21x originality signature: r and s, 16 bytes each.
+	SignatureSize = 32
+)
+
+// secp128r1 holds the domain parameters of the secp128r1 curve as defined in SEC 2.
+var secp128r1 = struct {
+	p, a, b, n *big.Int
+	gx, gy     *big.Int
+}{
+	p:  hexInt("fffffffdffffffffffffffffffffffff"),
+	a:  hexInt("fffffffdfffffffffffffffffffffffc"),
+	b:  hexInt("e87579c11079f43dd824993c2cee5ed3"),
+	n:  hexInt("fffffffe0000000075a30d1b9038a115"),
+	gx: hexInt("161ff7528b899b2d0c28607ca52c5b86"),
+	gy: hexInt("cf5ac8395bafeb13c02da292dded7a83"),
+}
+
+// nxpPublicKey is the NXP public key used to sign the UID of NTAG21x tags.
+var nxpPublicKey = struct{ x, y *big.Int }{
+	x: hexInt("494e1a386d3d3cfe3dc10e5de68a499b"),
+	y: hexInt("1c202db5b132393e89ed19fe5be8bc61"),
+}
+
+// VerifySignature verifies the given 32 byte originality signature against the given 7 byte UID
+// using the NXP public key. It returns true for a genuine NXP tag. Blank NTAG215 clones either have
+// no valid signature or have been signed by someone other than NXP.
+func VerifySignature(uid, sig []byte) bool {
+	return verifySignature(nxpPublicKey.x, nxpPublicKey.y, uid, sig)
+}
+
+// verifySignature verifies the given ECDSA signature of the UID using the given public key.
+func verifySignature(qx, qy *big.Int, uid, sig []byte) bool {
+	if len(uid) != 7 || len(sig) != SignatureSize {
+		return false
+	}
+
+	c := secp128r1
+	r := new(big.Int).SetBytes(sig[:16])
+	s := new(big.Int).SetBytes(sig[16:])
+	if r.Sign() == 0 || s.Sign() == 0 || r.Cmp(c.n) >= 0 || s.Cmp(c.n) >= 0 {
+		return false
+	}
+
+	// u1 = e / s and u2 = r / s where e is the UID.
+	w := new(big.Int).ModInverse(s, c.n)
+	u1 := new(big.Int).SetBytes(uid)
+	u1.Mul(u1, w).Mod(u1, c.n)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, c.n)
+
+	x1, y1 := scalarMult(c.gx, c.gy, u1)
+	x2, y2 := scalarMult(qx, qy, u2)
+	x, _ := addPoints(x1, y1, x2, y2)
+	if x == nil {
+		return false
+	}
+
+	return x.Mod(x, c.n).Cmp(r) == 0
+}
+
+// addPoints adds two points on the secp128r1 curve using affine coordinates. The point at infinity
+// is represented by nil coordinates.
+func addPoints(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	if x1 == nil {
+		return x2, y2
+	}
+	if x2 == nil {
+		return x1, y1
+	}
+
+	p := secp128r1.p
+	l := new(big.Int)
+	if x1.Cmp(x2) == 0 {
+		if sum := new(big.Int).Add(y1, y2); sum.Mod(sum, p).Sign() == 0 {
+			return nil, nil
+		}
+		// l = (3 * x1^2 + a) / (2 * y1)
+		l.Mul(x1, x1).Mul(l, big.NewInt(3)).Add(l, secp128r1.a)
+		l.Mul(l, new(big.Int).ModInverse(new(big.Int).Lsh(y1, 1), p))
+	} else {
+		// l = (y2 - y1) / (x2 - x1)
+		dx := new(big.Int).Sub(x2, x1)
+		l.Sub(y2, y1).Mul(l, dx.ModInverse(dx.Mod(dx, p), p))
+	}
+	l.Mod(l, p)
+
+	x := new(big.Int).Mul(l, l)
+	x.Sub(x, x1).Sub(x, x2).Mod(x, p)
+	y := new(big.Int).Sub(x1, x)
+	y.Mul(y, l).Sub(y, y1).Mod(y, p)
+
+	return x, y
+}
+
+// scalarMult returns k times the given point on the secp128r1 curve.
+func scalarMult(x, y, k *big.Int) (*big.Int, *big.Int) {
+	var rx, ry *big.Int
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		rx, ry = addPoints(rx, ry, rx, ry)
+		if k.Bit(i) == 1 {
+			rx, ry = addPoints(rx, ry, x, y)
+		}
+	}
+	return rx, ry
+}
+
+// hexInt returns the big.Int for the given hexadecimal string.
+func hexInt(s string) *big.Int {
+	i, _ := new(big.Int).SetString(s, 16)
+	return i
+}
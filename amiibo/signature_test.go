@@ -0,0 +1,74 @@
+package amiibo
+
+import (
+	"math/big"
+	"testing"
+)
+
+// onCurve returns true when the given point is on the secp128r1 curve.
+func onCurve(x, y *big.Int) bool {
+	c := secp128r1
+	// y^2 = x^3 + ax + b
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, c.p)
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x).Add(x3, new(big.Int).Mul(c.a, x)).Add(x3, c.b).Mod(x3, c.p)
+	return y2.Cmp(x3) == 0
+}
+
+// sign signs the given UID with the given private key using the given nonce.
+func sign(d, k *big.Int, uid []byte) []byte {
+	c := secp128r1
+	r, _ := scalarMult(c.gx, c.gy, k)
+	r.Mod(r, c.n)
+	// s = (e + rd) / k
+	s := new(big.Int).Mul(r, d)
+	s.Add(s, new(big.Int).SetBytes(uid)).Mul(s, new(big.Int).ModInverse(k, c.n)).Mod(s, c.n)
+
+	sig := make([]byte, SignatureSize)
+	r.FillBytes(sig[:16])
+	s.FillBytes(sig[16:])
+	return sig
+}
+
+func TestSecp128r1(t *testing.T) {
+	c := secp128r1
+	if !onCurve(c.gx, c.gy) {
+		t.Error("got generator off the curve, want on the curve")
+	}
+	if x, y := scalarMult(c.gx, c.gy, c.n); x != nil || y != nil {
+		t.Errorf("got %x %x, want the point at infinity", x, y)
+	}
+	if !onCurve(nxpPublicKey.x, nxpPublicKey.y) {
+		t.Error("got NXP public key off the curve, want on the curve")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	d := hexInt("0123456789abcdef0123456789abcdef")
+	qx, qy := scalarMult(secp128r1.gx, secp128r1.gy, d)
+	uid := []byte{0x04, 0x23, 0x5c, 0x8a, 0x32, 0x4d, 0x80}
+	sig := sign(d, hexInt("fedcba9876543210fedcba9876543210"), uid)
+
+	if !verifySignature(qx, qy, uid, sig) {
+		t.Error("got false, want true")
+	}
+	if VerifySignature(uid, sig) {
+		t.Error("got true for a signature not made by NXP, want false")
+	}
+
+	tampered := append([]byte{}, uid...)
+	tampered[6]++
+	if verifySignature(qx, qy, tampered, sig) {
+		t.Error("got true for a tampered UID, want false")
+	}
+	if verifySignature(qx, qy, uid[:4], sig) {
+		t.Error("got true for a short UID, want false")
+	}
+	if verifySignature(qx, qy, uid, make([]byte, SignatureSize)) {
+		t.Error("got true for an empty signature, want false")
+	}
+	if verifySignature(qx, qy, uid, sig[:16]) {
+		t.Error("got true for a short signature, want false")
+	}
+}
@@ -9,6 +9,7 @@ const (
 	testDataDir = "testdata/"
 
 	testDummyNtag     = "dummy_ntag215.bin"
+	testRetailNtag    = "retail_ntag215.bin"
 	testDummyAmiitool = "dummy_amiitool.bin"
 )
 
@@ -6,6 +6,7 @@ import (
 	"github.com/malc0mn/amiigo/amiibo"
 	"github.com/malc0mn/amiigo/nfcptl"
 	"sync"
+	"time"
 )
 
 // config holds all the active settings
@@ -14,6 +15,30 @@ type config struct {
 	vendor string
 	// device is the product alias of the USB device to connect to.
 	device string
+	// serial is the serial number of the USB device to connect to. When empty, the first device
+	// matching the vendor and device alias will be used.
+	serial string
+	// port is the serial port of NFC portals connected over a UART, e.g. a PN532 breakout board.
+	// When empty, the default port of the driver will be used.
+	port string
+	// baud is the baud rate to use for the serial port. When zero, the default baud rate of the
+	// driver will be used.
+	baud int
+	// tokenDir is the directory watched by the virtual portal for token files. A token file placed
+	// in this directory will be placed on the virtual portal.
+	tokenDir string
+	// listen is the address the portal server listens on in serve mode.
+	listen string
+	// token is the auth token the portal server requires in serve mode. When using a remote
+	// portal, it is the auth token sent to the server. It is read from the config file or the
+	// tokenEnv environment variable only, so it does not show up in the process list.
+	token string
+	// remote is the address of the portal server to use instead of a local portal. Using a remote
+	// portal is disabled when empty.
+	remote string
+	// recordFile is the path of the file to record all traffic with the NFC portal to. Recording
+	// is disabled when empty.
+	recordFile string
 	// cacheDir is the path to the directory where data will be cached. If the path does not start
 	// with a leading forward slash ("/"), it will be stored in the current users home directory.
 	// It defaults to "~/.cache".
@@ -33,6 +58,8 @@ type config struct {
 
 	// ui holds UI related config.
 	ui *uiConf
+	// portal holds the tuning options of the portal driver.
+	portal *portalConf
 
 	// quit is closed on command shutdown.
 	quit chan struct{}
@@ -45,11 +72,66 @@ type uiConf struct {
 	invertImage bool
 }
 
+// portalConf holds the tuning options passed to drivers implementing nfcptl.Configurable. A zero
+// value keeps the default of the driver.
+type portalConf struct {
+	// optimised makes the driver communicate with the portal as efficiently as possible instead
+	// of mimicking the original software.
+	optimised bool
+	// pollMs is the interval in milliseconds at which the portal is polled for a token.
+	pollMs int
+	// removalErrors is the number of consecutive failed token polls after which the token is
+	// considered to be removed.
+	removalErrors int
+	// readRetries is the number of times reading a page is retried. A negative value disables
+	// retries.
+	readRetries int
+	// writeRetries is the number of times writing a page is retried. A negative value disables
+	// retries.
+	writeRetries int
+	// experimentalMFC enables the unverified MIFARE Classic support of the portal.
+	experimentalMFC bool
+}
+
+// options returns the driver options for the non zero values of the portal config.
+func (pc *portalConf) options() []nfcptl.Option {
+	var opts []nfcptl.Option
+	if pc.optimised {
+		opts = append(opts, nfcptl.Optimised(true))
+	}
+	if pc.pollMs > 0 {
+		opts = append(opts, nfcptl.PollInterval(time.Duration(pc.pollMs)*time.Millisecond))
+	}
+	if pc.removalErrors > 0 {
+		opts = append(opts, nfcptl.RemovalThreshold(pc.removalErrors))
+	}
+	if pc.readRetries > 0 {
+		opts = append(opts, nfcptl.ReadRetries(pc.readRetries))
+	} else if pc.readRetries < 0 {
+		opts = append(opts, nfcptl.ReadRetries(0))
+	}
+	if pc.writeRetries > 0 {
+		opts = append(opts, nfcptl.WriteRetries(pc.writeRetries))
+	} else if pc.writeRetries < 0 {
+		opts = append(opts, nfcptl.WriteRetries(0))
+	}
+	if pc.experimentalMFC {
+		opts = append(opts, nfcptl.ExperimentalMFC(true))
+	}
+
+	return opts
+}
+
 const (
 	// defaultVendor is the default vendor alias to use for vendor ID lookup
 	defaultVendor = nfcptl.VendorDatelElextronicsLtd
 	// defaultDevice is the default device alias to use for vendor ID lookup
 	defaultDevice = nfcptl.ProductPowerSavesForAmiibo
+	// defaultListen is the default address the portal server listens on in serve mode. The server
+	// does not encrypt its traffic, so it only listens on the loopback address by default.
+	defaultListen = "127.0.0.1:7390"
+	// tokenEnv is the environment variable holding the auth token, overriding the config file.
+	tokenEnv = "AMIIGO_TOKEN"
 	// defaultAmiiboApiBaseUrl is the default base url of the open Amiibo HTTP API.
 	defaultAmiiboApiBaseUrl = "https://www.amiiboapi.com"
 )
@@ -57,10 +139,12 @@ const (
 var conf = &config{
 	vendor:           defaultVendor,
 	device:           defaultDevice,
+	listen:           defaultListen,
 	cacheDir:         cacheDir,
 	logFile:          defaultLogFile,
 	amiiboApiBaseUrl: defaultAmiiboApiBaseUrl,
 	ui:               &uiConf{},
+	portal:           &portalConf{},
 	quit:             make(chan struct{}),
 }
 
@@ -83,6 +167,29 @@ func loadConfig(cFile string, conf *config) error {
 		if k, err := i.GetKey("device"); err == nil {
 			conf.device = k.String()
 		}
+		if k, err := i.GetKey("serial"); err == nil {
+			conf.serial = k.String()
+		}
+		if k, err := i.GetKey("port"); err == nil {
+			conf.port = k.String()
+		}
+		if k, err := i.GetKey("baud"); err == nil {
+			if v, err := k.Int(); err == nil {
+				conf.baud = v
+			}
+		}
+		if k, err := i.GetKey("token_dir"); err == nil {
+			conf.tokenDir = k.String()
+		}
+		if k, err := i.GetKey("listen"); err == nil {
+			conf.listen = k.String()
+		}
+		if k, err := i.GetKey("token"); err == nil {
+			conf.token = k.String()
+		}
+		if k, err := i.GetKey("remote"); err == nil {
+			conf.remote = k.String()
+		}
 		if k, err := i.GetKey("amiibo_api_base_url"); err == nil {
 			conf.amiiboApiBaseUrl = k.String()
 		}
@@ -99,6 +206,39 @@ func loadConfig(cFile string, conf *config) error {
 		}
 	}
 
+	if i, err := f.GetSection("portal"); err == nil {
+		if k, err := i.GetKey("optimised"); err == nil {
+			if v, err := k.Bool(); err == nil {
+				conf.portal.optimised = v
+			}
+		}
+		if k, err := i.GetKey("poll_ms"); err == nil {
+			if v, err := k.Int(); err == nil {
+				conf.portal.pollMs = v
+			}
+		}
+		if k, err := i.GetKey("removal_errors"); err == nil {
+			if v, err := k.Int(); err == nil {
+				conf.portal.removalErrors = v
+			}
+		}
+		if k, err := i.GetKey("read_retries"); err == nil {
+			if v, err := k.Int(); err == nil {
+				conf.portal.readRetries = v
+			}
+		}
+		if k, err := i.GetKey("write_retries"); err == nil {
+			if v, err := k.Int(); err == nil {
+				conf.portal.writeRetries = v
+			}
+		}
+		if k, err := i.GetKey("experimental_mfc"); err == nil {
+			if v, err := k.Bool(); err == nil {
+				conf.portal.experimentalMFC = v
+			}
+		}
+	}
+
 	return nil
 }
 
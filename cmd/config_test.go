@@ -44,6 +44,40 @@ func TestLoadconfigOk(t *testing.T) {
 		t.Errorf("conf.device = %s; want %s", conf.device, want)
 	}
 
+	want = "0123456789AB"
+	if conf.serial != want {
+		t.Errorf("conf.serial = %s; want %s", conf.serial, want)
+	}
+
+	want = "/dev/ttyS3"
+	if conf.port != want {
+		t.Errorf("conf.port = %s; want %s", conf.port, want)
+	}
+
+	if conf.baud != 57600 {
+		t.Errorf("conf.baud = %d; want %d", conf.baud, 57600)
+	}
+
+	want = "/some/token/dir"
+	if conf.tokenDir != want {
+		t.Errorf("conf.tokenDir = %s; want %s", conf.tokenDir, want)
+	}
+
+	want = "127.0.0.1:7391"
+	if conf.listen != want {
+		t.Errorf("conf.listen = %s; want %s", conf.listen, want)
+	}
+
+	want = "s3cr3t"
+	if conf.token != want {
+		t.Errorf("conf.token = %s; want %s", conf.token, want)
+	}
+
+	want = "lab:7390"
+	if conf.remote != want {
+		t.Errorf("conf.remote = %s; want %s", conf.remote, want)
+	}
+
 	want = "/some/test/dir"
 	if conf.cacheDir != want {
 		t.Errorf("conf.cacheDir = %s; want %s", conf.cacheDir, want)
@@ -63,6 +97,34 @@ func TestLoadconfigOk(t *testing.T) {
 	if conf.ui.invertImage != wantB {
 		t.Errorf("conf.ui.invertImage = %v; want %v", conf.ui.invertImage, wantB)
 	}
+
+	if conf.portal.optimised != wantB {
+		t.Errorf("conf.portal.optimised = %v; want %v", conf.portal.optimised, wantB)
+	}
+
+	wantI := 100
+	if conf.portal.pollMs != wantI {
+		t.Errorf("conf.portal.pollMs = %d; want %d", conf.portal.pollMs, wantI)
+	}
+
+	wantI = 3
+	if conf.portal.removalErrors != wantI {
+		t.Errorf("conf.portal.removalErrors = %d; want %d", conf.portal.removalErrors, wantI)
+	}
+
+	wantI = 4
+	if conf.portal.readRetries != wantI {
+		t.Errorf("conf.portal.readRetries = %d; want %d", conf.portal.readRetries, wantI)
+	}
+
+	wantI = 5
+	if conf.portal.writeRetries != wantI {
+		t.Errorf("conf.portal.writeRetries = %d; want %d", conf.portal.writeRetries, wantI)
+	}
+
+	if conf.portal.experimentalMFC != wantB {
+		t.Errorf("conf.portal.experimentalMFC = %v; want %v", conf.portal.experimentalMFC, wantB)
+	}
 }
 
 func TestLoadConfigWrongPath(t *testing.T) {
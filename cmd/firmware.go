@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/malc0mn/amiigo/nfcptl"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// firmware prints the hardware info of the NFC portal. When a firmware image is given, the image is
+// flashed to the portal after confirmation by putting the portal in DFU mode. Only STM32F0 based
+// portals are supported.
+func firmware(conf *config, image string) error {
+	if conf.remote != "" {
+		return errors.New("updating the firmware requires a local NFC portal")
+	}
+
+	var data []byte
+	if image != "" {
+		var err error
+		if data, err = os.ReadFile(image); err != nil {
+			return err
+		}
+		if err := nfcptl.ValidateSTM32F0Firmware(data); err != nil {
+			return err
+		}
+	}
+
+	// The portal is located first so its USB port is known when it attaches again in DFU mode.
+	di, err := locatePortal(conf)
+	if err != nil {
+		return err
+	}
+	c, err := nfcptl.NewClientForDevice(di, verbose)
+	if err != nil {
+		return err
+	}
+	if err := c.Connect(); err != nil {
+		return err
+	}
+	// The events channel is closed once the client has fully shut down.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range c.Events() {
+			if verbose {
+				log.Printf("event %s: % x", e, e.Data())
+			}
+		}
+	}()
+	disconnect := func() {
+		c.Disconnect()
+		<-done
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	raw, err := c.HardwareInfo(ctx)
+	var info *nfcptl.STM32F0HardwareInfo
+	if err == nil {
+		info, err = nfcptl.ParseSTM32F0HardwareInfo(raw)
+	}
+	if err != nil {
+		disconnect()
+		return fmt.Errorf("reading hardware info: %w", err)
+	}
+	fmt.Printf("NFC portal %04x:%04x: %s\n", c.VendorId(), c.ProductId(), info)
+
+	if data == nil {
+		disconnect()
+		return nil
+	}
+
+	fmt.Printf("About to flash %s to the NFC portal. Do NOT unplug the portal while flashing!\n", image)
+	fmt.Print("Type 'yes' to continue: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != "yes" {
+		disconnect()
+		fmt.Println("Firmware update cancelled.")
+		return nil
+	}
+
+	err = c.EnterDfu(ctx)
+	disconnect()
+	if err != nil {
+		return fmt.Errorf("entering DFU mode: %w", err)
+	}
+
+	fmt.Println("Waiting for the NFC portal to attach in DFU mode...")
+	wait, cancelWait := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelWait()
+	d, err := nfcptl.OpenDFU(wait, nfcptl.STM32F0DFUTarget(di), 500*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// Flashing is never interrupted: a partially flashed portal is worse than a slow update.
+	err = d.Flash(context.Background(), data, func(p nfcptl.FirmwareProgress) {
+		fmt.Printf("\r%-8s %5d/%d bytes (%d%%)", p.Stage, p.Done, p.Total, p.Done*100/p.Total)
+	})
+	fmt.Println()
+	if errors.Is(err, nfcptl.ErrDfuSe) {
+		return fmt.Errorf("firmware not flashed, unplug the NFC portal and plug it back in: %w", err)
+	} else if errors.Is(err, nfcptl.ErrNotVerified) {
+		fmt.Println("Warning: the NFC portal cannot read back its firmware, the firmware has not been verified.")
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Println("Firmware updated, unplug the NFC portal and plug it back in.")
+	return nil
+}
+
+// locatePortal returns the attached NFC portal matching the configured vendor, device and serial
+// number. An unset value matches any portal. An error is returned when no portal or more than one
+// portal matches.
+func locatePortal(conf *config) (nfcptl.DeviceInfo, error) {
+	devs, err := nfcptl.ListDevices()
+	if err != nil {
+		return nfcptl.DeviceInfo{}, err
+	}
+
+	var found []nfcptl.DeviceInfo
+	for _, d := range devs {
+		if (conf.vendor == "" || d.Vendor == conf.vendor) && (conf.device == "" || d.Product == conf.device) && (conf.serial == "" || d.Serial == conf.serial) {
+			found = append(found, d)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nfcptl.DeviceInfo{}, errors.New("no matching NFC portal found")
+	case 1:
+		return found[0], nil
+	default:
+		return nfcptl.DeviceInfo{}, errors.New("multiple NFC portals found, select one using -s")
+	}
+}
@@ -3,18 +3,29 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 )
 
 var (
-	showHelp    bool
-	showVersion bool
-	verbose     bool
-	cFile       string
+	showHelp     bool
+	showVersion  bool
+	listPortals  bool
+	serveMode    bool
+	firmwareMode bool
+	verbose      bool
+	cFile        string
 )
 
 func initFlags() {
-	flag.StringVar(&conf.vendor, "v", defaultVendor, "The vendor of the portal that will be connected to.")
+	flag.StringVar(&conf.vendor, "v", defaultVendor, "The vendor of the portal that will be connected to. Pass an empty vendor and device to auto-detect the portal.")
 	flag.StringVar(&conf.device, "d", defaultDevice, "The NFC portal to connect to.")
+	flag.StringVar(&conf.serial, "s", "", "The serial number of the NFC portal to connect to when multiple portals of the same type are connected.")
+	flag.StringVar(&conf.port, "port", "", "The serial port of NFC portals connected over a UART such as the PN532.")
+	flag.IntVar(&conf.baud, "baud", 0, "The baud rate of the serial port. The driver default is used when zero.")
+	flag.StringVar(&conf.tokenDir, "t", "", "The directory the virtual portal watches for token files.")
+	flag.StringVar(&conf.listen, "listen", defaultListen, "The address the portal server listens on in serve mode.")
+	flag.StringVar(&conf.remote, "remote", "", "The address of an amiigo portal server to use instead of a local NFC portal.")
+	flag.StringVar(&conf.recordFile, "record", "", "Record all traffic with the NFC portal to the given file.")
 	flag.StringVar(&conf.logFile, "l", defaultLogFile, "Write logfile to the given path. Logs are discarded by default.")
 	flag.StringVar(&conf.retailKeyPath, "k", "", "Path to retail key for amiibo decryption/encryption")
 	flag.StringVar(&cFile, "c", "", "Read all settings from a config file. The config file will override any command line flags present.")
@@ -24,13 +35,24 @@ func initFlags() {
 
 	flag.BoolVar(&showHelp, "?", false, "Display usage information.")
 	flag.BoolVar(&showVersion, "version", false, "Display version info.")
+	flag.BoolVar(&listPortals, "list", false, "List all connected and supported NFC portals.")
 
 	flag.Usage = printUsage
 
-	flag.Parse()
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "serve" {
+		serveMode = true
+		args = args[1:]
+	} else if len(args) > 0 && args[0] == "firmware" {
+		firmwareMode = true
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
 }
 
 func printUsage() {
-	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", exe)
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s [serve | firmware [options] [image]]:\n", exe)
+	fmt.Fprintln(flag.CommandLine.Output(), "  serve\n    \tExpose the NFC portal on the network instead of starting the terminal interface.")
+	fmt.Fprintln(flag.CommandLine.Output(), "  firmware\n    \tDisplay the hardware info of the NFC portal. When a firmware image is given, flash it to the portal.")
 	flag.PrintDefaults()
 }
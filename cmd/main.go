@@ -1,7 +1,9 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"github.com/malc0mn/amiigo/nfcptl"
 	"log"
 	"os"
 	"path/filepath"
@@ -13,6 +15,8 @@ const (
 	errOpenConfig  = 102
 	errOpenLogFile = 103
 	errReadKey     = 104
+	errServe       = 105
+	errFirmware    = 106
 )
 
 var (
@@ -35,6 +39,14 @@ func main() {
 		os.Exit(ok)
 	}
 
+	if listPortals {
+		if err := printPortals(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing NFC portals - %s\n", err)
+			os.Exit(errGeneral)
+		}
+		os.Exit(ok)
+	}
+
 	if cFile != "" {
 		if err := loadConfig(cFile, conf); err != nil {
 			fmt.Fprintf(os.Stderr, "Error opening config file - %s\n", err)
@@ -42,6 +54,14 @@ func main() {
 		}
 	}
 
+	if token := os.Getenv(tokenEnv); token != "" {
+		conf.token = token
+	}
+
+	if conf.remote != "" {
+		conf.vendor, conf.device = nfcptl.VendorRemote, nfcptl.ProductServer
+	}
+
 	if key, err := loadRetailKey(conf.retailKeyPath); err != nil {
 		fmt.Fprint(os.Stderr, err)
 		os.Exit(errReadKey)
@@ -57,6 +77,22 @@ func main() {
 	defer f.Close()
 	log.SetOutput(f)
 
+	if serveMode {
+		if err := serve(conf); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving NFC portal - %s\n", err)
+			os.Exit(errServe)
+		}
+		os.Exit(ok)
+	}
+
+	if firmwareMode {
+		if err := firmware(conf, flag.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error updating firmware - %s\n", err)
+			os.Exit(errFirmware)
+		}
+		os.Exit(ok)
+	}
+
 	if err := createCacheDirs(conf.cacheDir); err != nil {
 		fmt.Printf("Error creating caching directories: %s\n", err)
 		os.Exit(errGeneral)
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/malc0mn/amiigo/amiibo"
 	"github.com/malc0mn/amiigo/nfcptl"
+	"log"
+	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -11,41 +15,95 @@ import (
 // portal holds the parts that need to be controlled by the NFC portal.
 type portal struct {
 	client *nfcptl.Client
-	evt    chan struct{} // Event channel used to re-init the portal.
-	log    chan<- []byte // Logger channel.
-	amb    chan<- *amb   // Channel to send last read amiibo to.
-	tkn    bool          // Boolean to indicate a token is placed on the portal.
-	con    bool          // Boolean to indicate the portal is connected.
+	evt    chan struct{}   // Event channel used to re-init the portal.
+	log    chan<- []byte   // Logger channel.
+	amb    chan<- *amb     // Channel to send last read amiibo to.
+	tkn    bool            // Boolean to indicate a token is placed on the portal.
+	con    bool            // Boolean to indicate the portal is connected.
+	tag    *amiibo.NTAG215 // Data of the token placed on the portal, used to check writes.
 
 	sync.Mutex
 }
 
-// connect will block until a successful connection is established to the USB NFC portal.
+// configureClient passes the portal options from the config to the driver of the given client. No
+// error is returned when no options have been configured.
+func configureClient(c *nfcptl.Client, conf *config) error {
+	opts := conf.portal.options()
+	if len(opts) == 0 {
+		return nil
+	}
+
+	return c.Configure(opts...)
+}
+
+// connect will block until a successful connection is established to the NFC portal or until quit
+// is closed.
 func (p *portal) connect(quit <-chan struct{}) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-	output := false
+	connected := connectClient(p.client, quit, func() {
+		p.log <- encodeStringCell("Please connect your amiibo NFC portal to a USB port.")
+	})
+	if connected {
+		p.connected(true)
+		p.log <- encodeStringCell(fmt.Sprintf("Successfully connected to NFC portal %04x:%04x.", p.client.VendorId(), p.client.ProductId()))
+	}
+}
+
+// connectClient will block until the given client is connected, in which case it returns true, or
+// until quit is closed. A connection is attempted right away and each time a supported device is
+// attached to a USB port. A portal Watch cannot observe, such as a remote portal or a portal
+// connected over a serial port, is retried every few seconds as well. The waiting function is
+// called once when the first attempt failed.
+func connectClient(c *nfcptl.Client, quit <-chan struct{}, waiting func()) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	devices := nfcptl.Watch(ctx, time.Second)
+
+	var retry <-chan time.Time
+	if !watchable(c) {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		retry = ticker.C
+	}
 
+	output := false
 	for {
-		select {
-		case <-quit:
-			return
+		err := c.Connect()
+		if err == nil {
+			return true
+		}
+		log.Printf("Connecting to NFC portal failed: %s", err)
+		if !output {
+			waiting()
+			output = true
+		}
 
-		case <-ticker.C:
-			err := p.client.Connect()
-			if err == nil {
-				p.connected(true)
-				p.log <- encodeStringCell(fmt.Sprintf("Successfully connected to NFC portal %04x:%04x.", p.client.VendorId(), p.client.ProductId()))
-				return
-			}
-			if !output {
-				p.log <- encodeStringCell("Please connect your amiibo NFC portal to a USB port.")
-				output = true
+	wait:
+		for {
+			select {
+			case <-quit:
+				return false
+			case <-retry:
+				break wait
+			case e := <-devices:
+				if e.Name() == nfcptl.DeviceArrived {
+					break wait
+				}
 			}
 		}
 	}
 }
 
+// watchable returns true when Watch observes the portal of the given client being attached, which
+// is only the case for drivers talking to the portal over USB directly.
+func watchable(c *nfcptl.Client) bool {
+	pd, ok := c.Driver().(nfcptl.ProtocolDriver)
+	if !ok {
+		return false
+	}
+	_, ok = pd.Protocol().(*nfcptl.USB)
+	return ok
+}
+
 // listen is the main hardware loop that handles all portal related things from connection over
 // handling its events to cleanly disconnecting on shutdown.
 func (p *portal) listen(conf *config) {
@@ -53,11 +111,36 @@ func (p *portal) listen(conf *config) {
 	defer conf.wg.Done()
 
 	var err error
-	p.client, err = nfcptl.NewClient(conf.vendor, conf.device, verbose)
+	p.client, err = nfcptl.NewClientForDevice(nfcptl.DeviceInfo{Vendor: conf.vendor, Product: conf.device, Serial: conf.serial}, verbose)
 	if err != nil {
 		p.log <- encodeStringCell(fmt.Sprintf("Error initialising client: %s\n", err))
 		return
 	}
+	p.client.SetSerial(nfcptl.Serial{Port: conf.port, Baud: conf.baud})
+	if err := configureClient(p.client, conf); err != nil {
+		p.log <- encodeStringCellWarning(fmt.Sprintf("Ignoring portal options: %s", err))
+	}
+	if verbose {
+		events, _ := p.client.Subscribe()
+		go logEvents(events)
+	}
+	if v, ok := p.client.Driver().(*nfcptl.Virtual); ok {
+		v.WatchDir(conf.tokenDir)
+	}
+	if r, ok := p.client.Driver().(*nfcptl.Remote); ok {
+		r.SetServer(conf.remote, conf.token)
+	}
+	if conf.recordFile != "" {
+		f, err := os.OpenFile(conf.recordFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			p.log <- encodeStringCell(fmt.Sprintf("Error opening recording file: %s\n", err))
+			return
+		}
+		defer f.Close()
+		if err := p.client.Record(f); err != nil {
+			p.log <- encodeStringCell(fmt.Sprintf("Error recording portal traffic: %s\n", err))
+		}
+	}
 
 	p.connect(conf.quit)
 
@@ -65,11 +148,34 @@ func (p *portal) listen(conf *config) {
 	//p.client.SendCommand(nfcptl.Command{Command: nfcptl.GetDeviceName})
 	//p.client.SendCommand(nfcptl.Command{Command: nfcptl.GetHardwareInfo})
 
+	var sig, version []byte // Originality signature and GET_VERSION response of the token on the portal.
 	for {
 		select {
 		case e := <-p.client.Events():
-			p.log <- encodeStringCell(fmt.Sprintf("Received event: %s", e.String()))
+			if e.Name() != nfcptl.TokenTagWriteProgress {
+				p.log <- encodeStringCell(fmt.Sprintf("Received event: %s", e.String()))
+			}
 			switch e.Name() {
+			case nfcptl.TokenTagWriteProgress:
+				if pl, ok := e.Payload().(nfcptl.WriteProgressEvent); ok {
+					p.writeProgress(pl)
+				}
+			case nfcptl.TokenTagWriteError:
+				if pl, ok := e.Payload().(nfcptl.WriteErrorEvent); ok && pl.Page >= 0 {
+					p.log <- encodeStringCellWarning(fmt.Sprintf("Write FAILED at page %#02x: %v", pl.Page, pl.Err))
+				}
+			case nfcptl.TokenTagWriteLocked:
+				if pl, ok := e.Payload().(nfcptl.PagesEvent); ok {
+					p.log <- encodeStringCellWarning(fmt.Sprintf("Write refused by the portal, locked pages would have to change: %s", formatPages(pl.Pages)))
+				}
+			case nfcptl.TokenTagVerifyError:
+				if pl, ok := e.Payload().(nfcptl.PagesEvent); ok {
+					p.log <- encodeStringCellWarning(fmt.Sprintf("Write verification FAILED, pages differ from the dump: %s", formatPages(pl.Pages)))
+				}
+			case nfcptl.TokenTagVersion:
+				version = e.Data()
+			case nfcptl.TokenTagSignature:
+				sig = e.Data()
 			case nfcptl.TokenTagData:
 				p.tokenState(true)
 				a, err := amiibo.NewAmiibo(e.Data(), nil)
@@ -77,14 +183,30 @@ func (p *portal) listen(conf *config) {
 					p.log <- encodeStringCell(err.Error())
 					break
 				}
+				p.setTag(&a.NTAG215)
+
+				// Keep the chip identity so it ends up in the dump when saving.
+				a.SetVersion(version)
+				a.SetSignature(sig)
+				if sig != nil {
+					if a.VerifySignature() {
+						p.log <- encodeStringCell("Token signature verified: genuine NXP tag")
+					} else {
+						p.log <- encodeStringCell("Token signature invalid: this is NOT a genuine NXP tag!")
+					}
+				}
 
 				// Send amiibo to receiver
 				p.amb <- newAmiibo(a, true)
 
 				p.log <- encodeStringCell("NFC portal ready")
 			case nfcptl.TokenRemoved:
+				sig, version = nil, nil
 				p.tokenState(false)
+				p.setTag(nil)
 				p.amb <- &amb{nfc: true} // Signal token removal from NFC portal.
+			case nfcptl.DeviceLeft:
+				p.log <- encodeStringCellWarning("NFC portal unplugged!")
 			case nfcptl.Disconnect:
 				p.connected(false)
 				p.log <- encodeStringCell("NFC portal disconnected!")
@@ -100,10 +222,13 @@ func (p *portal) listen(conf *config) {
 }
 
 // write sends amiibo data to the NFC portal which will then write it to the token placed on the
-// portal. If user is true, then only the user data of the amiibo tag will be written,
-// corresponding with 'restoring a backup' in the original software. Pass false to write the full
-// amiibo data.
-func (p *portal) write(data []byte, user bool) {
+// portal. The mode is one of the nfcptl write modes: nfcptl.WriteUserData only writes the user data
+// of the amiibo tag, corresponding with 'restoring a backup' in the original software, while
+// nfcptl.WriteFull writes the full amiibo data. Add nfcptl.WriteSmart to only write the pages that
+// differ from the data on the token. The write is always verified by the portal. Writes that would
+// have to change locked pages of the token are refused before sending anything to the portal.
+// With nfcptl.WriteProvision, the amiibo data is first prepared for the blank token on the portal.
+func (p *portal) write(data []byte, mode byte) {
 	if !p.isConnected() {
 		p.log <- encodeStringCell("Cannot write: connect an NFC portal first!")
 		return
@@ -114,16 +239,98 @@ func (p *portal) write(data []byte, user bool) {
 		return
 	}
 
-	typ := []byte{0x00}
+	if mode&nfcptl.WriteProvision != 0 {
+		if data = p.provision(data); data == nil {
+			return
+		}
+	}
+
+	if tag := p.token(); tag != nil {
+		if err := tag.CheckWrite(data, mode&nfcptl.WriteUserData != 0); err != nil {
+			p.log <- encodeStringCellWarning("Refusing to write: " + err.Error())
+			return
+		}
+	}
+
 	msg := "full amiibo"
-	if user {
-		typ = []byte{0x01}
+	if mode&nfcptl.WriteUserData != 0 {
 		msg = "amiibo user"
 	}
+	if mode&nfcptl.WriteProvision != 0 {
+		msg = "provisioned amiibo"
+	}
+	if mode&nfcptl.WriteSmart != 0 {
+		msg = "changed " + msg
+	}
 	p.log <- encodeStringCell("Writing " + msg + " data to token")
 
 	p.log <- encodeStringCell("Sending amiibo data to NFC portal")
-	p.client.SendCommand(nfcptl.Command{Command: nfcptl.WriteTokenData, Arguments: append(typ, data...)})
+	mode |= nfcptl.WriteVerify
+	p.client.SendCommand(nfcptl.Command{Command: nfcptl.WriteTokenData, Arguments: append([]byte{mode}, data...)})
+}
+
+// provision prepares the given amiibo data for the blank token on the portal using the retail key:
+// the UID of the token is taken over and the amiibo is signed again. It returns nil when this is
+// not possible.
+func (p *portal) provision(data []byte) []byte {
+	if conf.retailKey == nil {
+		p.log <- encodeStringCellWarning("Cannot provision: no retail key loaded")
+		return nil
+	}
+
+	tag := p.token()
+	if tag == nil {
+		p.log <- encodeStringCellWarning("Cannot provision: the token on the NFC portal could not be read")
+		return nil
+	}
+
+	a, err := amiibo.NewAmiibo(data, nil)
+	if err == nil {
+		a, err = amiibo.Provision(conf.retailKey, a, tag)
+	}
+	if err != nil {
+		p.log <- encodeStringCellWarning("Cannot provision: " + err.Error())
+		return nil
+	}
+
+	return a.Raw()
+}
+
+// writeProgress logs the progress of a write in steps of 10% to keep the log readable. Retries are
+// always logged so that a slow write can be told apart from a hung one.
+func (p *portal) writeProgress(e nfcptl.WriteProgressEvent) {
+	if e.Total == 0 {
+		return
+	}
+
+	if e.Retries > 0 {
+		p.log <- encodeStringCellWarning(fmt.Sprintf("Retrying write of page %#02x (retry %d)", e.Page, e.Retries))
+		return
+	}
+	if pct := e.Position * 100 / e.Total; e.Position == e.Total || pct/10 != (e.Position-1)*100/e.Total/10 {
+		p.log <- encodeStringCell(fmt.Sprintf("Writing token: %d%% (%d of %d pages)", pct, e.Position, e.Total))
+	}
+}
+
+// logEvents writes all events received on the given channel to the log file, including the time
+// they were published and the command that caused them. It returns when the channel is closed.
+func logEvents(events <-chan *nfcptl.Event) {
+	for e := range events {
+		if cmd, ok := e.Command(); ok {
+			log.Printf("event %s at %s in reply to %s: % x", e, e.Time().Format(time.StampMilli), cmd, e.Data())
+		} else {
+			log.Printf("event %s at %s: % x", e, e.Time().Format(time.StampMilli), e.Data())
+		}
+	}
+}
+
+// formatPages formats the given page numbers as space separated hex values.
+func formatPages(pages []int) string {
+	s := make([]string, len(pages))
+	for i, page := range pages {
+		s[i] = fmt.Sprintf("%02x", page)
+	}
+	return strings.Join(s, " ")
 }
 
 // reInit signals the receiver that the portal needs to be re-initialized due to a disconnect. The
@@ -151,6 +358,22 @@ func (p *portal) tokenPlaced() bool {
 	return tkn
 }
 
+// setTag stores the data read from the token on the portal in a thread safe way.
+func (p *portal) setTag(tag *amiibo.NTAG215) {
+	p.Lock()
+	p.tag = tag
+	p.Unlock()
+}
+
+// token returns the data read from the token on the portal, nil when no token has been read.
+func (p *portal) token() *amiibo.NTAG215 {
+	p.Lock()
+	tag := p.tag
+	p.Unlock()
+
+	return tag
+}
+
 // tokenState updates the token status in a thread safe way. True means a token is present on the
 // portal.
 func (p *portal) connected(con bool) {
@@ -168,6 +391,25 @@ func (p *portal) isConnected() bool {
 	return con
 }
 
+// printPortals prints all connected NFC portals that are supported by amiigo to stdout.
+func printPortals() error {
+	devs, err := nfcptl.ListDevices()
+	if err != nil {
+		return err
+	}
+
+	if len(devs) == 0 {
+		fmt.Println("No supported NFC portals found.")
+		return nil
+	}
+
+	for _, d := range devs {
+		fmt.Println(d)
+	}
+
+	return nil
+}
+
 // newPortal returns a new portal ready for use.
 func newPortal(log chan<- []byte, amiiboChan chan<- *amb) *portal {
 	return &portal{
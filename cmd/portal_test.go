@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/malc0mn/amiigo/nfcptl"
+	"testing"
+)
+
+func TestWatchable(t *testing.T) {
+	tests := []struct {
+		vendor  string
+		product string
+		want    bool
+	}{
+		{nfcptl.VendorDatelElextronicsLtd, nfcptl.ProductPowerSavesForAmiibo, true},
+		{nfcptl.VendorAdvancedCardSystems, nfcptl.ProductACR122U, true},
+		{nfcptl.VendorNXPSemiconductors, nfcptl.ProductPN532, false},
+		{nfcptl.VendorSiliconLabs, nfcptl.ProductN2EliteUSB, false},
+		{nfcptl.VendorRemote, nfcptl.ProductServer, false},
+		{nfcptl.VendorVirtual, nfcptl.ProductEmulator, false},
+	}
+
+	for _, test := range tests {
+		c, err := nfcptl.NewClient(test.vendor, test.product, false)
+		if err != nil {
+			t.Fatalf("nfcptl.NewClient() error = %s; want nil", err)
+		}
+		if got := watchable(c); got != test.want {
+			t.Errorf("watchable() = %v for %s/%s; want %v", got, test.vendor, test.product, test.want)
+		}
+	}
+}
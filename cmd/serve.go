@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/malc0mn/amiigo/nfcptl"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// serveTokenFile is the file in the cache dir holding the auth token generated in serve mode.
+const serveTokenFile = "serve_token"
+
+// serve exposes the NFC portal on the network so that it can be used by amiigo instances on other
+// machines through the -remote flag. It blocks until interrupted. When the portal disconnects, the
+// server stops listening until the portal is connected again.
+func serve(conf *config) error {
+	if conf.token == "" {
+		if err := createCacheDirs(conf.cacheDir); err != nil {
+			return err
+		}
+		file := path.Join(cacheBase, serveTokenFile)
+		token, err := loadToken(file)
+		if err != nil {
+			return err
+		}
+		conf.token = token
+		fmt.Printf("Using the auth token stored in %s\n", file)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	quit := make(chan struct{})
+	go func() {
+		<-sig
+		close(quit)
+	}()
+
+	for {
+		c, err := nfcptl.NewClientForDevice(nfcptl.DeviceInfo{Vendor: conf.vendor, Product: conf.device, Serial: conf.serial}, verbose)
+		if err != nil {
+			return err
+		}
+		c.SetSerial(nfcptl.Serial{Port: conf.port, Baud: conf.baud})
+		if err := configureClient(c, conf); err != nil {
+			fmt.Printf("Ignoring portal options: %s\n", err)
+		}
+		if v, ok := c.Driver().(*nfcptl.Virtual); ok {
+			v.WatchDir(conf.tokenDir)
+		}
+
+		if !connectClient(c, quit, func() { fmt.Println("Please connect your amiibo NFC portal to a USB port.") }) {
+			return nil
+		}
+
+		if err := serveClient(c, conf, quit); err != nil {
+			return err
+		}
+
+		select {
+		case <-quit:
+			return nil
+		default:
+			fmt.Println("NFC portal disconnected!")
+		}
+	}
+}
+
+// serveClient exposes the given connected client on the network until the client disconnects or
+// quit is closed, in which case the client is disconnected.
+func serveClient(c *nfcptl.Client, conf *config, quit <-chan struct{}) error {
+	// The events channel is closed once the client has fully shut down.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range c.Events() {
+			if verbose {
+				log.Printf("event %s: % x", e, e.Data())
+			}
+		}
+	}()
+
+	s, err := nfcptl.NewServer(c, conf.token)
+	if err != nil {
+		c.Disconnect()
+		return err
+	}
+	l, err := net.Listen("tcp", conf.listen)
+	if err != nil {
+		c.Disconnect()
+		return err
+	}
+	defer l.Close()
+	go s.Serve(l)
+	fmt.Printf("Serving NFC portal %04x:%04x on %s\n", c.VendorId(), c.ProductId(), l.Addr())
+
+	select {
+	case <-done:
+	case <-quit:
+		c.Disconnect()
+		<-done
+	}
+
+	return nil
+}
+
+// loadToken returns the auth token stored in the given file. When the file does not exist, a
+// random token is generated and stored in it, readable by the current user only.
+func loadToken(file string) (string, error) {
+	b, err := os.ReadFile(file)
+	if err == nil {
+		if token := strings.TrimSpace(string(b)); token != "" {
+			return token, nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("reading auth token: %w", err)
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(file, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("storing auth token: %w", err)
+	}
+
+	return token, nil
+}
+
+// generateToken returns a random auth token.
+func generateToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating auth token: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadToken(t *testing.T) {
+	file := filepath.Join(t.TempDir(), serveTokenFile)
+
+	token, err := loadToken(file)
+	if err != nil {
+		t.Fatalf("loadToken() error = %s; want nil", err)
+	}
+	if len(token) != 32 {
+		t.Errorf("len(token) = %d; want 32", len(token))
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("os.Stat() error = %s; want nil", err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("fi.Mode().Perm() = %o; want 600", perm)
+	}
+
+	got, err := loadToken(file)
+	if err != nil {
+		t.Fatalf("loadToken() error = %s; want nil", err)
+	}
+	if got != token {
+		t.Errorf("loadToken() = %s; want %s", got, token)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/gdamore/tcell/v2"
+	"github.com/malc0mn/amiigo/nfcptl"
 	"sync"
 	"time"
 )
@@ -183,9 +184,15 @@ func newUi(invertImage bool) *ui {
 	hex := newTextModal(s, boxOpts{title: "view dump as hex", key: 'h', xPos: -1, yPos: -1, width: 84, height: 36, typ: boxTypeCharacter, needAmiibo: true, scroll: true}, logs.content)
 	write := newOptionsModal(
 		s,
-		boxOpts{title: "write amiibo data to token", key: 'w', xPos: -1, yPos: -1, width: 80, height: 9, typ: boxTypeCharacter, needAmiibo: true},
+		boxOpts{title: "write amiibo data to token", key: 'w', xPos: -1, yPos: -1, width: 80, height: 15, typ: boxTypeCharacter, needAmiibo: true},
 		logs.content,
-		[]mopts{{'f', "write full amiibo to token", 0}, {'u', "only write userdata to token (aka 'restore backup')", 1}},
+		[]mopts{
+			{'f', "write full amiibo to token", nfcptl.WriteFull},
+			{'u', "only write userdata to token (aka 'restore backup')", nfcptl.WriteUserData},
+			{'c', "only write changed pages of the full amiibo to token", nfcptl.WriteFull | nfcptl.WriteSmart},
+			{'s', "smart 'restore backup': only write changed userdata pages", nfcptl.WriteUserData | nfcptl.WriteSmart},
+			{'p', "provision a blank tag: full amiibo including password and lock bytes", nfcptl.WriteProvision},
+		},
 		prepData,
 		u.write,
 	)
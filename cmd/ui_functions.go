@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/malc0mn/amiigo/amiibo"
 	"github.com/malc0mn/amiigo/apii"
+	"github.com/malc0mn/amiigo/nfcptl"
 	"os"
 	"path"
 	"path/filepath"
@@ -134,7 +135,12 @@ func saveDump(filename string, amb *amb, log chan<- []byte) bool {
 	}
 
 	log <- encodeStringCell(fmt.Sprintf("Writing amiibo to file '%s'", dest))
-	if err := os.WriteFile(filename, amb.a.Raw(), 0644); err != nil {
+	data := amb.a.Raw()
+	if a, ok := amb.a.(*amiibo.Amiibo); ok {
+		// Keep the signature and version when known.
+		data = a.Extended()
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
 		log <- encodeStringCell(fmt.Sprintf("Error writing file: %s", err))
 		return false
 	}
@@ -146,7 +152,7 @@ func saveDump(filename string, amb *amb, log chan<- []byte) bool {
 // prepData gets the amiibo data in the correct format for writing to the NFC portal.
 // The returned byte array has the following structure:
 //   - the first 8 bytes are the amiibo ID to be written
-//   - the 9th byte is 0 for a full write and 1 to write only user data
+//   - the 9th byte is the nfcptl write mode, e.g. nfcptl.WriteFull or nfcptl.WriteUserData
 //   - the rest of the bytes, 540 in total, is the amiibo data to be written
 func prepData(value int, amb *amb, log chan<- []byte) []byte {
 	if amb == nil || amb.a == nil {
@@ -154,7 +160,8 @@ func prepData(value int, amb *amb, log chan<- []byte) []byte {
 		return nil
 	}
 
-	if amb.dec {
+	// Provisioning signs and encrypts the amiibo again, so decrypted amiibo are fine.
+	if amb.dec && value&nfcptl.WriteProvision == 0 {
 		if !conf.expertMode {
 			log <- encodeStringCellWarning("Refusing to write: decrypted amiibo!")
 			return nil
@@ -185,7 +192,8 @@ func writeToken(data []byte, nfcId []byte, ptl *portal, log chan<- []byte) {
 		return
 	}
 
-	user := data[8] == 1
+	mode := data[8]
+	user := mode&nfcptl.WriteUserData != 0
 
 	if user && nfcId != nil && !bytes.Equal(data[:8], nfcId) {
 		if !conf.expertMode {
@@ -195,7 +203,7 @@ func writeToken(data []byte, nfcId []byte, ptl *portal, log chan<- []byte) {
 		log <- encodeStringCellWarning("WARNING: writing user data with mismatching amiibo ID!")
 	}
 
-	ptl.write(data[9:], user)
+	ptl.write(data[9:], mode)
 }
 
 // decrypt decrypts the given amiibo and returns a new amiibo.Amiidump instance.
@@ -0,0 +1,125 @@
+package nfcptl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// CCIDMessageType defines the bMessageType of a CCID bulk message as defined in the USB CCID
+// specification.
+type CCIDMessageType byte
+
+const (
+	// CCID_PcToRdrIccPowerOn activates the card in the slot and returns its ATR.
+	CCID_PcToRdrIccPowerOn CCIDMessageType = 0x62
+	// CCID_PcToRdrIccPowerOff deactivates the card in the slot.
+	CCID_PcToRdrIccPowerOff CCIDMessageType = 0x63
+	// CCID_PcToRdrGetSlotStatus returns the status of the slot.
+	CCID_PcToRdrGetSlotStatus CCIDMessageType = 0x65
+	// CCID_PcToRdrXfrBlock transfers an APDU to the card or to the reader itself in case of a
+	// pseudo-APDU.
+	CCID_PcToRdrXfrBlock CCIDMessageType = 0x6f
+
+	// CCID_RdrToPcDataBlock is the reply to CCID_PcToRdrIccPowerOn and CCID_PcToRdrXfrBlock.
+	CCID_RdrToPcDataBlock CCIDMessageType = 0x80
+	// CCID_RdrToPcSlotStatus is the reply to CCID_PcToRdrIccPowerOff and
+	// CCID_PcToRdrGetSlotStatus.
+	CCID_RdrToPcSlotStatus CCIDMessageType = 0x81
+)
+
+const (
+	// CCID_IccPresentActive is the ICC status when a card is present and powered on.
+	CCID_IccPresentActive = 0x00
+	// CCID_IccPresentInactive is the ICC status when a card is present but not powered on.
+	CCID_IccPresentInactive = 0x01
+	// CCID_IccNotPresent is the ICC status when no card is present.
+	CCID_IccNotPresent = 0x02
+
+	// CCID_CommandFailed is the command status when the reader failed to process the command.
+	CCID_CommandFailed = 0x01
+	// CCID_TimeExtension is the command status when the reader needs more time to process the
+	// command. The actual reply will follow.
+	CCID_TimeExtension = 0x02
+
+	// ccidHeaderSize is the size of the header of each CCID bulk message.
+	ccidHeaderSize = 10
+)
+
+var errCCIDShortMessage = errors.New("ccid: message too short")
+
+// ErrCCID defines the error structure returned when a CCID reader failed to process a command.
+type ErrCCID struct {
+	Type CCIDMessageType // Type holds the message type of the command that failed.
+	Code byte            // Code holds the bError field of the reply.
+}
+
+// Error implements the error interface
+func (e ErrCCID) Error() string {
+	return fmt.Sprintf("ccid: command %#02x failed with error %#02x", byte(e.Type), e.Code)
+}
+
+// ccidMessage describes a CCID bulk message. The three message specific header bytes are the
+// bStatus, bError and bChainParameter/bClockStatus fields for replies from the reader.
+type ccidMessage struct {
+	typ    CCIDMessageType
+	slot   byte
+	seq    byte
+	params [3]byte
+	data   []byte
+}
+
+// marshal returns the message as a byte array ready for sending.
+func (m *ccidMessage) marshal() []byte {
+	b := make([]byte, ccidHeaderSize, ccidHeaderSize+len(m.data))
+	b[0] = byte(m.typ)
+	binary.LittleEndian.PutUint32(b[1:5], uint32(len(m.data)))
+	b[5] = m.slot
+	b[6] = m.seq
+	copy(b[7:], m.params[:])
+
+	return append(b, m.data...)
+}
+
+// ccidMessageLength returns the total length of the message starting in b. It returns an error
+// when b does not hold a complete header.
+func ccidMessageLength(b []byte) (int, error) {
+	if len(b) < ccidHeaderSize {
+		return 0, errCCIDShortMessage
+	}
+
+	return ccidHeaderSize + int(binary.LittleEndian.Uint32(b[1:5])), nil
+}
+
+// unmarshalCCIDMessage parses the CCID message in b.
+func unmarshalCCIDMessage(b []byte) (*ccidMessage, error) {
+	l, err := ccidMessageLength(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < l {
+		return nil, errCCIDShortMessage
+	}
+
+	m := &ccidMessage{
+		typ:  CCIDMessageType(b[0]),
+		slot: b[5],
+		seq:  b[6],
+		data: append([]byte{}, b[ccidHeaderSize:l]...),
+	}
+	copy(m.params[:], b[7:10])
+
+	return m, nil
+}
+
+// iccStatus returns the ICC status of a reply: one of CCID_IccPresentActive,
+// CCID_IccPresentInactive or CCID_IccNotPresent.
+func (m *ccidMessage) iccStatus() byte {
+	return m.params[0] & 0x03
+}
+
+// commandStatus returns the command status of a reply: zero when the command was processed
+// without error, CCID_CommandFailed or CCID_TimeExtension.
+func (m *ccidMessage) commandStatus() byte {
+	return m.params[0] >> 6
+}
@@ -0,0 +1,58 @@
+package nfcptl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCcidMessage_Marshal(t *testing.T) {
+	m := &ccidMessage{typ: CCID_PcToRdrXfrBlock, seq: 0x05, data: []byte{0xff, 0xca, 0x00, 0x00, 0x00}}
+	got := m.marshal()
+	want := []byte{0x6f, 0x05, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0xff, 0xca, 0x00, 0x00, 0x00}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
+
+func TestUnmarshalCCIDMessage(t *testing.T) {
+	b := []byte{0x80, 0x02, 0x00, 0x00, 0x00, 0x00, 0x07, 0x41, 0xfe, 0x00, 0x90, 0x00}
+	m, err := unmarshalCCIDMessage(b)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	if m.typ != CCID_RdrToPcDataBlock {
+		t.Errorf("got %#02x, want %#02x", m.typ, CCID_RdrToPcDataBlock)
+	}
+	if m.seq != 0x07 {
+		t.Errorf("got %#02x, want 0x07", m.seq)
+	}
+	if m.iccStatus() != CCID_IccPresentInactive {
+		t.Errorf("got %d, want %d", m.iccStatus(), CCID_IccPresentInactive)
+	}
+	if m.commandStatus() != CCID_CommandFailed {
+		t.Errorf("got %d, want %d", m.commandStatus(), CCID_CommandFailed)
+	}
+	if !bytes.Equal(m.data, []byte{0x90, 0x00}) {
+		t.Errorf("got %x, want 9000", m.data)
+	}
+	if got := m.marshal(); !bytes.Equal(got, b) {
+		t.Errorf("got %x, want %x", got, b)
+	}
+
+	if _, err := unmarshalCCIDMessage(b[:11]); err != errCCIDShortMessage {
+		t.Errorf("got %v, want %s", err, errCCIDShortMessage)
+	}
+	if _, err := unmarshalCCIDMessage(b[:9]); err != errCCIDShortMessage {
+		t.Errorf("got %v, want %s", err, errCCIDShortMessage)
+	}
+}
+
+func TestErrCCID(t *testing.T) {
+	e := ErrCCID{Type: CCID_PcToRdrIccPowerOn, Code: 0xfe}
+	got := e.Error()
+	want := "ccid: command 0x62 failed with error 0xfe"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -1,44 +1,88 @@
 package nfcptl
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 )
 
+// ErrClientTerminated is returned when a command is sent to a client that has been disconnected.
+var ErrClientTerminated = errors.New("nfcptl: client has been terminated")
+
+// eventsBuffer is the number of events buffered on the Events channel. It holds all events of a
+// full token write, so that a consumer handling the events one by one does not miss any.
+const eventsBuffer = 256
+
 // Client allows easy communications with an NFC portal connected over USB.
 type Client struct {
 	va string // The vendor alias to use
 	pa string // The product alias to use
 
+	target DeviceInfo // Optionally targets a specific device by serial number or bus and address.
+	serial Serial     // Optionally overrides the serial port and baud rate of UART drivers.
+
 	driver Driver // The driver to use for communicating with the NFC portal
 
 	wg sync.WaitGroup // The client will use this to wait for the driver's goroutine(s) to finish.
 
 	debug bool // Will enable verbose logging
 
-	// TODO: do we need to use a context instead of a terminate channel?
-	terminate chan struct{} // A channel telling the Driver to terminate as soon as the channel is closed.
-	events    chan *Event   // Device events will be received on this channel.
-	commands  chan Command  // Command structs will be sent on this channel for the driver to act upon.
+	ctx    context.Context    // Cancelling this context tells the Driver to terminate.
+	cancel context.CancelFunc // Cancels ctx.
+
+	stopped  chan struct{} // Closed as soon as the client has fully shut down after a connection.
+	shutdown sync.Once     // Ensures the client is shut down only once.
+	lost     sync.Once     // Ensures DeviceLeft is sent only once.
+	err      error         // Holds the error returned by the driver on disconnect.
+
+	events   <-chan *Event // Device events will be received on this channel.
+	commands chan Command  // Command structs will be sent on this channel for the driver to act upon.
+
+	waitersMu sync.Mutex           // Protects waiters.
+	waiters   map[*waiter]struct{} // Synchronous requests waiting for a reply event.
+	requests  atomic.Uint64        // The last request number handed out to a synchronous request.
+
+	subsMu     sync.Mutex               // Protects subs and subsClosed.
+	subs       map[*subscriber]struct{} // Consumers that subscribed to the events.
+	subsClosed bool                     // Set when the client has shut down.
+
+	executingMu sync.Mutex // Protects executing.
+	executing   *Command   // The command the driver is executing, nil when idle.
 }
 
-// NewClient builds a new Client struct.
+// NewClient builds a new Client struct. When both vendor and device are empty strings, the
+// attached USB devices will be searched for a supported device using DetectDevice.
 func NewClient(vendor, device string, debug bool) (*Client, error) {
-	// TODO: add auto-detection when vendor and device are empty strings.
+	if vendor == "" && device == "" {
+		var err error
+		if vendor, device, err = DetectDevice(); err != nil {
+			return nil, err
+		}
+		if debug {
+			log.Printf("nfcptl: detected vendor %s and product %s", vendor, device)
+		}
+	}
+
 	d, err := GetDriverByVendorAndProductAlias(vendor, device)
 	if err != nil {
 		return nil, err
 	}
 
 	c := &Client{
-		va:        vendor,
-		pa:        device,
-		driver:    d,
-		debug:     debug,
-		terminate: make(chan struct{}),
-		events:    make(chan *Event, 10),
-		commands:  make(chan Command, 1),
+		va:       vendor,
+		pa:       device,
+		driver:   d.New(),
+		debug:    debug,
+		commands: make(chan Command, 1),
+		waiters:  make(map[*waiter]struct{}),
+		subs:     make(map[*subscriber]struct{}),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	// The Events channel is just another subscriber, so it never blocks the driver either.
+	c.events, _ = c.subscribe(eventsBuffer)
 
 	if c.Debug() {
 		log.Printf("nfcptl: using vendor ID %#04x and product ID %#04x", c.VendorId(), c.ProductId())
@@ -47,15 +91,95 @@ func NewClient(vendor, device string, debug bool) (*Client, error) {
 	return c, nil
 }
 
+// NewClientForDevice builds a new Client struct targeting a specific device. This allows multiple
+// devices of the same model to be used at the same time, each one with its own Client. The device
+// is selected by its serial number when DeviceInfo.Serial is set and by its bus and address when
+// DeviceInfo.Bus is not zero. Use ListDevices to find the attached devices.
+func NewClientForDevice(di DeviceInfo, debug bool) (*Client, error) {
+	c, err := NewClient(di.Vendor, di.Product, debug)
+	if err != nil {
+		return nil, err
+	}
+	c.target = di
+
+	if c.Debug() && (di.Serial != "" || di.Bus != 0) {
+		log.Printf("nfcptl: targeting device with serial %q on bus %d address %d", di.Serial, di.Bus, di.Address)
+	}
+
+	return c, nil
+}
+
+// Driver returns the driver the client is using. A type assertion can be used to access driver
+// specific features.
+func (c *Client) Driver() Driver {
+	return c.driver
+}
+
+// Record makes the client record all traffic between the driver and the device to w. It MUST be
+// called before connecting. ErrNoProtocol is returned when the driver does not communicate with
+// the device through a Protocol.
+func (c *Client) Record(w io.Writer) error {
+	pd, ok := c.driver.(ProtocolDriver)
+	if !ok {
+		return ErrNoProtocol
+	}
+
+	return pd.SetProtocol(NewRecorder(pd.Protocol(), w))
+}
+
+// Replay makes the driver talk to the given Replay instead of the device. It MUST be called before
+// connecting. ErrNoProtocol is returned when the driver does not communicate with the device
+// through a Protocol.
+func (c *Client) Replay(r *Replay) error {
+	pd, ok := c.driver.(ProtocolDriver)
+	if !ok {
+		return ErrNoProtocol
+	}
+
+	return pd.SetProtocol(r)
+}
+
+// SetSerial sets the serial port and baud rate to use for drivers talking to their device over a
+// UART, overriding the defaults of the driver. Empty or zero values keep the driver defaults. It
+// MUST be called before connecting.
+func (c *Client) SetSerial(s Serial) {
+	c.serial = s
+}
+
 // Setup returns the driver's setup struct. This will be protocol dependant: the protocol will
 // verify the setup struct and panic when it is not what it expects.
+// For UART drivers, the serial port and baud rate set using SetSerial are applied to the setup.
 func (c *Client) Setup() any {
-	return c.driver.Setup()
+	setup := c.driver.Setup()
+	if s, ok := setup.(Serial); ok {
+		if c.serial.Port != "" {
+			s.Port = c.serial.Port
+		}
+		if c.serial.Baud != 0 {
+			s.Baud = c.serial.Baud
+		}
+		return s
+	}
+
+	return setup
 }
 
 // Connect establishes a new connection to the device and opens up input and output endpoints.
+// It is equivalent to calling ConnectContext with context.Background().
 func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext establishes a new connection to the device and opens up input and output
+// endpoints. When the given context is cancelled or expires, the driver is terminated and the
+// device is disconnected exactly as if Disconnect had been called.
+func (c *Client) ConnectContext(ctx context.Context) error {
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
 	if err := c.driver.Connect(c); err != nil {
+		c.cancel()
+		// Free up whatever the driver managed to claim before failing.
+		c.driver.Disconnect()
 		return err
 	}
 
@@ -63,9 +187,35 @@ func (c *Client) Connect() error {
 	c.wg.Add(1)
 	go c.driver.Drive(c)
 
+	c.stopped = make(chan struct{})
+	go func() {
+		<-c.ctx.Done()
+		c.shutdown.Do(c.disconnect)
+		close(c.stopped)
+	}()
+
 	return nil
 }
 
+// DeviceLost MUST be called by drivers when IsDeviceGone returns true for an error returned by the
+// Protocol. It sends the DeviceLeft event and terminates the driver, after which the client shuts
+// down exactly as if Disconnect had been called. Unlike Disconnect, DeviceLost does not block, so
+// it is safe to call from the driver's goroutines.
+// This function is exposed to allow Driver implementations outside the nfcptl package.
+func (c *Client) DeviceLost(err error) {
+	if c.ctx.Err() != nil {
+		return
+	}
+
+	c.lost.Do(func() {
+		log.Printf("nfcptl: device is gone: %v", err)
+		di := c.Target()
+		di.VendorId, di.ProductId = c.VendorId(), c.ProductId()
+		c.PublishEvent(NewEvent(DeviceLeft, di.eventData()))
+		c.cancel()
+	})
+}
+
 // Done MUST be used by drivers to signal the end of the main goroutine which will allow the client
 // to ensure a clean shutdown.
 func (c *Client) Done() {
@@ -73,41 +223,66 @@ func (c *Client) Done() {
 }
 
 // Disconnect cleanly disconnects the client and frees up all resources. It also sends the
-// Disconnect event.
+// Disconnect event. Disconnect blocks until the driver has terminated.
 func (c *Client) Disconnect() error {
-	c.PublishEvent(NewEvent(Disconnect, []byte{}))
+	c.cancel()
+
+	if c.stopped != nil {
+		<-c.stopped
+	} else {
+		// We never connected, so there is no goroutine to do the work for us.
+		c.shutdown.Do(c.disconnect)
+	}
 
-	close(c.terminate)
+	return c.err
+}
+
+// disconnect sends the Disconnect event, waits for the driver's goroutines to finish and
+// disconnects the driver. It MUST only be called through c.shutdown.
+func (c *Client) disconnect() {
+	c.PublishEvent(NewEvent(Disconnect, []byte{}))
 
 	// Wait for a clean shutdown of the driver's goroutines.
 	c.wg.Wait()
 
-	if err := c.driver.Disconnect(); err != nil {
-		return err
-	}
-
-	close(c.events)
+	c.err = c.driver.Disconnect()
 
-	return nil
+	c.closeSubscribers()
 }
 
-// Events returns the read only events channel. The caller MUST use this channel to listen for
-// device events and act accordingly.
+// Events returns the read only events channel. The caller SHOULD use this channel to listen for
+// device events and act accordingly. The channel is closed once the client has fully shut down.
+// Just like a subscription, the channel never blocks the driver: when the consumer does not keep
+// up, the oldest buffered event is dropped, so events such as TokenTagData can be lost. The
+// DeviceLeft and Disconnect events are never dropped. Use Subscribe to have additional consumers
+// observe the events.
 func (c *Client) Events() <-chan *Event {
 	return c.events
 }
 
-// PublishEvent places an event on the event channel.
+// PublishEvent places an event on the event channel and hands it to all subscribers without
+// blocking. When the driver is executing a command, the event gets that command as its originating
+// command, see Executing. Events published after the client has shut down are dropped.
 // This function is exposed to allow Driver implementations outside the nfcptl package.
 func (c *Client) PublishEvent(e *Event) {
-	c.events <- e
+	if _, ok := e.Command(); !ok {
+		c.executingMu.Lock()
+		if c.executing != nil {
+			e.setRequest(*c.executing)
+		}
+		c.executingMu.Unlock()
+	}
+
+	c.notifyWaiters(e)
+	c.notifySubscribers(e)
 }
 
 // Terminate returns the termination channel which a Driver MUST use to cleanly terminate any
-// goroutines. The channel is closed by the Disconnect function signaling the listeners to halt.
+// goroutines. The channel is closed when the context passed to ConnectContext is done or by the
+// Disconnect function, signaling the listeners to halt.
 // This function is exposed to allow Driver implementations outside the nfcptl package.
 func (c *Client) Terminate() <-chan struct{} {
-	return c.terminate
+	return c.ctx.Done()
 }
 
 // Commands returns the read only commands channel. The Driver MUST use this channel to listen for
@@ -117,6 +292,25 @@ func (c *Client) Commands() <-chan Command {
 	return c.commands
 }
 
+// Executing MUST be called by drivers right before executing a command received on the Commands
+// channel, the returned function MUST be called when done executing it. All events published in
+// between get the command as their originating command. Typical use:
+//
+//	defer c.Executing(cmd)()
+//
+// This function is exposed to allow Driver implementations outside the nfcptl package.
+func (c *Client) Executing(cmd Command) func() {
+	c.executingMu.Lock()
+	c.executing = &cmd
+	c.executingMu.Unlock()
+
+	return func() {
+		c.executingMu.Lock()
+		c.executing = nil
+		c.executingMu.Unlock()
+	}
+}
+
 // SendCommand sends a ClientCommand to the internal commands channel. The Driver can act on
 // this command when implemented by publishing an Event.
 // When not implemented, the Driver should publish an error Event.
@@ -124,6 +318,31 @@ func (c *Client) SendCommand(cmd Command) {
 	c.commands <- cmd
 }
 
+// SendCommandContext sends a ClientCommand to the internal commands channel just like SendCommand
+// but gives up when the given context is done before the Driver accepts the command. The context
+// is attached to the command so that the Driver will not execute the command when the context is
+// done by the time the Driver gets to it. In both cases, a CommandCancelled event is published.
+func (c *Client) SendCommandContext(ctx context.Context, cmd Command) error {
+	cmd.ctx = ctx
+
+	select {
+	case c.commands <- cmd:
+		return nil
+	case <-ctx.Done():
+		// Select picks a random case when the client has been terminated as well. Nobody is
+		// waiting for the CommandCancelled event in that case.
+		if c.ctx.Err() != nil {
+			return ErrClientTerminated
+		}
+		e := NewEvent(CommandCancelled, []byte{byte(cmd.Command)})
+		e.setRequest(cmd)
+		c.PublishEvent(e)
+		return ctx.Err()
+	case <-c.Terminate():
+		return ErrClientTerminated
+	}
+}
+
 // VendorId returns the vendor ID the client is using.
 func (c *Client) VendorId() uint16 {
 	id, err := c.driver.VendorId(c.va)
@@ -142,6 +361,13 @@ func (c *Client) ProductId() uint16 {
 	return id
 }
 
+// Target returns the device the client should connect to. Fields that are not set must not be
+// used for device selection.
+// This function is exposed to allow Protocol implementations outside the nfcptl package.
+func (c *Client) Target() DeviceInfo {
+	return c.target
+}
+
 // Debug returns whether the client is running in debug mode.
 func (c *Client) Debug() bool {
 	return c.debug
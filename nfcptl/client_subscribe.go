@@ -0,0 +1,147 @@
+package nfcptl
+
+import (
+	"log"
+	"sync"
+)
+
+// subscriberBuffer is the number of events buffered for each subscriber.
+const subscriberBuffer = 32
+
+// lifecycleEvents holds the events that are never dropped when a subscriber does not keep up,
+// since consumers rely on them to learn that the device or the client is gone.
+var lifecycleEvents = map[EventType]bool{DeviceLeft: true, Disconnect: true}
+
+// subscriber receives a copy of all published events of the types it is interested in.
+type subscriber struct {
+	types   map[EventType]bool // All events are received when empty.
+	ch      chan *Event
+	dropped int // The number of events dropped because the subscriber did not keep up.
+}
+
+// Subscribe returns a channel receiving all events published by the client from now on, or only
+// the events of the given types when a filter is passed. This allows multiple consumers to observe
+// the same portal next to the consumer of the Events channel.
+// Just like the Events channel, a subscription never blocks the driver: each subscriber has its own
+// buffer and when a subscriber does not keep up, the oldest buffered event is dropped to make room
+// for the new one. The DeviceLeft and Disconnect events are never dropped. The channel is closed when the client shuts down or when the returned
+// unsubscribe function is called, which is safe to call more than once.
+func (c *Client) Subscribe(filter ...EventType) (<-chan *Event, func()) {
+	return c.subscribe(subscriberBuffer, filter...)
+}
+
+// subscribe implements Subscribe buffering the given number of events for the subscriber.
+func (c *Client) subscribe(size int, filter ...EventType) (<-chan *Event, func()) {
+	s := &subscriber{
+		types: make(map[EventType]bool),
+		ch:    make(chan *Event, size),
+	}
+	for _, t := range filter {
+		s.types[t] = true
+	}
+
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	if c.subsClosed {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	c.subs[s] = struct{}{}
+
+	var once sync.Once
+	return s.ch, func() {
+		once.Do(func() {
+			c.subsMu.Lock()
+			defer c.subsMu.Unlock()
+			if _, ok := c.subs[s]; ok {
+				delete(c.subs, s)
+				close(s.ch)
+			}
+		})
+	}
+}
+
+// notifySubscribers hands the event to all subscribers interested in its type without blocking.
+func (c *Client) notifySubscribers(e *Event) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	for s := range c.subs {
+		if len(s.types) > 0 && !s.types[e.Name()] {
+			continue
+		}
+
+		select {
+		case s.ch <- e:
+			continue
+		default:
+		}
+
+		if s.overflow(e) {
+			s.dropped++
+			if c.Debug() {
+				log.Printf("nfcptl: subscriber is not keeping up, %d events dropped", s.dropped)
+			}
+		}
+	}
+}
+
+// overflow sends the given event to the subscriber when its buffer is full by dropping the oldest
+// buffered event that is not a lifecycle event. When the buffer only holds lifecycle events, the
+// given event is dropped instead, unless it is a lifecycle event itself. Only one event is ever sent
+// at a time, so the buffered events can be put back in order, even when the subscriber was reading.
+// It returns true when an event has been dropped.
+func (s *subscriber) overflow(e *Event) bool {
+	var buf []*Event
+drain:
+	for {
+		select {
+		case b := <-s.ch:
+			buf = append(buf, b)
+		default:
+			break drain
+		}
+	}
+
+	dropped := len(buf) == cap(s.ch)
+	if dropped {
+		drop := -1
+		for i, b := range buf {
+			if !lifecycleEvents[b.Name()] {
+				drop = i
+				break
+			}
+		}
+		switch {
+		case drop >= 0:
+			buf = append(buf[:drop], buf[drop+1:]...)
+		case !lifecycleEvents[e.Name()]:
+			e = nil
+		default:
+			buf = buf[1:]
+		}
+	}
+
+	for _, b := range buf {
+		s.ch <- b
+	}
+	if e != nil {
+		s.ch <- e
+	}
+
+	return dropped
+}
+
+// closeSubscribers closes the channels of all subscribers. Subscribing afterwards returns a closed
+// channel.
+func (c *Client) closeSubscribers() {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	for s := range c.subs {
+		close(s.ch)
+		delete(c.subs, s)
+	}
+	c.subsClosed = true
+}
@@ -0,0 +1,166 @@
+package nfcptl
+
+import (
+	"testing"
+	"time"
+)
+
+// newSubscribeClient returns a connected Client using a testDriver. Events are drained in the
+// background.
+func newSubscribeClient(t *testing.T) *Client {
+	c, _ := newTestClient(t)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	go func() {
+		for range c.Events() {
+		}
+	}()
+	t.Cleanup(func() { c.Disconnect() })
+
+	return c
+}
+
+// expectSubscribed reads an event from the subscription and fails when it is not of the given type
+// or when the channel is closed.
+func expectSubscribed(t *testing.T, ch <-chan *Event, want EventType) *Event {
+	t.Helper()
+
+	select {
+	case e, ok := <-ch:
+		if !ok {
+			t.Fatalf("channel closed, want %s", want)
+		}
+		if e.Name() != want {
+			t.Fatalf("got %s, want %s", e, want)
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("got no event, want %s", want)
+	}
+
+	return nil
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	c := newSubscribeClient(t)
+
+	all, unsubAll := c.Subscribe()
+	tokens, unsubTokens := c.Subscribe(TokenDetected, TokenRemoved)
+
+	c.PublishEvent(NewEvent(TokenDetected, []byte{0x04}))
+	c.PublishEvent(NewEvent(FrontLedOn, nil))
+	c.PublishEvent(NewEvent(TokenRemoved, nil))
+
+	expectSubscribed(t, all, TokenDetected)
+	expectSubscribed(t, all, FrontLedOn)
+	expectSubscribed(t, all, TokenRemoved)
+	expectSubscribed(t, tokens, TokenDetected)
+	expectSubscribed(t, tokens, TokenRemoved)
+
+	unsubTokens()
+	unsubTokens()
+	if _, ok := <-tokens; ok {
+		t.Error("got an event, want the channel to be closed after unsubscribing")
+	}
+
+	c.PublishEvent(NewEvent(TokenDetected, []byte{0x04}))
+	expectSubscribed(t, all, TokenDetected)
+
+	c.Disconnect()
+	expectSubscribed(t, all, Disconnect)
+	if _, ok := <-all; ok {
+		t.Error("got an event, want the channel to be closed after disconnecting")
+	}
+	unsubAll()
+
+	late, unsubLate := c.Subscribe()
+	if _, ok := <-late; ok {
+		t.Error("got an event, want a closed channel when subscribing to a terminated client")
+	}
+	unsubLate()
+}
+
+func TestClient_SubscribeDropsOldest(t *testing.T) {
+	c := newSubscribeClient(t)
+
+	ch, unsub := c.Subscribe(TokenTagWriteProgress)
+	defer unsub()
+
+	// Publishing must not block although nobody is reading the subscription.
+	total := subscriberBuffer + 2
+	for i := 0; i < total; i++ {
+		c.PublishEvent(NewEvent(TokenTagWriteProgress, []byte{byte(i), byte(i + 1), byte(total), 0x00}))
+	}
+
+	for i := 2; i < total; i++ {
+		e := expectSubscribed(t, ch, TokenTagWriteProgress)
+		if got := int(e.Data()[0]); got != i {
+			t.Fatalf("got page %d, want %d", got, i)
+		}
+	}
+}
+
+func TestClient_SubscribeKeepsLifecycleEvents(t *testing.T) {
+	c := newSubscribeClient(t)
+
+	ch, unsub := c.Subscribe()
+	defer unsub()
+
+	c.PublishEvent(NewEvent(TokenTagData, nil))
+	c.PublishEvent(NewEvent(DeviceLeft, nil))
+	for i := 0; i < subscriberBuffer; i++ {
+		c.PublishEvent(NewEvent(TokenTagWriteProgress, []byte{byte(i), byte(i + 1), subscriberBuffer, 0x00}))
+	}
+	c.PublishEvent(NewEvent(Disconnect, nil))
+
+	// TokenTagData and the two oldest progress events made room, DeviceLeft is kept in order.
+	expectSubscribed(t, ch, DeviceLeft)
+	for i := 2; i < subscriberBuffer; i++ {
+		e := expectSubscribed(t, ch, TokenTagWriteProgress)
+		if got := int(e.Data()[0]); got != i {
+			t.Fatalf("got page %d, want %d", got, i)
+		}
+	}
+	expectSubscribed(t, ch, Disconnect)
+
+	// A buffer holding only lifecycle events drops new events that are not.
+	for i := 0; i < subscriberBuffer; i++ {
+		c.PublishEvent(NewEvent(DeviceLeft, nil))
+	}
+	c.PublishEvent(NewEvent(TokenTagData, nil))
+	for i := 0; i < subscriberBuffer; i++ {
+		expectSubscribed(t, ch, DeviceLeft)
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("got %s, want no event", e)
+	default:
+	}
+}
+
+func TestClient_EventsDropsOldest(t *testing.T) {
+	c, _ := newTestClient(t)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	// Publishing must not block although nobody is reading the Events channel.
+	total := eventsBuffer + 2
+	for i := 0; i < total; i++ {
+		c.PublishEvent(NewEvent(TokenTagWriteProgress, []byte{byte(i), byte(i + 1), byte(total), 0x00}))
+	}
+
+	for i := 2; i < total; i++ {
+		e := expectSubscribed(t, c.Events(), TokenTagWriteProgress)
+		if got := e.Data()[0]; got != byte(i) {
+			t.Fatalf("got page %d, want %d", got, byte(i))
+		}
+	}
+
+	c.Disconnect()
+	expectSubscribed(t, c.Events(), Disconnect)
+	if _, ok := <-c.Events(); ok {
+		t.Error("got an event, want the channel to be closed after disconnecting")
+	}
+}
@@ -0,0 +1,215 @@
+package nfcptl
+
+import (
+	"context"
+	"errors"
+)
+
+// waiter waits for the first event of a set of event types to be published in reply to a command.
+type waiter struct {
+	cmd   Command
+	types map[EventType]bool
+	ch    chan *Event
+}
+
+// ErrInvalidReply is returned by the synchronous Client methods when the driver replied to a command
+// with an event holding invalid data.
+var ErrInvalidReply = errors.New("nfcptl: invalid reply event")
+
+// failureEvents holds the events that make a request fail regardless of the command sent.
+var failureEvents = []EventType{Error, UnknownCommand, CommandCancelled}
+
+// ReadToken reads the token that is placed on the NFC portal and returns the token data. When the
+// token could not be read, an ErrCommandFailed error will be returned holding the
+// TokenTagDataError event which contains the partial token data.
+// The events are still published on the Events channel and to all subscribers.
+func (c *Client) ReadToken(ctx context.Context) ([]byte, error) {
+	e, err := c.request(ctx, Command{Command: FetchTokenData}, []EventType{TokenTagData}, TokenTagDataError)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Data(), nil
+}
+
+// WriteToken writes the given amiibo data to the token placed on the NFC portal using the given
+// write mode, being WriteFull, WriteUserData or WriteProvision, optionally OR-ed with WriteSmart
+// and WriteVerify. See the WriteTokenData command for details. It returns when the write has
+// finished or failed, in which case an ErrCommandFailed error will be returned. A write that could
+// not be read back or verified has failed.
+// The events are still published on the Events channel and to all subscribers.
+func (c *Client) WriteToken(ctx context.Context, data []byte, mode byte) error {
+	_, err := c.request(
+		ctx,
+		Command{Command: WriteTokenData, Arguments: append([]byte{mode}, data...)},
+		[]EventType{TokenTagWriteFinish},
+		TokenTagWriteError, TokenTagWriteLocked, TokenTagDataSizeError, TokenTagVerifyError, TokenTagDataError,
+	)
+
+	return err
+}
+
+// DeviceName returns the data of the DeviceName event in reply to the GetDeviceName command.
+// The events are still published on the Events channel and to all subscribers.
+func (c *Client) DeviceName(ctx context.Context) ([]byte, error) {
+	e, err := c.request(ctx, Command{Command: GetDeviceName}, []EventType{DeviceName})
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Data(), nil
+}
+
+// HardwareInfo returns the data of the HardwareInfo event in reply to the GetHardwareInfo command.
+// The events are still published on the Events channel and to all subscribers.
+func (c *Client) HardwareInfo(ctx context.Context) ([]byte, error) {
+	e, err := c.request(ctx, Command{Command: GetHardwareInfo}, []EventType{HardwareInfo})
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Data(), nil
+}
+
+// EnterDfu puts the device in device firmware update mode. The client disconnects once the driver
+// has published the DfuMode event, after which OpenDFU can be used to flash the firmware.
+// The events are still published on the Events channel and to all subscribers.
+func (c *Client) EnterDfu(ctx context.Context) error {
+	_, err := c.request(ctx, Command{Command: EnterDfu}, []EventType{DfuMode})
+
+	return err
+}
+
+// ReadPages reads count pages starting from the given page of the NFC Forum Type 2 Tag placed on
+// the NFC portal and returns the data of the pages, four bytes per page. When the pages could not
+// be read, an ErrCommandFailed error will be returned holding the TokenTagPageError event.
+// The events are still published on the Events channel and to all subscribers.
+func (c *Client) ReadPages(ctx context.Context, page, count byte) ([]byte, error) {
+	e, err := c.request(ctx, Command{Command: ReadPages, Arguments: []byte{page, count}}, []EventType{TokenTagPages}, TokenTagPageError)
+	if err != nil {
+		return nil, err
+	}
+
+	p, ok := e.Payload().(TokenPagesEvent)
+	if !ok || p.Page != int(page) || len(p.Data) != int(count)*4 {
+		return nil, ErrInvalidReply
+	}
+	return p.Data, nil
+}
+
+// WritePage writes the given four bytes of data to the given page of the NFC Forum Type 2 Tag
+// placed on the NFC portal. When the page could not be written, an ErrCommandFailed error will be
+// returned holding the TokenTagPageError event.
+// The events are still published on the Events channel and to all subscribers.
+func (c *Client) WritePage(ctx context.Context, page byte, data []byte) error {
+	_, err := c.request(ctx, Command{Command: WritePage, Arguments: append([]byte{page}, data...)}, []EventType{TokenTagPageWritten}, TokenTagPageError)
+
+	return err
+}
+
+// PwdAuth authenticates to the NTAG21x token placed on the NFC portal using the given four byte
+// password and returns the two byte password acknowledge (PACK). When authentication failed, an
+// ErrCommandFailed error will be returned holding the TokenTagPageError event.
+// The events are still published on the Events channel and to all subscribers.
+func (c *Client) PwdAuth(ctx context.Context, pwd []byte) ([]byte, error) {
+	e, err := c.request(ctx, Command{Command: PwdAuth, Arguments: pwd}, []EventType{TokenTagAuthenticated}, TokenTagPageError)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Data(), nil
+}
+
+// request sends the given command to the driver and blocks until the driver publishes one of the
+// given success or failure events in reply to the command. Only events originating from the command
+// are considered, so events the driver publishes by itself, or for other commands, are ignored. A
+// failure event is returned as an ErrCommandFailed error, unless the command was unknown to the
+// driver or has been cancelled.
+func (c *Client) request(ctx context.Context, cmd Command, success []EventType, failure ...EventType) (*Event, error) {
+	cmd.req = c.requests.Add(1)
+	failure = append(failure, failureEvents...)
+	w := c.addWaiter(cmd, append(failure, success...))
+	defer c.removeWaiter(w)
+
+	if err := c.SendCommandContext(ctx, cmd); err != nil {
+		return nil, err
+	}
+
+	select {
+	case e := <-w.ch:
+		switch {
+		case e.Name() == UnknownCommand:
+			return nil, ErrUnsupportedCommand{Command: cmd.Command}
+		case e.Name() == CommandCancelled && ctx.Err() != nil:
+			return nil, ctx.Err()
+		case !isOneOf(e.Name(), success):
+			return e, &ErrCommandFailed{Command: cmd.Command, Event: e}
+		}
+		return e, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.Terminate():
+		return nil, ErrClientTerminated
+	}
+}
+
+// addWaiter registers a new waiter for the given event types in reply to the given command.
+func (c *Client) addWaiter(cmd Command, types []EventType) *waiter {
+	w := &waiter{
+		cmd:   cmd,
+		types: make(map[EventType]bool),
+		ch:    make(chan *Event, 1),
+	}
+	for _, t := range types {
+		w.types[t] = true
+	}
+
+	c.waitersMu.Lock()
+	c.waiters[w] = struct{}{}
+	c.waitersMu.Unlock()
+
+	return w
+}
+
+// removeWaiter unregisters the given waiter.
+func (c *Client) removeWaiter(w *waiter) {
+	c.waitersMu.Lock()
+	delete(c.waiters, w)
+	c.waitersMu.Unlock()
+}
+
+// notifyWaiters hands the event to all waiters waiting for its type in reply to the command it
+// originates from. Each waiter is notified only once and is removed afterwards.
+func (c *Client) notifyWaiters(e *Event) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+
+	for w := range c.waiters {
+		if w.types[e.Name()] && w.replied(e) {
+			w.ch <- e
+			delete(c.waiters, w)
+		}
+	}
+}
+
+// replied returns true when the event originates from the command of the waiter. Events received
+// from a Server do not know the request they originate from, so those only need to originate from
+// the same ClientCommand.
+func (w *waiter) replied(e *Event) bool {
+	cmd, ok := e.Command()
+	if !ok || cmd != w.cmd.Command {
+		return false
+	}
+
+	return e.req == 0 || e.req == w.cmd.req
+}
+
+// isOneOf returns true when typ is present in types.
+func isOneOf(typ EventType, types []EventType) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package nfcptl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// replyDriver is a Driver implementation replying to each command with a copy of the configured
+// event. The unsolicited events are published right before, but not in reply to the command.
+type replyDriver struct {
+	testDriver
+	replies     map[ClientCommand]*Event
+	unsolicited []*Event
+}
+
+func (d *replyDriver) Drive(c *Client) {
+	for {
+		select {
+		case cmd := <-c.Commands():
+			for _, e := range d.unsolicited {
+				e := *e
+				c.PublishEvent(&e)
+			}
+			done := c.Executing(cmd)
+			if e, ok := d.replies[cmd.Command]; ok {
+				e := *e
+				c.PublishEvent(&e)
+			} else {
+				c.PublishEvent(NewEvent(UnknownCommand, []byte{}))
+			}
+			done()
+		case <-c.Terminate():
+			c.Done()
+			return
+		}
+	}
+}
+
+// newReplyClient returns a connected Client using a replyDriver. Events are drained in the
+// background.
+func newReplyClient(t *testing.T, replies map[ClientCommand]*Event) *Client {
+	c, err := NewClient("datel", "ps4amiibo", false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	c.driver = &replyDriver{replies: replies}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	go func() {
+		for range c.Events() {
+		}
+	}()
+	t.Cleanup(func() { c.Disconnect() })
+
+	return c
+}
+
+func TestClient_ReadToken(t *testing.T) {
+	token := []byte{0x04, 0x25, 0x9a, 0x8c}
+	c := newReplyClient(t, map[ClientCommand]*Event{
+		FetchTokenData: NewEvent(TokenTagData, token),
+	})
+
+	got, err := c.ReadToken(context.Background())
+	if err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+	if !bytes.Equal(got, token) {
+		t.Errorf("got %#x, want %#x", got, token)
+	}
+
+	c = newReplyClient(t, map[ClientCommand]*Event{
+		FetchTokenData: NewEvent(TokenTagDataError, token[:2]),
+	})
+
+	_, err = c.ReadToken(context.Background())
+	var cf *ErrCommandFailed
+	if !errors.As(err, &cf) {
+		t.Fatalf("got %v, want ErrCommandFailed", err)
+	}
+	if cf.Event.Name() != TokenTagDataError || !bytes.Equal(cf.Event.Data(), token[:2]) {
+		t.Errorf("got %s %#x, want %s %#x", cf.Event, cf.Event.Data(), TokenTagDataError, token[:2])
+	}
+}
+
+func TestClient_WriteToken(t *testing.T) {
+	c := newReplyClient(t, map[ClientCommand]*Event{
+		WriteTokenData: NewEvent(TokenTagWriteFinish, nil),
+	})
+
+	if err := c.WriteToken(context.Background(), make([]byte, 540), WriteUserData); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+
+	c = newReplyClient(t, map[ClientCommand]*Event{
+		WriteTokenData: NewEvent(TokenTagWriteError, []byte{0x10}),
+	})
+
+	err := c.WriteToken(context.Background(), make([]byte, 540), WriteFull)
+	want := "command WriteTokenData failed with event TokenTagWriteError"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+
+	for _, typ := range []EventType{TokenTagVerifyError, TokenTagDataError} {
+		c = newReplyClient(t, map[ClientCommand]*Event{
+			WriteTokenData: NewEvent(typ, []byte{0x10}),
+		})
+
+		err := c.WriteToken(context.Background(), make([]byte, 540), WriteFull|WriteVerify)
+		var cf *ErrCommandFailed
+		if !errors.As(err, &cf) || cf.Event.Name() != typ {
+			t.Errorf("got %v, want ErrCommandFailed holding %s", err, typ)
+		}
+	}
+}
+
+func TestClient_PageCommands(t *testing.T) {
+	pages := []byte{0x10, 0x01, 0x02, 0x03, 0x04}
+	c := newReplyClient(t, map[ClientCommand]*Event{
+		ReadPages: NewEvent(TokenTagPages, pages),
+		WritePage: NewEvent(TokenTagPageWritten, pages[:1]),
+		PwdAuth:   NewErrorEvent(TokenTagPageError, []byte{byte(PwdAuth)}, errors.New("password mismatch")),
+	})
+
+	got, err := c.ReadPages(context.Background(), 0x10, 1)
+	if err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+	if !bytes.Equal(got, pages[1:]) {
+		t.Errorf("got %#x, want %#x", got, pages[1:])
+	}
+
+	if err := c.WritePage(context.Background(), 0x10, pages[1:]); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+
+	_, err = c.PwdAuth(context.Background(), []byte{0x01, 0x02, 0x03, 0x04})
+	var cf *ErrCommandFailed
+	if !errors.As(err, &cf) || cf.Event.Name() != TokenTagPageError {
+		t.Errorf("got %v, want ErrCommandFailed with %s", err, TokenTagPageError)
+	}
+
+	for _, data := range [][]byte{{}, {0x11, 0x01, 0x02, 0x03, 0x04}, pages[:4]} {
+		c = newReplyClient(t, map[ClientCommand]*Event{
+			ReadPages: NewEvent(TokenTagPages, data),
+		})
+		if _, err := c.ReadPages(context.Background(), 0x10, 1); err != ErrInvalidReply {
+			t.Errorf("%#x: got %v, want %s", data, err, ErrInvalidReply)
+		}
+	}
+}
+
+func TestClient_DeviceName(t *testing.T) {
+	name := []byte("NFC-Portal")
+	c := newReplyClient(t, map[ClientCommand]*Event{
+		GetDeviceName: NewEvent(DeviceName, name),
+	})
+
+	got, err := c.DeviceName(context.Background())
+	if err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+	if !bytes.Equal(got, name) {
+		t.Errorf("got %s, want %s", got, name)
+	}
+
+	_, err = c.HardwareInfo(context.Background())
+	var uc ErrUnsupportedCommand
+	if !errors.As(err, &uc) || uc.Command != GetHardwareInfo {
+		t.Errorf("got %v, want ErrUnsupportedCommand for %s", err, GetHardwareInfo)
+	}
+}
+
+func TestClient_requestTimeout(t *testing.T) {
+	// The reply is never sent since the driver publishes an unrelated event.
+	c := newReplyClient(t, map[ClientCommand]*Event{
+		GetHardwareInfo: NewEvent(OK, nil),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := c.HardwareInfo(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %s", err, context.DeadlineExceeded)
+	}
+
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+	if len(c.waiters) != 0 {
+		t.Errorf("got %d waiters, want 0", len(c.waiters))
+	}
+}
+
+func TestClient_RequestIgnoresOtherEvents(t *testing.T) {
+	token := []byte{0x04, 0x25, 0x9a, 0x8c}
+	c := newReplyClient(t, map[ClientCommand]*Event{
+		FetchTokenData: NewEvent(TokenTagData, token),
+	})
+	other := NewEvent(CommandCancelled, []byte{byte(FetchTokenData)})
+	other.setRequest(Command{Command: FetchTokenData, req: 1 << 32})
+	c.driver.(*replyDriver).unsolicited = []*Event{
+		NewEvent(TokenTagDataError, token[:2]),
+		NewEvent(Error, nil),
+		other,
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := c.ReadToken(context.Background())
+		if err != nil {
+			t.Fatalf("got %s, want nil", err)
+		}
+		if !bytes.Equal(got, token) {
+			t.Errorf("got %#x, want %#x", got, token)
+		}
+	}
+}
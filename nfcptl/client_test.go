@@ -1,6 +1,40 @@
 package nfcptl
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testDriver is a minimal Driver implementation that does nothing but wait for termination.
+type testDriver struct {
+	disconnected bool
+}
+
+func (d *testDriver) Connect(c *Client) error                { return nil }
+func (d *testDriver) Disconnect() error                      { d.disconnected = true; return nil }
+func (d *testDriver) Supports() []Vendor                     { return nil }
+func (d *testDriver) VendorId(alias string) (uint16, error)  { return 0, nil }
+func (d *testDriver) ProductId(alias string) (uint16, error) { return 0, nil }
+func (d *testDriver) Setup() any                             { return nil }
+func (d *testDriver) New() Driver                            { return &testDriver{} }
+func (d *testDriver) Drive(c *Client) {
+	<-c.Terminate()
+	c.Done()
+}
+
+// newTestClient returns a Client using a testDriver.
+func newTestClient(t *testing.T) (*Client, *testDriver) {
+	c, err := NewClient("datel", "ps4amiibo", false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	d := &testDriver{}
+	c.driver = d
+
+	return c, d
+}
 
 func TestNewClient(t *testing.T) {
 	_, err := NewClient("datel", "ps4a", true)
@@ -33,3 +67,205 @@ func TestNewClient(t *testing.T) {
 		t.Error("got nil, want interface{}")
 	}
 }
+
+func TestNewClientForDevice(t *testing.T) {
+	di := DeviceInfo{Vendor: "datel", Product: "ps4amiibo", Serial: "0123456789AB", Bus: 1, Address: 7}
+	c1, err := NewClientForDevice(di, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	if got := c1.Target(); got != di {
+		t.Errorf("got %v, want %v", got, di)
+	}
+
+	c2, err := NewClientForDevice(DeviceInfo{Vendor: "datel", Product: "ps4amiibo"}, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	if c1.driver == c2.driver {
+		t.Error("got the same driver instance for both clients, want a driver instance per client")
+	}
+
+	_, err = NewClientForDevice(DeviceInfo{Vendor: "datel", Product: "ps4a"}, false)
+	if err == nil {
+		t.Error("got nil, want nfcptl: no driver found for vendor=datel and product=ps4a")
+	}
+}
+
+func TestClient_SetSerial(t *testing.T) {
+	c, err := NewClient(VendorNXPSemiconductors, ProductPN532, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	want := Serial{Port: "/dev/ttyUSB0", Baud: 115200}
+	if got := c.Setup(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	c.SetSerial(Serial{Port: "/dev/ttyS1"})
+	want = Serial{Port: "/dev/ttyS1", Baud: 115200}
+	if got := c.Setup(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	c.SetSerial(Serial{Port: "/dev/ttyS1", Baud: 9600})
+	want = Serial{Port: "/dev/ttyS1", Baud: 9600}
+	if got := c.Setup(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// A serial port must not affect drivers that do not use a UART.
+	c, _ = newTestClient(t)
+	c.SetSerial(Serial{Port: "/dev/ttyS1"})
+	if got := c.Setup(); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+}
+
+func TestClient_ConnectContext(t *testing.T) {
+	c, d := newTestClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.ConnectContext(ctx); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	cancel()
+
+	var got []EventType
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case e, ok := <-c.Events():
+			if !ok {
+				done = true
+				break
+			}
+			got = append(got, e.Name())
+		case <-timeout:
+			t.Fatal("client did not shut down after cancelling the context")
+		}
+	}
+
+	if len(got) != 1 || got[0] != Disconnect {
+		t.Errorf("got %v, want [%s]", got, Disconnect)
+	}
+	if !d.disconnected {
+		t.Error("got false, want driver to be disconnected")
+	}
+
+	// Disconnecting after the context has been cancelled must not block nor panic.
+	if err := c.Disconnect(); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+}
+
+func TestClient_Disconnect(t *testing.T) {
+	c, d := newTestClient(t)
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if err := c.Disconnect(); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+	if !d.disconnected {
+		t.Error("got false, want driver to be disconnected")
+	}
+
+	e := <-c.Events()
+	if e.Name() != Disconnect {
+		t.Errorf("got %s, want %s", e, Disconnect)
+	}
+
+	// A client that never connected must disconnect cleanly too.
+	c, d = newTestClient(t)
+	if err := c.Disconnect(); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+	if !d.disconnected {
+		t.Error("got false, want driver to be disconnected")
+	}
+}
+
+func TestClient_SendCommandContext(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	// Fill up the commands channel so the next command blocks.
+	c.SendCommand(Command{Command: GetDeviceName})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := c.SendCommandContext(ctx, Command{Command: GetHardwareInfo})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %s", err, context.DeadlineExceeded)
+	}
+
+	e := <-c.Events()
+	if e.Name() != CommandCancelled || e.Data()[0] != byte(GetHardwareInfo) {
+		t.Errorf("got %s %#x, want %s %#x", e, e.Data(), CommandCancelled, GetHardwareInfo)
+	}
+
+	cmd := <-c.Commands()
+	if cmd.Context() != context.Background() {
+		t.Error("got a custom context, want context.Background()")
+	}
+
+	type key struct{}
+	ctx = context.WithValue(context.Background(), key{}, "value")
+	if err := c.SendCommandContext(ctx, Command{Command: GetHardwareInfo}); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+	cmd = <-c.Commands()
+	if cmd.Context() != ctx {
+		t.Error("got a different context, want the context passed to SendCommandContext")
+	}
+
+	c.Disconnect()
+	c.SendCommand(Command{Command: GetDeviceName})
+	err = c.SendCommandContext(context.Background(), Command{Command: GetHardwareInfo})
+	if !errors.Is(err, ErrClientTerminated) {
+		t.Errorf("got %v, want %s", err, ErrClientTerminated)
+	}
+}
+
+func TestClient_SendCommandContextDisconnect(t *testing.T) {
+	c, _ := newTestClient(t)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	c.SendCommand(Command{Command: GetDeviceName})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Disconnect()
+	for i := 0; i < 100; i++ {
+		err := c.SendCommandContext(ctx, Command{Command: GetHardwareInfo})
+		if !errors.Is(err, ErrClientTerminated) {
+			t.Fatalf("got %v, want %s", err, ErrClientTerminated)
+		}
+	}
+
+	// Cancel the context while the client disconnects.
+	for i := 0; i < 100; i++ {
+		c, _ := newTestClient(t)
+		if err := c.Connect(); err != nil {
+			t.Fatalf("got %s, want nil", err)
+		}
+		c.SendCommand(Command{Command: GetDeviceName})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		res := make(chan error)
+		go func() {
+			res <- c.SendCommandContext(ctx, Command{Command: GetHardwareInfo})
+		}()
+		go cancel()
+		c.Disconnect()
+
+		if err := <-res; !errors.Is(err, context.Canceled) && !errors.Is(err, ErrClientTerminated) {
+			t.Fatalf("got %v, want %s or %s", err, context.Canceled, ErrClientTerminated)
+		}
+	}
+}
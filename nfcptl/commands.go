@@ -1,36 +1,127 @@
 package nfcptl
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 type ClientCommand byte
 
 type Command struct {
 	Command   ClientCommand
 	Arguments []byte
+
+	ctx context.Context // Set by Client.SendCommandContext.
+	req uint64          // Set by the synchronous client methods to recognise the reply events.
+}
+
+// Context returns the context the command was sent with. When the command was not sent using
+// Client.SendCommandContext, context.Background() is returned.
+// Drivers SHOULD NOT execute a command of which the context is done and publish the
+// CommandCancelled event instead.
+func (cmd Command) Context() context.Context {
+	if cmd.ctx == nil {
+		return context.Background()
+	}
+	return cmd.ctx
 }
 
 const (
 	GetDeviceName ClientCommand = iota
 	GetHardwareInfo
 	GetApiPassword
+	// FetchTokenData reads the token placed on the portal. The driver replies with TokenTagData or
+	// with TokenTagDataError when the token could not be read.
 	FetchTokenData
-	// WriteTokenData expects the first byte in the arguments to be 0x00 for a full write or 0x01
-	// to write only the user data block. The next 540 bytes must always be the full amiibo data.
+	// WriteTokenData expects the first byte in the arguments to be WriteFull, WriteUserData or
+	// WriteProvision, optionally OR-ed with WriteSmart and WriteVerify. The next 540 bytes must
+	// always be the full amiibo data.
 	WriteTokenData
 	// SetLedState accepts an argument ranging from 0x00 being off, to 0xff being full power.
 	SetLedState
+	// ListBanks lists the banks of a token holding multiple amiibo such as the N2 Elite. The driver
+	// replies with BankList or with BankError when the banks could not be listed.
+	ListBanks
+	// SetActiveBank expects the zero based number of the bank to present to a reader as its first
+	// argument. The driver replies with ActiveBankSet or BankError.
+	SetActiveBank
+	// SetBankCount expects the number of banks the token should have as its first argument. The
+	// driver replies with BankCountSet or BankError.
+	SetBankCount
+	// ReadBank expects the zero based number of the bank to read as its first argument. The driver
+	// replies with BankData or BankError.
+	ReadBank
+	// WriteBank expects the zero based number of the bank to write as its first argument followed
+	// by the 540 or 572 bytes of amiibo data. The driver replies with the same events as it does
+	// for WriteTokenData.
+	WriteBank
+	// EraseBank expects the zero based number of the bank to erase as its first argument. The
+	// driver replies with BankErased or BankError.
+	EraseBank
+	// EnterDfu puts the device in device firmware update mode. The driver replies with DfuMode
+	// after which the client disconnects. Use OpenDFU to flash the firmware afterwards.
+	EnterDfu
+	// ReadPages reads pages of an NFC Forum Type 2 Tag such as the NTAG21x. It expects the page to
+	// start reading from as its first argument and the number of pages to read as its second
+	// argument. The driver replies with TokenTagPages or with TokenTagPageError.
+	ReadPages
+	// WritePage expects the page to write as its first argument followed by the four bytes to
+	// write. The driver replies with TokenTagPageWritten or with TokenTagPageError.
+	WritePage
+	// PwdAuth expects the four byte password to send using the NTAG21x PWD_AUTH command as its
+	// arguments. The driver replies with TokenTagAuthenticated or with TokenTagPageError.
+	PwdAuth
 )
 
-// String returns the string representation of the ClientCommand.
+// The write modes accepted as the first argument of the WriteTokenData command.
+const (
+	// WriteFull writes all pages of the amiibo data to the token.
+	WriteFull = 0x00
+	// WriteUserData writes only the user data block of the amiibo data to the token, being pages
+	// 0x04 up to and including 0x81. The original software labels this as 'restoring a backup'.
+	WriteUserData = 0x01
+	// WriteSmart can be combined with WriteFull or WriteUserData to first read the token and only
+	// write the pages that differ from the amiibo data. The write is refused upfront when a page
+	// that needs to change is locked.
+	WriteSmart = 0x02
+	// WriteVerify can be combined with any of the other write modes to compare the token data read
+	// after the write to the amiibo data. The TokenTagVerifyError event is published when they
+	// differ.
+	WriteVerify = 0x04
+	// WriteProvision turns a blank NTAG215 token into an amiibo. The amiibo data must hold the UID
+	// of the token, so it must have been prepared for the token using amiibo.Provision. The data
+	// pages are written first, followed by PWD and PACK, CFG0 holding AUTH0 and CFG1 and finally
+	// the static and dynamic lock bytes. The token is authenticated using the new password right
+	// after writing AUTH0. The write is always verified and WriteSmart is ignored.
+	WriteProvision = 0x08
+)
+
+// String returns the string representation of the ClientCommand. Unknown commands are represented
+// by their number.
 func (cc ClientCommand) String() string {
-	return []string{
+	names := []string{
 		"GetDeviceName",
 		"GetHardwareInfo",
 		"GetApiPassword",
 		"FetchTokenData",
 		"WriteTokenData",
 		"SetLedState",
-	}[cc]
+		"ListBanks",
+		"SetActiveBank",
+		"SetBankCount",
+		"ReadBank",
+		"WriteBank",
+		"EraseBank",
+		"EnterDfu",
+		"ReadPages",
+		"WritePage",
+		"PwdAuth",
+	}
+	if int(cc) >= len(names) {
+		return fmt.Sprintf("ClientCommand(%d)", cc)
+	}
+
+	return names[cc]
 }
 
 type DriverCommand byte
@@ -69,3 +160,15 @@ type ErrUnsupportedCommand struct {
 func (e ErrUnsupportedCommand) Error() string {
 	return fmt.Sprintf("received unsupported command %d", e.Command)
 }
+
+// ErrCommandFailed defines the error structure returned by the synchronous Client methods when the
+// driver replied to a command with an event signaling failure.
+type ErrCommandFailed struct {
+	Command ClientCommand
+	Event   *Event // Event holds the event published by the driver which can contain partial data.
+}
+
+// Error implements the error interface
+func (e ErrCommandFailed) Error() string {
+	return fmt.Sprintf("command %s failed with event %s", e.Command, e.Event)
+}
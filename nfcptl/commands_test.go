@@ -13,6 +13,18 @@ func TestClientCommand_String(t *testing.T) {
 		FetchTokenData:  "FetchTokenData",
 		WriteTokenData:  "WriteTokenData",
 		SetLedState:     "SetLedState",
+		ListBanks:       "ListBanks",
+		SetActiveBank:   "SetActiveBank",
+		SetBankCount:    "SetBankCount",
+		ReadBank:        "ReadBank",
+		WriteBank:       "WriteBank",
+		EraseBank:       "EraseBank",
+		EnterDfu:        "EnterDfu",
+		ReadPages:       "ReadPages",
+		WritePage:       "WritePage",
+		PwdAuth:         "PwdAuth",
+		PwdAuth + 1:     "ClientCommand(16)",
+		200:             "ClientCommand(200)",
 	}
 
 	for cmd, want := range tests {
@@ -0,0 +1,147 @@
+package nfcptl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrNoDeviceDetected is returned by auto-detection when none of the attached USB devices is
+// supported by a registered driver.
+var ErrNoDeviceDetected = errors.New("nfcptl: no supported device detected")
+
+// DeviceInfo describes an attached device that is supported by one of the registered drivers.
+type DeviceInfo struct {
+	Vendor    string // Vendor holds the vendor alias of the device.
+	Product   string // Product holds the product alias of the device.
+	VendorId  uint16 // VendorId holds the USB vendor ID of the device.
+	ProductId uint16 // ProductId holds the USB product ID of the device.
+	Bus       int    // Bus holds the number of the USB bus the device is connected to.
+	Address   int    // Address holds the address of the device on the USB bus.
+	Port      int    // Port holds the number of the USB port the device is connected to.
+	Path      string // Path holds the USB port path of the device, e.g. "1.4" for port 4 of a hub on port 1.
+	Serial    string // Serial holds the USB serial number string. Empty when it could not be read.
+}
+
+// String returns the device info in a human readable format.
+func (di DeviceInfo) String() string {
+	s := fmt.Sprintf("%s/%s (%04x:%04x) on bus %03d address %03d", di.Vendor, di.Product, di.VendorId, di.ProductId, di.Bus, di.Address)
+	if di.Serial != "" {
+		s += " serial " + di.Serial
+	}
+	return s
+}
+
+// ErrAmbiguousDevice defines the error structure returned when auto-detection found more than one
+// type of supported device. The caller must then pick one of the candidates explicitly.
+type ErrAmbiguousDevice struct {
+	Candidates []DeviceInfo // Candidates holds one attached device for each supported device type.
+}
+
+// Error implements the error interface
+func (e ErrAmbiguousDevice) Error() string {
+	list := make([]string, len(e.Candidates))
+	for i, c := range e.Candidates {
+		list[i] = c.String()
+	}
+	return "nfcptl: multiple supported devices detected: " + strings.Join(list, ", ")
+}
+
+// ListDevices returns all attached devices that are supported by one of the registered drivers.
+// The devices will be opened briefly to read the serial number. When the current user is not
+// allowed to open a device, the serial number will be empty.
+func ListDevices() ([]DeviceInfo, error) {
+	devs, err := attachedDevices(func(vid, pid uint16) bool {
+		_, _, ok := lookupAliases(vid, pid)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range devs {
+		devs[i].Vendor, devs[i].Product, _ = lookupAliases(devs[i].VendorId, devs[i].ProductId)
+	}
+
+	return devs, nil
+}
+
+// DetectDevice searches the attached USB devices for a device supported by one of the registered
+// drivers. It returns the vendor and product alias of the detected device. When no device is
+// found, ErrNoDeviceDetected is returned. When different types of supported devices are found, an
+// ErrAmbiguousDevice error listing all candidates is returned. Multiple devices of the same type
+// are not considered ambiguous: the first one will be used.
+func DetectDevice() (string, string, error) {
+	devs, err := ListDevices()
+	if err != nil {
+		return "", "", err
+	}
+
+	return pickCandidate(candidates(devs))
+}
+
+// lookupAliases returns the vendor and product alias of the registered driver supporting the
+// given vendor and product ID. Products having a zero product ID, such as the remote, virtual and
+// PN532 devices, cannot be found on the USB bus and never match. The aliases are searched in
+// sorted order so the result does not depend on the order of the drivers map.
+func lookupAliases(vid, pid uint16) (string, string, bool) {
+	if pid == 0 {
+		return "", "", false
+	}
+
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	var aliases [][2]string
+	for va, products := range drivers {
+		for pa := range products {
+			aliases = append(aliases, [2]string{va, pa})
+		}
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		if aliases[i][0] != aliases[j][0] {
+			return aliases[i][0] < aliases[j][0]
+		}
+		return aliases[i][1] < aliases[j][1]
+	})
+
+	for _, a := range aliases {
+		va, pa := a[0], a[1]
+		d := drivers[va][pa]
+		if id, err := d.VendorId(va); err != nil || id != vid {
+			continue
+		}
+		if id, err := d.ProductId(pa); err == nil && id == pid {
+			return va, pa, true
+		}
+	}
+
+	return "", "", false
+}
+
+// candidates returns the first device of each device type in the list, preserving the order.
+func candidates(devs []DeviceInfo) []DeviceInfo {
+	var cands []DeviceInfo
+	seen := make(map[string]bool)
+	for _, d := range devs {
+		if key := d.Vendor + "/" + d.Product; !seen[key] {
+			cands = append(cands, d)
+			seen[key] = true
+		}
+	}
+
+	return cands
+}
+
+// pickCandidate returns the vendor and product alias of the only candidate in the list.
+func pickCandidate(cands []DeviceInfo) (string, string, error) {
+	switch len(cands) {
+	case 0:
+		return "", "", ErrNoDeviceDetected
+	case 1:
+		return cands[0].Vendor, cands[0].Product, nil
+	}
+
+	return "", "", ErrAmbiguousDevice{Candidates: cands}
+}
@@ -0,0 +1,92 @@
+package nfcptl
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDeviceInfo_String(t *testing.T) {
+	di := DeviceInfo{Vendor: "vend", Product: "prod", VendorId: 0x1c1a, ProductId: 0x03d9, Bus: 1, Address: 12}
+	got := di.String()
+	want := "vend/prod (1c1a:03d9) on bus 001 address 012"
+	if got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+
+	di.Serial = "0123456789AB"
+	got = di.String()
+	want += " serial 0123456789AB"
+	if got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+}
+
+func TestErrAmbiguousDevice_Error(t *testing.T) {
+	e := ErrAmbiguousDevice{Candidates: []DeviceInfo{
+		{Vendor: "vend", Product: "prod", VendorId: 0x1c1a, ProductId: 0x03d9, Bus: 1, Address: 2},
+		{Vendor: "other", Product: "device", VendorId: 0x5c60, ProductId: 0xdead, Bus: 3, Address: 4},
+	}}
+	got := e.Error()
+	want := "nfcptl: multiple supported devices detected: vend/prod (1c1a:03d9) on bus 001 address 002, other/device (5c60:dead) on bus 003 address 004"
+	if got != want {
+		t.Errorf("got '%s', want '%s'", got, want)
+	}
+}
+
+func TestLookupAliases(t *testing.T) {
+	va, pa, ok := lookupAliases(VIDMaxlander, PIDMaxLander)
+	if va != VendorMaxlander || pa != ProductMaxLander || !ok {
+		t.Errorf("lookupAliases() returned '%s,%s,%v', want '%s,%s,true'", va, pa, ok, VendorMaxlander, ProductMaxLander)
+	}
+
+	va, pa, ok = lookupAliases(0x046d, 0xc52b)
+	if va != "" || pa != "" || ok {
+		t.Errorf("lookupAliases() returned '%s,%s,%v', want ',,false'", va, pa, ok)
+	}
+
+	// Devices that cannot be found on the USB bus never match, although their IDs are registered.
+	for _, ids := range [][2]uint16{{VIDRemote, PIDServer}, {VIDVirtual, PIDEmulator}, {VIDNXPSemiconductors, PIDPN532}} {
+		va, pa, ok = lookupAliases(ids[0], ids[1])
+		if va != "" || pa != "" || ok {
+			t.Errorf("lookupAliases(%04x, %04x) returned '%s,%s,%v', want ',,false'", ids[0], ids[1], va, pa, ok)
+		}
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	devs := []DeviceInfo{
+		{Vendor: VendorMaxlander, Product: ProductMaxLander, Bus: 1, Address: 5},
+		{Vendor: VendorDatelElextronicsLtd, Product: ProductPowerSavesForAmiibo, Bus: 1, Address: 6},
+		{Vendor: VendorMaxlander, Product: ProductMaxLander, Bus: 2, Address: 3},
+	}
+
+	got := candidates(devs)
+	want := devs[:2]
+	if len(got) != len(want) {
+		t.Fatalf("candidates() returned %d candidates, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("candidates() returned %s, want %s", got[i], want[i])
+		}
+	}
+}
+
+func TestPickCandidate(t *testing.T) {
+	_, _, err := pickCandidate(nil)
+	if !errors.Is(err, ErrNoDeviceDetected) {
+		t.Errorf("pickCandidate() returned %v, want %s", err, ErrNoDeviceDetected)
+	}
+
+	ps := DeviceInfo{Vendor: VendorDatelElextronicsLtd, Product: ProductPowerSavesForAmiibo}
+	va, pa, err := pickCandidate([]DeviceInfo{ps})
+	if va != VendorDatelElextronicsLtd || pa != ProductPowerSavesForAmiibo || err != nil {
+		t.Errorf("pickCandidate() returned '%s,%s,%v', want '%s,%s,<nil>'", va, pa, err, VendorDatelElextronicsLtd, ProductPowerSavesForAmiibo)
+	}
+
+	ml := DeviceInfo{Vendor: VendorMaxlander, Product: ProductMaxLander}
+	_, _, err = pickCandidate([]DeviceInfo{ps, ml})
+	if e, ok := err.(ErrAmbiguousDevice); !ok || len(e.Candidates) != 2 {
+		t.Errorf("pickCandidate() returned %v, want ErrAmbiguousDevice with 2 candidates", err)
+	}
+}
@@ -0,0 +1,598 @@
+package nfcptl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/gousb"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The DFU class specific requests as defined by the USB Device Firmware Upgrade Specification,
+// Revision 1.1.
+const (
+	dfuDetach    = 0x00
+	dfuDnload    = 0x01
+	dfuUpload    = 0x02
+	dfuGetStatus = 0x03
+	dfuClrStatus = 0x04
+	dfuGetState  = 0x05
+	dfuAbort     = 0x06
+)
+
+// The interface class, subclass and protocol of a device in DFU mode.
+const (
+	dfuClass    = gousb.ClassApplication
+	dfuSubClass = 0x01
+	dfuProtocol = 0x02
+)
+
+// dfuFunctionalDescriptor is the descriptor type of the DFU functional descriptor.
+const dfuFunctionalDescriptor = 0x21
+
+// dfuSeVersion is the bcdDFUVersion in the DFU functional descriptor of a device using the
+// STMicroelectronics DfuSe extensions, such as the STM32 system memory bootloader.
+const dfuSeVersion = 0x011a
+
+// dfuState is the state of a device in DFU mode as returned by DFU_GETSTATUS.
+type dfuState byte
+
+const (
+	dfuStateAppIdle dfuState = iota
+	dfuStateAppDetach
+	dfuStateIdle
+	dfuStateDnloadSync
+	dfuStateDnbusy
+	dfuStateDnloadIdle
+	dfuStateManifestSync
+	dfuStateManifest
+	dfuStateManifestWaitReset
+	dfuStateUploadIdle
+	dfuStateError
+)
+
+// String returns the name of the state as used in the DFU specification.
+func (s dfuState) String() string {
+	names := []string{
+		"appIDLE",
+		"appDETACH",
+		"dfuIDLE",
+		"dfuDNLOAD-SYNC",
+		"dfuDNBUSY",
+		"dfuDNLOAD-IDLE",
+		"dfuMANIFEST-SYNC",
+		"dfuMANIFEST",
+		"dfuMANIFEST-WAIT-RESET",
+		"dfuUPLOAD-IDLE",
+		"dfuERROR",
+	}
+	if int(s) < len(names) {
+		return names[s]
+	}
+	return fmt.Sprintf("state %d", s)
+}
+
+// dfuStatus holds the reply to DFU_GETSTATUS.
+type dfuStatus struct {
+	status      byte
+	pollTimeout time.Duration // The time to wait before the next DFU_GETSTATUS request.
+	state       dfuState
+}
+
+var (
+	// ErrNoDownload is returned by DFU.Flash when the device does not accept firmware downloads.
+	ErrNoDownload = errors.New("dfu: device does not support downloading firmware")
+	// ErrNotVerified is returned by DFU.Flash when the firmware has been flashed successfully, but
+	// the device cannot upload the firmware again to verify it.
+	ErrNotVerified = errors.New("dfu: device does not support reading back the firmware, the firmware has not been verified")
+	// ErrVerifyFailed is returned by DFU.Flash when the firmware read back from the device differs
+	// from the flashed image.
+	ErrVerifyFailed = errors.New("dfu: firmware read back differs from image")
+	// ErrNotInDfuMode is returned by DFU.Flash when the device is in its run-time mode.
+	ErrNotInDfuMode = errors.New("dfu: device is not in DFU mode")
+	// ErrDfuSe is returned by DFU.Flash when the device uses the STMicroelectronics DfuSe
+	// extensions. A DfuSe device needs the flash to be erased and the download address to be set,
+	// downloading a plain DFU image to it would write the image to the wrong place.
+	ErrDfuSe = errors.New("dfu: device uses the unsupported DfuSe protocol")
+)
+
+// ErrDFUStatus defines the error structure returned when a device in DFU mode reports an error.
+type ErrDFUStatus struct {
+	Status byte // The bStatus value reported by the device.
+}
+
+// Error implements the error interface
+func (e ErrDFUStatus) Error() string {
+	names := []string{
+		"OK",
+		"errTARGET",
+		"errFILE",
+		"errWRITE",
+		"errERASE",
+		"errCHECK_ERASED",
+		"errPROG",
+		"errVERIFY",
+		"errADDRESS",
+		"errNOTDONE",
+		"errFIRMWARE",
+		"errVENDOR",
+		"errUSBR",
+		"errPOR",
+		"errUNKNOWN",
+		"errSTALLEDPKT",
+	}
+	if int(e.Status) < len(names) {
+		return fmt.Sprintf("dfu: device reported %s", names[e.Status])
+	}
+	return fmt.Sprintf("dfu: device reported status %#02x", e.Status)
+}
+
+// DFUDevice is a USB device in DFU mode. It is implemented by the device returned by OpenDFU, but
+// can be implemented by a simulated device as well.
+type DFUDevice interface {
+	// Control sends a control request to the device, see gousb.Device.Control.
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+}
+
+// DFUAttributes holds the capabilities of a device in DFU mode as described by its DFU functional
+// descriptor.
+type DFUAttributes struct {
+	CanDownload           bool
+	CanUpload             bool
+	ManifestationTolerant bool // The device returns to dfuIDLE after manifestation instead of requiring a reset.
+	WillDetach            bool
+	DetachTimeout         time.Duration
+	TransferSize          int    // The maximum number of bytes per DFU_DNLOAD or DFU_UPLOAD request.
+	Version               uint16 // The bcdDFUVersion, 0x011a for a device using DfuSe. Zero when not reported.
+}
+
+// DfuSe returns true when the device uses the STMicroelectronics DfuSe extensions.
+func (a DFUAttributes) DfuSe() bool {
+	return a.Version == dfuSeVersion
+}
+
+// DFUTarget identifies the device OpenDFU opens. A zero value matches any device in DFU mode.
+type DFUTarget struct {
+	VendorId  uint16 // The vendor ID of the device in DFU mode, zero matching any vendor.
+	ProductId uint16 // The product ID of the device in DFU mode, zero matching any product.
+	Bus       int    // The USB bus the device is attached to.
+	Path      string // The USB port path of the device as in DeviceInfo, empty matching any port.
+}
+
+// matches returns true when the device described by desc is the target. A device attaching in DFU
+// mode gets a new address, but keeps the bus and the port path it was attached to.
+func (t DFUTarget) matches(desc *gousb.DeviceDesc) bool {
+	if (t.VendorId != 0 && uint16(desc.Vendor) != t.VendorId) || (t.ProductId != 0 && uint16(desc.Product) != t.ProductId) {
+		return false
+	}
+
+	return t.Path == "" || (desc.Bus == t.Bus && usbPortPath(desc.Path) == t.Path)
+}
+
+// usbPortPath returns the given USB port numbers as a port path, e.g. "1.4" for a device attached
+// to port 4 of a hub attached to port 1 of the root hub.
+func usbPortPath(ports []int) string {
+	path := make([]string, len(ports))
+	for i, p := range ports {
+		path[i] = strconv.Itoa(p)
+	}
+	return strings.Join(path, ".")
+}
+
+// FirmwareStage is the stage of a firmware update reported by FirmwareProgress.
+type FirmwareStage string
+
+const (
+	// FirmwareDownload is reported while the firmware image is being downloaded to the device.
+	FirmwareDownload FirmwareStage = "download"
+	// FirmwareVerify is reported while the firmware is being read back from the device.
+	FirmwareVerify FirmwareStage = "verify"
+)
+
+// FirmwareProgress reports the progress of DFU.Flash.
+type FirmwareProgress struct {
+	Stage FirmwareStage
+	Done  int // The number of bytes downloaded or verified so far.
+	Total int // The size of the firmware image.
+}
+
+// DFU implements the host side of the USB Device Firmware Upgrade protocol, revision 1.1, allowing
+// a firmware image to be flashed to a device in DFU mode. The STMicroelectronics DfuSe extensions
+// are not supported: flashing a DfuSe device is refused.
+type DFU struct {
+	dev   DFUDevice
+	iface uint16
+	attrs DFUAttributes
+}
+
+// NewDFU returns a DFU flashing the device through the given DFU interface number using the given
+// attributes. Use OpenDFU to open an attached device instead.
+func NewDFU(dev DFUDevice, iface uint16, attrs DFUAttributes) *DFU {
+	return &DFU{dev: dev, iface: iface, attrs: attrs}
+}
+
+// Attributes returns the capabilities of the device.
+func (d *DFU) Attributes() DFUAttributes {
+	return d.attrs
+}
+
+// Close releases the device when it implements io.Closer.
+func (d *DFU) Close() error {
+	if c, ok := d.dev.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Flash downloads the given firmware image to the device, makes the device manifest it and then
+// reads the firmware back to verify it. The progress function, when not nil, is called after each
+// transferred block. ErrNotVerified is returned when the image has been flashed but the device
+// cannot upload the firmware or requires a reset after manifestation. ErrDfuSe is returned before
+// sending anything to a device using DfuSe. When the context is done, the download is aborted.
+func (d *DFU) Flash(ctx context.Context, image []byte, progress func(FirmwareProgress)) error {
+	if d.attrs.DfuSe() {
+		return ErrDfuSe
+	}
+	if !d.attrs.CanDownload {
+		return ErrNoDownload
+	}
+	if d.attrs.TransferSize <= 0 {
+		return fmt.Errorf("dfu: invalid transfer size %d", d.attrs.TransferSize)
+	}
+	if progress == nil {
+		progress = func(FirmwareProgress) {}
+	}
+
+	if err := d.idle(); err != nil {
+		return err
+	}
+	blocks, err := d.download(ctx, image, progress)
+	if err != nil {
+		d.abort()
+		return err
+	}
+	if err := d.manifest(ctx, blocks); err != nil {
+		return err
+	}
+
+	if !d.attrs.CanUpload || !d.attrs.ManifestationTolerant {
+		return ErrNotVerified
+	}
+	return d.verify(ctx, image, progress)
+}
+
+// idle brings the device in the dfuIDLE state, clearing a previous error or aborting a previous
+// transfer.
+func (d *DFU) idle() error {
+	st, err := d.getStatus()
+	if err != nil {
+		return err
+	}
+
+	switch st.state {
+	case dfuStateIdle:
+		return nil
+	case dfuStateAppIdle, dfuStateAppDetach:
+		return ErrNotInDfuMode
+	case dfuStateError:
+		if err := d.request(dfuClrStatus, 0, nil); err != nil {
+			return err
+		}
+	default:
+		if err := d.abort(); err != nil {
+			return err
+		}
+	}
+
+	if st, err = d.getStatus(); err != nil {
+		return err
+	}
+	if st.state != dfuStateIdle {
+		return fmt.Errorf("dfu: device is in state %s, want %s", st.state, dfuStateIdle)
+	}
+	return nil
+}
+
+// download sends the image to the device in blocks of the transfer size. It returns the number of
+// blocks sent.
+func (d *DFU) download(ctx context.Context, image []byte, progress func(FirmwareProgress)) (int, error) {
+	size := d.attrs.TransferSize
+	block := 0
+	for off := 0; off < len(image); block, off = block+1, off+size {
+		if err := ctx.Err(); err != nil {
+			return block, err
+		}
+
+		end := off + size
+		if end > len(image) {
+			end = len(image)
+		}
+		if err := d.request(dfuDnload, uint16(block), image[off:end]); err != nil {
+			return block, fmt.Errorf("dfu: download of block %d failed: %w", block, err)
+		}
+		if err := d.waitFor(ctx, dfuStateDnloadIdle); err != nil {
+			return block, err
+		}
+		progress(FirmwareProgress{Stage: FirmwareDownload, Done: end, Total: len(image)})
+	}
+
+	return block, nil
+}
+
+// manifest ends the download using a zero length DFU_DNLOAD request and waits for the device to
+// finish manifestation. A device that is not manifestation tolerant might stop responding while
+// it resets, which is not considered an error.
+func (d *DFU) manifest(ctx context.Context, block int) error {
+	if err := d.request(dfuDnload, uint16(block), nil); err != nil {
+		return fmt.Errorf("dfu: ending download failed: %w", err)
+	}
+
+	want := dfuStateIdle
+	if !d.attrs.ManifestationTolerant {
+		want = dfuStateManifestWaitReset
+	}
+	err := d.waitFor(ctx, want)
+	if _, ok := err.(*ErrDFUStatus); ok || d.attrs.ManifestationTolerant {
+		return err
+	}
+	if err != nil {
+		log.Printf("dfu: device stopped responding during manifestation: %s", err)
+	}
+	return nil
+}
+
+// verify uploads the firmware from the device and compares it to the image.
+func (d *DFU) verify(ctx context.Context, image []byte, progress func(FirmwareProgress)) error {
+	size := d.attrs.TransferSize
+	var got []byte
+	for block := 0; len(got) < len(image); block++ {
+		if err := ctx.Err(); err != nil {
+			d.abort()
+			return err
+		}
+
+		buf := make([]byte, size)
+		n, err := d.dev.Control(gousb.ControlIn|gousb.ControlClass|gousb.ControlInterface, dfuUpload, uint16(block), d.iface, buf)
+		if err != nil {
+			d.abort()
+			return fmt.Errorf("dfu: upload of block %d failed: %w", block, err)
+		}
+		got = append(got, buf[:n]...)
+
+		done := len(got)
+		if done > len(image) {
+			done = len(image)
+		}
+		progress(FirmwareProgress{Stage: FirmwareVerify, Done: done, Total: len(image)})
+
+		if n < size {
+			// A short upload ends the transfer and returns the device to dfuIDLE.
+			break
+		}
+	}
+	if len(got) >= len(image) {
+		// The device holds more firmware than the image, so end the transfer ourselves.
+		d.abort()
+	}
+
+	if len(got) < len(image) {
+		return fmt.Errorf("%w: read %d bytes, want %d", ErrVerifyFailed, len(got), len(image))
+	}
+	for i := range image {
+		if got[i] != image[i] {
+			return fmt.Errorf("%w at offset %#04x", ErrVerifyFailed, i)
+		}
+	}
+
+	return nil
+}
+
+// waitFor polls the status of the device until it is in the given state, honoring the poll
+// timeout requested by the device. An error is returned when the device reports an error or
+// ends up in another state.
+func (d *DFU) waitFor(ctx context.Context, want dfuState) error {
+	for {
+		st, err := d.getStatus()
+		if err != nil {
+			return err
+		}
+		if st.status != 0 {
+			d.request(dfuClrStatus, 0, nil)
+			return &ErrDFUStatus{Status: st.status}
+		}
+
+		switch st.state {
+		case want:
+			return nil
+		case dfuStateDnloadSync, dfuStateDnbusy, dfuStateManifestSync, dfuStateManifest:
+			select {
+			case <-time.After(st.pollTimeout):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		default:
+			return fmt.Errorf("dfu: device is in state %s, want %s", st.state, want)
+		}
+	}
+}
+
+// getStatus sends DFU_GETSTATUS.
+func (d *DFU) getStatus() (dfuStatus, error) {
+	b := make([]byte, 6)
+	n, err := d.dev.Control(gousb.ControlIn|gousb.ControlClass|gousb.ControlInterface, dfuGetStatus, 0, d.iface, b)
+	if err != nil {
+		return dfuStatus{}, fmt.Errorf("dfu: get status failed: %w", err)
+	}
+	if n != len(b) {
+		return dfuStatus{}, fmt.Errorf("dfu: get status returned %d bytes, want %d", n, len(b))
+	}
+
+	return dfuStatus{
+		status:      b[0],
+		pollTimeout: time.Duration(uint32(b[1])|uint32(b[2])<<8|uint32(b[3])<<16) * time.Millisecond,
+		state:       dfuState(b[4]),
+	}, nil
+}
+
+// abort sends DFU_ABORT returning the device to dfuIDLE.
+func (d *DFU) abort() error {
+	return d.request(dfuAbort, 0, nil)
+}
+
+// request sends a host-to-device DFU request.
+func (d *DFU) request(req uint8, val uint16, data []byte) error {
+	_, err := d.dev.Control(gousb.ControlOut|gousb.ControlClass|gousb.ControlInterface, req, val, d.iface, data)
+	return err
+}
+
+// OpenDFU waits until the given target is attached in DFU mode and opens it, e.g. after the
+// EnterDfu command has been sent to a portal. Only devices exposing a DFU interface are
+// considered. Use STM32F0DFUTarget to get the target of an STM32F0 based portal, which makes sure
+// no other device in DFU mode is flashed by accident. The attached devices are listed every
+// interval until the context is done.
+func OpenDFU(ctx context.Context, target DFUTarget, interval time.Duration) (*DFU, error) {
+	for {
+		d, err := openDFU(target)
+		if err != nil {
+			return nil, err
+		}
+		if d != nil {
+			return d, nil
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// usbDFUDevice implements the DFUDevice interface for an attached USB device.
+type usbDFUDevice struct {
+	ctx   *gousb.Context
+	dev   *gousb.Device
+	cfg   *gousb.Config
+	iface *gousb.Interface
+}
+
+func (u *usbDFUDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return u.dev.Control(rType, request, val, idx, data)
+}
+
+func (u *usbDFUDevice) Close() error {
+	if u.iface != nil {
+		u.iface.Close()
+	}
+	if u.cfg != nil {
+		u.cfg.Close()
+	}
+	u.dev.Close()
+	return u.ctx.Close()
+}
+
+// openDFU opens the first attached device in DFU mode matching the given target. It returns nil
+// when there is none.
+func openDFU(target DFUTarget) (*DFU, error) {
+	ctx := gousb.NewContext()
+
+	var setting gousb.InterfaceSetting
+	cfgNum, found := 0, false
+	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		if found || !target.matches(desc) {
+			return false
+		}
+		for _, cfg := range desc.Configs {
+			for _, i := range cfg.Interfaces {
+				for _, s := range i.AltSettings {
+					if s.Class == dfuClass && s.SubClass == dfuSubClass && s.Protocol == dfuProtocol {
+						setting, cfgNum, found = s, cfg.Number, true
+						return true
+					}
+				}
+			}
+		}
+		return false
+	})
+	if len(devs) == 0 {
+		ctx.Close()
+		if err != nil {
+			return nil, fmt.Errorf("dfu: could not open device: %v", err)
+		}
+		return nil, nil
+	}
+
+	u := &usbDFUDevice{ctx: ctx, dev: devs[0]}
+	u.dev.SetAutoDetach(true)
+	log.Printf("dfu: found device %s", u.dev)
+
+	attrs, err := u.attributes(cfgNum, setting.Number)
+	if err == nil {
+		u.cfg, err = u.dev.Config(cfgNum)
+	}
+	if err == nil {
+		u.iface, err = u.cfg.Interface(setting.Number, setting.Alternate)
+	}
+	if err != nil {
+		u.Close()
+		return nil, fmt.Errorf("dfu: could not open device: %v", err)
+	}
+
+	return NewDFU(u, uint16(setting.Number), attrs), nil
+}
+
+// attributes reads the configuration descriptor with the given configuration value and returns
+// the attributes in the DFU functional descriptor of the given interface.
+func (u *usbDFUDevice) attributes(cfgNum, iface int) (DFUAttributes, error) {
+	for idx := 0; idx < len(u.dev.Desc.Configs); idx++ {
+		b := make([]byte, 1024)
+		n, err := u.dev.Control(gousb.ControlIn|gousb.ControlDevice, 0x06 /* GET_DESCRIPTOR */, uint16(gousb.DescriptorTypeConfig)<<8|uint16(idx), 0, b)
+		if err != nil {
+			return DFUAttributes{}, err
+		}
+		if n > 5 && int(b[5]) == cfgNum {
+			return parseDFUAttributes(b[:n], iface)
+		}
+	}
+
+	return DFUAttributes{}, fmt.Errorf("configuration %d not found", cfgNum)
+}
+
+// parseDFUAttributes returns the attributes in the DFU functional descriptor following the
+// descriptor of the given DFU interface in the given raw configuration descriptor.
+func parseDFUAttributes(cfg []byte, iface int) (DFUAttributes, error) {
+	inIface := false
+	for len(cfg) >= 2 && int(cfg[0]) >= 2 && int(cfg[0]) <= len(cfg) {
+		desc := cfg[:cfg[0]]
+		cfg = cfg[cfg[0]:]
+
+		switch desc[1] {
+		case byte(gousb.DescriptorTypeInterface):
+			inIface = len(desc) >= 8 && int(desc[2]) == iface && desc[5] == byte(dfuClass) && desc[6] == dfuSubClass
+		case dfuFunctionalDescriptor:
+			if !inIface || len(desc) < 7 {
+				continue
+			}
+			attrs := DFUAttributes{
+				CanDownload:           desc[2]&0x01 != 0,
+				CanUpload:             desc[2]&0x02 != 0,
+				ManifestationTolerant: desc[2]&0x04 != 0,
+				WillDetach:            desc[2]&0x08 != 0,
+				DetachTimeout:         time.Duration(binary.LittleEndian.Uint16(desc[3:5])) * time.Millisecond,
+				TransferSize:          int(binary.LittleEndian.Uint16(desc[5:7])),
+			}
+			// bcdDFUVersion was only added in revision 1.1 of the DFU specification.
+			if len(desc) >= 9 {
+				attrs.Version = binary.LittleEndian.Uint16(desc[7:9])
+			}
+			return attrs, nil
+		}
+	}
+
+	return DFUAttributes{}, fmt.Errorf("no DFU functional descriptor found for interface %d", iface)
+}
@@ -0,0 +1,363 @@
+package nfcptl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/gousb"
+	"testing"
+	"time"
+)
+
+// The bStatus values used by simulatedDFU.
+const (
+	dfuStatusOK         = 0x00
+	dfuStatusErrWrite   = 0x03
+	dfuStatusErrStalled = 0x0f
+)
+
+var errSimulatedStall = errors.New("simulated DFU: request stalled")
+
+// simulatedDFU implements the DFUDevice interface simulating a device in DFU mode that follows the
+// state machine of the DFU 1.1 specification. Downloaded firmware is written to its flash memory
+// during manifestation.
+type simulatedDFU struct {
+	attrs  DFUAttributes
+	state  dfuState
+	status byte
+	flash  []byte
+
+	block    int    // The next block expected in a download.
+	download []byte // The firmware downloaded so far.
+	busy     bool   // True when the device reports dfuDNBUSY on the next DFU_GETSTATUS.
+
+	failBlock int  // The block for which errWRITE is reported, -1 for none.
+	corrupt   bool // Flips a bit while manifesting the firmware.
+	reset     bool // True when the device is resetting after manifestation and no longer responds.
+}
+
+// newSimulatedDFU returns a simulatedDFU in the dfuIDLE state that is manifestation tolerant and
+// supports uploading.
+func newSimulatedDFU() *simulatedDFU {
+	return &simulatedDFU{
+		attrs: DFUAttributes{
+			CanDownload:           true,
+			CanUpload:             true,
+			ManifestationTolerant: true,
+			TransferSize:          1024,
+			Version:               0x0110,
+		},
+		state:     dfuStateIdle,
+		flash:     make([]byte, STM32F0FirmwareSize),
+		failBlock: -1,
+	}
+}
+
+// stall puts the device in the dfuERROR state as a real device does when it stalls a request.
+func (s *simulatedDFU) stall() (int, error) {
+	s.state, s.status = dfuStateError, dfuStatusErrStalled
+	return 0, errSimulatedStall
+}
+
+func (s *simulatedDFU) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	if s.reset {
+		return 0, errors.New("simulated DFU: no device")
+	}
+	if idx != 0 {
+		return s.stall()
+	}
+
+	switch request {
+	case dfuDnload:
+		return s.dnload(val, data)
+	case dfuUpload:
+		return s.upload(val, data)
+	case dfuGetStatus:
+		return s.getStatus(data)
+	case dfuClrStatus:
+		if s.state != dfuStateError {
+			return s.stall()
+		}
+		s.state, s.status = dfuStateIdle, dfuStatusOK
+	case dfuAbort:
+		if s.state != dfuStateIdle && s.state != dfuStateDnloadIdle && s.state != dfuStateUploadIdle {
+			return s.stall()
+		}
+		s.state = dfuStateIdle
+	default:
+		return s.stall()
+	}
+
+	return 0, nil
+}
+
+func (s *simulatedDFU) dnload(block uint16, data []byte) (int, error) {
+	switch {
+	case s.state == dfuStateIdle && len(data) > 0 && block == 0:
+		s.block, s.download = 0, nil
+	case s.state == dfuStateDnloadIdle && int(block) == s.block:
+	default:
+		return s.stall()
+	}
+	if len(data) > s.attrs.TransferSize {
+		return s.stall()
+	}
+
+	if len(data) == 0 {
+		s.state = dfuStateManifestSync
+		return 0, nil
+	}
+	if int(block) == s.failBlock {
+		s.state, s.status = dfuStateError, dfuStatusErrWrite
+		return len(data), nil
+	}
+
+	s.download = append(s.download, data...)
+	s.block++
+	s.state, s.busy = dfuStateDnloadSync, true
+
+	return len(data), nil
+}
+
+func (s *simulatedDFU) upload(block uint16, data []byte) (int, error) {
+	if !s.attrs.CanUpload || (s.state != dfuStateIdle && s.state != dfuStateUploadIdle) {
+		return s.stall()
+	}
+
+	off := int(block) * s.attrs.TransferSize
+	if off > len(s.flash) {
+		off = len(s.flash)
+	}
+	n := copy(data, s.flash[off:])
+	s.state = dfuStateUploadIdle
+	if n < len(data) {
+		s.state = dfuStateIdle
+	}
+
+	return n, nil
+}
+
+func (s *simulatedDFU) getStatus(data []byte) (int, error) {
+	state := s.state
+	switch s.state {
+	case dfuStateDnloadSync:
+		if s.busy {
+			state, s.busy = dfuStateDnbusy, false
+		} else {
+			state, s.state = dfuStateDnloadIdle, dfuStateDnloadIdle
+		}
+	case dfuStateManifestSync:
+		copy(s.flash, s.download)
+		if s.corrupt {
+			s.flash[len(s.download)/2] ^= 0x01
+		}
+		state, s.state = dfuStateManifest, dfuStateIdle
+		if !s.attrs.ManifestationTolerant {
+			s.state, s.reset = dfuStateManifestWaitReset, true
+		}
+	}
+
+	copy(data, []byte{s.status, 0x01, 0x00, 0x00, byte(state), 0x00})
+	return 6, nil
+}
+
+func TestDFU_Flash(t *testing.T) {
+	dev := newSimulatedDFU()
+	image := testFirmware()
+
+	var progress []FirmwareProgress
+	err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), image, func(p FirmwareProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if !bytes.Equal(dev.flash, image) {
+		t.Error("got different flash contents, want the image")
+	}
+	if dev.state != dfuStateIdle {
+		t.Errorf("got %s, want %s", dev.state, dfuStateIdle)
+	}
+
+	blocks := STM32F0FirmwareSize / dev.attrs.TransferSize
+	if len(progress) != 2*blocks {
+		t.Fatalf("got %d progress reports, want %d", len(progress), 2*blocks)
+	}
+	want := []FirmwareProgress{
+		{Stage: FirmwareDownload, Done: dev.attrs.TransferSize, Total: STM32F0FirmwareSize},
+		{Stage: FirmwareDownload, Done: STM32F0FirmwareSize, Total: STM32F0FirmwareSize},
+		{Stage: FirmwareVerify, Done: dev.attrs.TransferSize, Total: STM32F0FirmwareSize},
+		{Stage: FirmwareVerify, Done: STM32F0FirmwareSize, Total: STM32F0FirmwareSize},
+	}
+	for i, got := range []FirmwareProgress{progress[0], progress[blocks-1], progress[blocks], progress[2*blocks-1]} {
+		if got != want[i] {
+			t.Errorf("got %+v, want %+v", got, want[i])
+		}
+	}
+}
+
+func TestDFU_FlashShortBlock(t *testing.T) {
+	dev := newSimulatedDFU()
+	dev.flash = make([]byte, 1500)
+	image := bytes.Repeat([]byte{0xa5}, 1500)
+
+	if err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), image, nil); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if !bytes.Equal(dev.flash, image) {
+		t.Error("got different flash contents, want the image")
+	}
+}
+
+func TestDFU_FlashNotVerified(t *testing.T) {
+	dev := newSimulatedDFU()
+	dev.attrs.ManifestationTolerant = false
+	image := testFirmware()
+
+	if err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), image, nil); err != ErrNotVerified {
+		t.Errorf("got %v, want %s", err, ErrNotVerified)
+	}
+	if !bytes.Equal(dev.flash, image) {
+		t.Error("got different flash contents, want the image")
+	}
+}
+
+func TestDFU_FlashVerifyFailed(t *testing.T) {
+	dev := newSimulatedDFU()
+	dev.corrupt = true
+
+	err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), testFirmware(), nil)
+	if !errors.Is(err, ErrVerifyFailed) {
+		t.Errorf("got %v, want %s", err, ErrVerifyFailed)
+	}
+}
+
+func TestDFU_FlashWriteError(t *testing.T) {
+	dev := newSimulatedDFU()
+	dev.failBlock = 3
+
+	err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), testFirmware(), nil)
+	if e, ok := err.(*ErrDFUStatus); !ok || e.Status != dfuStatusErrWrite {
+		t.Errorf("got %v, want errWRITE", err)
+	}
+	if dev.state != dfuStateIdle {
+		t.Errorf("got %s, want %s", dev.state, dfuStateIdle)
+	}
+
+	// A new attempt recovers from the failed one.
+	dev.failBlock = -1
+	if err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), testFirmware(), nil); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+}
+
+func TestDFU_FlashFromError(t *testing.T) {
+	dev := newSimulatedDFU()
+	dev.state, dev.status = dfuStateError, dfuStatusErrStalled
+
+	if err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), testFirmware(), nil); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+}
+
+func TestDFU_FlashNotInDfuMode(t *testing.T) {
+	dev := newSimulatedDFU()
+	dev.state = dfuStateAppIdle
+
+	if err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), testFirmware(), nil); err != ErrNotInDfuMode {
+		t.Errorf("got %v, want %s", err, ErrNotInDfuMode)
+	}
+}
+
+func TestDFU_FlashNoDownload(t *testing.T) {
+	dev := newSimulatedDFU()
+	dev.attrs.CanDownload = false
+
+	if err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), testFirmware(), nil); err != ErrNoDownload {
+		t.Errorf("got %v, want %s", err, ErrNoDownload)
+	}
+}
+
+func TestDFU_FlashDfuSe(t *testing.T) {
+	dev := newSimulatedDFU()
+	dev.attrs.Version = dfuSeVersion
+
+	if err := NewDFU(dev, 0, dev.attrs).Flash(context.Background(), testFirmware(), nil); err != ErrDfuSe {
+		t.Errorf("got %v, want %s", err, ErrDfuSe)
+	}
+	if !bytes.Equal(dev.flash, make([]byte, STM32F0FirmwareSize)) {
+		t.Error("got changed flash contents, want the flash untouched")
+	}
+}
+
+func TestDFU_FlashCancelled(t *testing.T) {
+	dev := newSimulatedDFU()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := NewDFU(dev, 0, dev.attrs).Flash(ctx, testFirmware(), func(p FirmwareProgress) {
+		if p.Done == 4*dev.attrs.TransferSize {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("got %v, want %s", err, context.Canceled)
+	}
+	if dev.state != dfuStateIdle {
+		t.Errorf("got %s, want %s", dev.state, dfuStateIdle)
+	}
+	if !bytes.Equal(dev.flash, make([]byte, STM32F0FirmwareSize)) {
+		t.Error("got changed flash contents, want the flash untouched")
+	}
+}
+
+func TestParseDFUAttributes(t *testing.T) {
+	cfg := []byte{
+		0x09, 0x02, 0x1b, 0x00, 0x01, 0x01, 0x00, 0x80, 0x32, // Configuration
+		0x09, 0x04, 0x00, 0x00, 0x00, 0xfe, 0x01, 0x02, 0x00, // Interface 0: DFU mode
+		0x09, 0x21, 0x0b, 0xff, 0x00, 0x00, 0x08, 0x1a, 0x01, // DFU functional descriptor
+	}
+
+	got, err := parseDFUAttributes(cfg, 0)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	want := DFUAttributes{
+		CanDownload:   true,
+		CanUpload:     true,
+		WillDetach:    true,
+		DetachTimeout: 255 * time.Millisecond,
+		TransferSize:  2048,
+		Version:       0x011a,
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := parseDFUAttributes(cfg, 1); err == nil {
+		t.Error("got nil, want an error for an interface without a DFU functional descriptor")
+	}
+}
+
+func TestDFUTarget_Matches(t *testing.T) {
+	desc := &gousb.DeviceDesc{Vendor: 0x0483, Product: 0xdf11, Bus: 1, Path: []int{2, 4}}
+
+	tests := []struct {
+		target DFUTarget
+		want   bool
+	}{
+		{DFUTarget{}, true},
+		{DFUTarget{VendorId: 0x0483, ProductId: 0xdf11}, true},
+		{DFUTarget{VendorId: 0x0483, ProductId: 0xdf11, Bus: 1, Path: "2.4"}, true},
+		{DFUTarget{VendorId: 0x0483}, true},
+		{DFUTarget{VendorId: 0x1234, ProductId: 0xdf11}, false},
+		{DFUTarget{VendorId: 0x0483, ProductId: 0x5750}, false},
+		{DFUTarget{VendorId: 0x0483, ProductId: 0xdf11, Bus: 1, Path: "2.3"}, false},
+		{DFUTarget{VendorId: 0x0483, ProductId: 0xdf11, Bus: 2, Path: "2.4"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.target.matches(desc); got != tt.want {
+			t.Errorf("%+v: got %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
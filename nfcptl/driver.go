@@ -30,6 +30,9 @@ type Driver interface {
 	// forcing the driver to hardcode it since it will give the most flexibility in writing other
 	// drivers where auto-detection might be harder or simply incorrect.
 	Setup() any
+	// New returns a new, unconnected instance of the driver. The Client will use its own driver
+	// instance, allowing multiple devices of the same model to be driven independently.
+	New() Driver
 	// Drive is where the main driver logic sits. The client starts this function as a goroutine
 	// after the USB connection is established and the driver must take over to control the device.
 	Drive(c *Client)
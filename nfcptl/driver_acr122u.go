@@ -0,0 +1,453 @@
+package nfcptl
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// init MUST be used in drivers to register the driver by calling RegisterDriver. If the driver is
+// not registered, it will not be recognised!
+func init() {
+	RegisterDriver(&acr122u{USBProtocol: &USB{}})
+}
+
+const (
+	// ACR122U_GetData is the pseudo-APDU class and instruction to get the UID of the card. The
+	// complete pseudo-APDU is:
+	//   0xff 0xca 0x00 0x00 0x00
+	ACR122U_GetData = 0xca
+
+	// ACR122U_ReadBinary is the instruction of the pseudo-APDU reading 16 bytes starting from the
+	// given page. This is equivalent to the NTAG21x READ command:
+	//   0xff 0xb0 0x00 <page> 0x10
+	ACR122U_ReadBinary = 0xb0
+
+	// ACR122U_UpdateBinary is the instruction of the pseudo-APDU writing 4 bytes to the given
+	// page. This is equivalent to the NTAG21x WRITE command:
+	//   0xff 0xd6 0x00 <page> 0x04 <4 bytes of data>
+	ACR122U_UpdateBinary = 0xd6
+
+	// ACR122U_Direct is the instruction of the pseudo-APDU passing a command directly to the
+	// PN532 inside the reader. The parameters P1 and P2 select the function of the command:
+	//   0xff 0x00 0x00 0x00 <Lc> <PN532 command>: direct transmit
+	//   0xff 0x00 0x40 <LED state> 0x04 <T1> <T2> <repetitions> <buzzer>: LED and buzzer control
+	//   0xff 0x00 0x48 0x00 0x00: get the firmware version, e.g. "ACR122U207"
+	ACR122U_Direct = 0x00
+
+	// ACR122U_LedControl is the P1 parameter of the LED and buzzer control pseudo-APDU.
+	ACR122U_LedControl = 0x40
+	// ACR122U_GetFirmwareVersion is the P1 parameter of the get firmware version pseudo-APDU.
+	ACR122U_GetFirmwareVersion = 0x48
+
+	// ACR122U_LedOn sets the final state of the green LED to on in the LED control pseudo-APDU.
+	ACR122U_LedOn = 0x0a
+	// ACR122U_LedOff sets the final state of the green LED to off in the LED control pseudo-APDU.
+	ACR122U_LedOff = 0x08
+
+	// acr122uPollInterval is the interval at which the reader is polled for a card.
+	acr122uPollInterval = 100 * time.Millisecond
+	// acr122uMaxReply is the maximum size of a reply from the reader.
+	acr122uMaxReply = 10 + 262
+)
+
+// ErrAPDU defines the error structure returned when a reader replies to an APDU with a status word
+// other than 0x90 0x00.
+type ErrAPDU struct {
+	SW1 byte // SW1 holds the first byte of the status word.
+	SW2 byte // SW2 holds the second byte of the status word.
+}
+
+// Error implements the error interface
+func (e ErrAPDU) Error() string {
+	return fmt.Sprintf("acr122u: APDU failed with status %02x%02x", e.SW1, e.SW2)
+}
+
+// acr122u implements the Driver interface for ACR122U style PC/SC readers. These are CCID readers
+// with a PN532 NFC controller inside, so the driver talks CCID over USB directly and uses the
+// pseudo-APDUs of the reader to access the NTAG215 tokens.
+// Beware: pcscd will claim the reader when it is running, so it must be stopped first.
+type acr122u struct {
+	tokenPlaced bool // Keeps track of token state.
+	seq         byte // The sequence number of the next CCID message.
+
+	c *Client
+
+	USBProtocol // The protocol this driver works with
+}
+
+// Supports implements these USB devices:
+//
+//	ID 072f:2200 Advanced Card Systems, Ltd ACR122U
+func (acr *acr122u) Supports() []Vendor {
+	return []Vendor{
+		{
+			ID:    VIDAdvancedCardSystems,
+			Alias: VendorAdvancedCardSystems,
+			Products: []Product{
+				{
+					ID:    PIDACR122U,
+					Alias: ProductACR122U,
+				},
+			},
+		},
+	}
+}
+
+func (acr *acr122u) VendorId(alias string) (uint16, error) {
+	for _, v := range acr.Supports() {
+		if v.Alias == alias {
+			return v.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("acr122u: unknown vendor %s", alias)
+}
+
+func (acr *acr122u) ProductId(alias string) (uint16, error) {
+	for _, v := range acr.Supports() {
+		for _, pr := range v.Products {
+			if pr.Alias == alias {
+				return pr.ID, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("acr122u: unknown product %s", alias)
+}
+
+func (acr *acr122u) Setup() any {
+	return DeviceSetup{
+		Config:           1,
+		Interface:        0,
+		AlternateSetting: 0,
+		InEndpoint:       2,
+		OutEndpoint:      2,
+	}
+}
+
+func (acr *acr122u) New() Driver {
+	return &acr122u{USBProtocol: &USB{}}
+}
+
+func (acr *acr122u) Protocol() Protocol {
+	return acr.USBProtocol
+}
+
+func (acr *acr122u) SetProtocol(p Protocol) error {
+	usb, ok := p.(USBProtocol)
+	if !ok {
+		return errors.New("acr122u: protocol must implement USBProtocol")
+	}
+	acr.USBProtocol = usb
+
+	return nil
+}
+
+func (acr *acr122u) Drive(c *Client) {
+	acr.c = c
+	if acr.c.Debug() {
+		log.Println("acr122u: driving")
+	}
+
+	acr.commandListener()
+}
+
+// commandListener listens for commands sent by the Client. If no commands are received it will
+// poll the reader to check if a token is placed on the device.
+func (acr *acr122u) commandListener() {
+	ticker := time.NewTicker(acr122uPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case cmd := <-acr.c.Commands():
+				acr.handleCommand(cmd)
+			default:
+				acr.pollForToken()
+			}
+		case <-acr.c.Terminate():
+			// Ensure the LED is off and the card is powered down before termination.
+			acr.setLed(ACR122U_LedOff)
+			acr.transact(CCID_PcToRdrIccPowerOff, nil)
+			// Signal the client we're done with this goroutine informing it that it's safe to
+			// disconnect.
+			acr.c.Done()
+			return
+		}
+	}
+}
+
+// handleCommand executes the given client command.
+func (acr *acr122u) handleCommand(cmd Command) {
+	defer acr.c.Executing(cmd)()
+
+	if cmd.Context().Err() != nil {
+		acr.c.PublishEvent(NewEvent(CommandCancelled, []byte{byte(cmd.Command)}))
+		return
+	}
+
+	switch cmd.Command {
+	case GetDeviceName:
+		acr.getFirmwareVersion()
+	case SetLedState:
+		state := byte(ACR122U_LedOn)
+		if len(cmd.Arguments) > 0 && cmd.Arguments[0] == 0x00 {
+			state = ACR122U_LedOff
+		}
+		acr.setLed(state)
+	case FetchTokenData:
+		acr.fetchToken()
+	case ReadPages, WritePage, PwdAuth:
+		handlePageCommand(acr.c, acr, "acr122u", acr.tokenPlaced, cmd)
+	case WriteTokenData:
+		if cmd.Arguments == nil {
+			log.Println("acr122u: no data to write")
+			acr.c.PublishEvent(NewEvent(TokenTagWriteError, nil))
+		} else {
+			acr.write(cmd.Arguments[1:], cmd.Arguments[0])
+		}
+	default:
+		acr.c.PublishEvent(NewEvent(UnknownCommand, []byte{}))
+	}
+}
+
+// pollForToken requests the slot status from the reader. When a card is detected, it will send a
+// TokenDetected event holding the token UID followed by reading the token contents and sending it
+// to the client using the TokenTagData event. When the card has been removed, a TokenRemoved event
+// is sent.
+func (acr *acr122u) pollForToken() {
+	// Readers can flag the slot status request as failed when there is no card, the ICC status
+	// will still be valid in that case so only bail out when there is no reply at all.
+	res, err := acr.transact(CCID_PcToRdrGetSlotStatus, nil)
+	if res == nil {
+		log.Printf("%s", err)
+		return
+	}
+
+	if res.iccStatus() == CCID_IccNotPresent {
+		if acr.tokenPlaced {
+			acr.tokenPlaced = false
+			acr.setLed(ACR122U_LedOff)
+			acr.c.PublishEvent(NewEvent(TokenRemoved, nil))
+		}
+		return
+	}
+
+	if acr.tokenPlaced {
+		return
+	}
+
+	if res.iccStatus() == CCID_IccPresentInactive {
+		if _, err := acr.transact(CCID_PcToRdrIccPowerOn, nil); err != nil {
+			log.Printf("%s", err)
+			return
+		}
+	}
+
+	uid, err := acr.transmit([]byte{0xff, ACR122U_GetData, 0x00, 0x00, 0x00})
+	if err != nil {
+		log.Printf("%s", err)
+		return
+	}
+	acr.tokenPlaced = true
+	log.Printf("acr122u: token detected with id %#x", uid)
+	acr.c.PublishEvent(NewEvent(TokenDetected, uid))
+	acr.setLed(ACR122U_LedOn)
+
+	if version, err := acr.readVersion(); err == nil {
+		acr.c.PublishEvent(NewEvent(TokenTagVersion, version))
+	} else if acr.c.Debug() {
+		log.Printf("acr122u: %s", err)
+	}
+	if sig, err := acr.readSignature(); err == nil {
+		acr.c.PublishEvent(NewEvent(TokenTagSignature, sig))
+	} else if acr.c.Debug() {
+		log.Printf("acr122u: %s", err)
+	}
+
+	acr.fetchToken()
+}
+
+// fetchToken reads the token placed on the reader. It publishes the TokenTagData event or the
+// TokenTagDataError event when the token could not be read.
+func (acr *acr122u) fetchToken() {
+	fetchNTAG215(acr.c, acr, "acr122u")
+}
+
+// readPages reads four pages starting from the given page.
+func (acr *acr122u) readPages(page byte) ([]byte, error) {
+	res, err := acr.transmit([]byte{0xff, ACR122U_ReadBinary, 0x00, page, 0x10})
+	if err == nil && len(res) != 16 {
+		return nil, fmt.Errorf("acr122u: read of page %#02x returned %d bytes", page, len(res))
+	}
+
+	return res, err
+}
+
+// writePage writes four bytes of data to the given page.
+func (acr *acr122u) writePage(page byte, data []byte) error {
+	_, err := acr.transmit(append([]byte{0xff, ACR122U_UpdateBinary, 0x00, page, 0x04}, data...))
+	return err
+}
+
+// pwdAuth authenticates to the token using the given password and returns the password
+// acknowledge.
+func (acr *acr122u) pwdAuth(pwd []byte) ([]byte, error) {
+	return acr.communicateThru(append([]byte{NTAG_PwdAuth}, pwd...))
+}
+
+// readVersion returns the eight byte GET_VERSION response of the token.
+func (acr *acr122u) readVersion() ([]byte, error) {
+	return acr.communicateThru([]byte{NTAG_GetVersion})
+}
+
+// readSignature returns the 32 byte originality signature of the token.
+func (acr *acr122u) readSignature() ([]byte, error) {
+	return acr.communicateThru([]byte{NTAG_ReadSig, 0x00})
+}
+
+// communicateThru sends the given NTAG21x command as is to the token using the
+// PN532_InCommunicateThru command of the PN532 inside the reader and returns the reply of the
+// token.
+func (acr *acr122u) communicateThru(cmd []byte) ([]byte, error) {
+	pn := append([]byte{0xd4, PN532_InCommunicateThru}, cmd...)
+	res, err := acr.transmit(append([]byte{0xff, ACR122U_Direct, 0x00, 0x00, byte(len(pn))}, pn...))
+	if err != nil {
+		return nil, err
+	}
+
+	// The reply is 0xd5 0x43 <status> <data>.
+	if len(res) < 3 || res[0] != 0xd5 || res[1] != PN532_InCommunicateThru+1 {
+		return nil, fmt.Errorf("acr122u: invalid reply to command %#02x: %x", cmd[0], res)
+	}
+	if res[2] != 0x00 {
+		return nil, fmt.Errorf("acr122u: command %#02x failed with status %#02x", cmd[0], res[2])
+	}
+
+	return res[3:], nil
+}
+
+// getFirmwareVersion publishes the DeviceName event holding the firmware version of the reader.
+func (acr *acr122u) getFirmwareVersion() {
+	res, err := acr.transact(CCID_PcToRdrXfrBlock, []byte{0xff, ACR122U_Direct, ACR122U_GetFirmwareVersion, 0x00, 0x00})
+	if err != nil {
+		log.Printf("%s", err)
+		acr.c.PublishEvent(NewErrorEvent(Error, nil, err))
+		return
+	}
+
+	// The firmware version is the only pseudo-APDU that is not followed by a status word.
+	acr.c.PublishEvent(NewEvent(DeviceName, res.data))
+}
+
+// setLed sets the final state of the green LED to the given state and publishes the FrontLedOn or
+// FrontLedOff event.
+func (acr *acr122u) setLed(state byte) {
+	res, err := acr.transact(CCID_PcToRdrXfrBlock, []byte{0xff, ACR122U_Direct, ACR122U_LedControl, state, 0x04, 0x00, 0x00, 0x00, 0x00})
+	// The LED control pseudo-APDU returns the LED state as SW2.
+	if err != nil || len(res.data) != 2 || res.data[0] != 0x90 {
+		log.Printf("acr122u: failed to set LED state %#02x", state)
+		return
+	}
+
+	if state == ACR122U_LedOff {
+		acr.c.PublishEvent(NewEvent(FrontLedOff, nil))
+	} else {
+		acr.c.PublishEvent(NewEvent(FrontLedOn, nil))
+	}
+}
+
+// write writes the given amiibo data to the token using the shared NTAG215 write procedure.
+func (acr *acr122u) write(data []byte, mode byte) {
+	writeNTAG215(acr.c, acr, "acr122u", acr.tokenPlaced, data, mode)
+}
+
+// transmit sends the given APDU to the reader and returns the reply without the status word. An
+// ErrAPDU error is returned when the status word is not 0x90 0x00.
+func (acr *acr122u) transmit(apdu []byte) ([]byte, error) {
+	res, err := acr.transact(CCID_PcToRdrXfrBlock, apdu)
+	if err != nil {
+		return nil, err
+	}
+
+	l := len(res.data)
+	if l < 2 {
+		return nil, fmt.Errorf("acr122u: APDU reply too short: %x", res.data)
+	}
+	if sw1, sw2 := res.data[l-2], res.data[l-1]; sw1 != 0x90 || sw2 != 0x00 {
+		return nil, ErrAPDU{SW1: sw1, SW2: sw2}
+	}
+
+	return res.data[:l-2], nil
+}
+
+// transact sends a CCID message of the given type to the reader and returns the reply. An ErrCCID
+// error is returned when the reader failed to process the message.
+func (acr *acr122u) transact(typ CCIDMessageType, data []byte) (*ccidMessage, error) {
+	msg := &ccidMessage{typ: typ, seq: acr.seq, data: data}
+	acr.seq++
+
+	if acr.c.Debug() {
+		log.Println("acr122u: sending message:")
+		log.Println(hex.Dump(msg.marshal()))
+	}
+	if _, err := acr.Write(msg.marshal()); err != nil {
+		if IsDeviceGone(err) {
+			acr.c.DeviceLost(err)
+		}
+		return nil, fmt.Errorf("acr122u: %v", err)
+	}
+
+	for {
+		res, err := acr.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if acr.c.Debug() {
+			log.Println("acr122u: reply:")
+			log.Println(hex.Dump(res.marshal()))
+		}
+		if res.seq != msg.seq {
+			// Not the reply we're waiting for, the reader might still be replying to a previous
+			// message we gave up on.
+			continue
+		}
+
+		switch res.commandStatus() {
+		case CCID_TimeExtension:
+			continue
+		case CCID_CommandFailed:
+			return res, ErrCCID{Type: typ, Code: res.params[1]}
+		}
+
+		return res, nil
+	}
+}
+
+// readMessage reads a single CCID message from the reader.
+func (acr *acr122u) readMessage() (*ccidMessage, error) {
+	var b []byte
+	for {
+		buf := make([]byte, acr122uMaxReply)
+		n, err := acr.Read(buf)
+		if err != nil {
+			if IsDeviceGone(err) {
+				acr.c.DeviceLost(err)
+			}
+			return nil, fmt.Errorf("acr122u: %v", err)
+		}
+		if n == 0 {
+			return nil, errors.New("acr122u: empty reply")
+		}
+		b = append(b, buf[:n]...)
+
+		if l, err := ccidMessageLength(b); err == nil && len(b) >= l {
+			return unmarshalCCIDMessage(b)
+		}
+	}
+}
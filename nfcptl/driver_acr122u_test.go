@@ -0,0 +1,263 @@
+package nfcptl
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// simReader simulates an ACR122U reader with an NTAG215 token at the Protocol level.
+type simReader struct {
+	mu      sync.Mutex
+	token   []byte // The NTAG215 memory of the token on the reader, nil when there is no token.
+	powered bool
+	authed  bool
+
+	replies chan []byte
+}
+
+func newSimReader() *simReader {
+	return &simReader{replies: make(chan []byte, 1)}
+}
+
+func (r *simReader) Connect(c *Client) error     { return nil }
+func (r *simReader) Disconnect() error           { return nil }
+func (r *simReader) SetIdle(val, idx uint16)     {}
+func (r *simReader) PollInterval() time.Duration { return time.Millisecond }
+func (r *simReader) MaxPacketSize() int          { return 64 }
+func (r *simReader) Read(p []byte) (int, error)  { return copy(p, <-r.replies), nil }
+
+// placeToken places the given token on the reader, passing nil removes the token.
+func (r *simReader) placeToken(token []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.token = token
+}
+
+// tokenData returns the memory of the token on the reader.
+func (r *simReader) tokenData() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.token
+}
+
+// reply queues the given message holding data as the reply to be read.
+func (r *simReader) reply(m *ccidMessage, data ...byte) {
+	m.data = data
+	r.replies <- m.marshal()
+}
+
+func (r *simReader) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	req, err := unmarshalCCIDMessage(p)
+	if err != nil {
+		return 0, err
+	}
+
+	res := &ccidMessage{typ: CCID_RdrToPcSlotStatus, seq: req.seq}
+	switch {
+	case r.token == nil:
+		res.params[0] = 0x40 | CCID_IccNotPresent
+		res.params[1] = 0xfe
+	case !r.powered:
+		res.params[0] = CCID_IccPresentInactive
+	}
+
+	switch req.typ {
+	case CCID_PcToRdrGetSlotStatus:
+		if r.token == nil {
+			r.powered, r.authed = false, false
+			// The reader does not flag a missing card as an error on a slot status request.
+			res.params[0] = CCID_IccNotPresent
+		}
+		r.reply(res)
+	case CCID_PcToRdrIccPowerOff:
+		r.powered, r.authed = false, false
+		r.reply(res)
+	case CCID_PcToRdrIccPowerOn:
+		res.typ = CCID_RdrToPcDataBlock
+		if r.token == nil {
+			r.reply(res)
+			break
+		}
+		r.powered = true
+		res.params[0] = CCID_IccPresentActive
+		r.reply(res, 0x3b, 0x8f, 0x80, 0x01, 0x80, 0x4f, 0x0c, 0xa0, 0x00, 0x00, 0x03, 0x06, 0x03, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x68)
+	case CCID_PcToRdrXfrBlock:
+		res.typ = CCID_RdrToPcDataBlock
+		if req.data[1] == ACR122U_Direct && req.data[2] != 0x00 {
+			// Pseudo-APDUs for the reader itself do not need a card.
+			res.params = [3]byte{}
+		}
+		r.reply(res, r.apdu(req.data)...)
+	}
+
+	return len(p), nil
+}
+
+// apdu returns the reply to the given pseudo-APDU.
+func (r *simReader) apdu(apdu []byte) []byte {
+	ok := []byte{0x90, 0x00}
+	fail := []byte{0x63, 0x00}
+
+	switch {
+	case apdu[1] == ACR122U_Direct && apdu[2] == ACR122U_GetFirmwareVersion:
+		return []byte("ACR122U207")
+	case apdu[1] == ACR122U_Direct && apdu[2] == ACR122U_LedControl:
+		return []byte{0x90, apdu[3] & 0x03}
+	case r.token == nil || !r.powered:
+		return fail
+	case apdu[1] == ACR122U_GetData:
+		return append(virtualUid(r.token), ok...)
+	case apdu[1] == ACR122U_ReadBinary:
+		var data []byte
+		for i := 0; i < 4; i++ {
+			page := (int(apdu[3]) + i) % 0x87
+			if page >= 0x85 {
+				// PWD and PACK always read as zero.
+				data = append(data, 0x00, 0x00, 0x00, 0x00)
+			} else {
+				data = append(data, r.token[page*4:page*4+4]...)
+			}
+		}
+		return append(data, ok...)
+	case apdu[1] == ACR122U_UpdateBinary:
+		page := int(apdu[3])
+		if auth0 := int(r.token[0x83*4+3]); page >= auth0 && !r.authed {
+			return fail
+		}
+		copy(r.token[page*4:], apdu[5:9])
+		return ok
+	case apdu[1] == ACR122U_Direct && apdu[6] == PN532_InCommunicateThru:
+		switch apdu[7] {
+		case NTAG_PwdAuth:
+			if !bytes.Equal(apdu[8:12], r.token[532:536]) {
+				return []byte{0xd5, 0x43, 0x01, 0x90, 0x00}
+			}
+			r.authed = true
+			return append(append([]byte{0xd5, 0x43, 0x00}, r.token[536:538]...), ok...)
+		case NTAG_GetVersion:
+			return append(append([]byte{0xd5, 0x43, 0x00}, ntag215Version...), ok...)
+		case NTAG_ReadSig:
+			return append(append([]byte{0xd5, 0x43, 0x00}, make([]byte, 32)...), ok...)
+		}
+	}
+
+	return []byte{0x6a, 0x81}
+}
+
+// newACR122UClient returns a connected Client using the acr122u driver talking to a simReader.
+func newACR122UClient(t *testing.T, token []byte) (*Client, *simReader) {
+	c, err := NewClient(VendorAdvancedCardSystems, ProductACR122U, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	r := newSimReader()
+	r.placeToken(token)
+	if err := c.Driver().(ProtocolDriver).SetProtocol(r); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	t.Cleanup(func() {
+		go func() {
+			for range c.Events() {
+			}
+		}()
+		c.Disconnect()
+	})
+
+	return c, r
+}
+
+// ntag215Version is the GET_VERSION response of an NTAG215.
+var ntag215Version = []byte{0x00, 0x04, 0x04, 0x02, 0x01, 0x00, 0x11, 0x03}
+
+// readTokenData returns the token as read by a reader: the PWD and PACK pages read as zero.
+func readTokenData(token []byte) []byte {
+	read := append([]byte{}, token...)
+	copy(read[532:], make([]byte, 8))
+	return read
+}
+
+func TestAcr122u_DetectToken(t *testing.T) {
+	token := testToken()
+	c, r := newACR122UClient(t, token)
+
+	evs := expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagVersion, TokenTagSignature, TokenTagData)
+	if want := virtualUid(token); !bytes.Equal(evs[0].Data(), want) {
+		t.Errorf("got %x, want %x", evs[0].Data(), want)
+	}
+	if !bytes.Equal(evs[2].Data(), ntag215Version) {
+		t.Errorf("got %x, want %x", evs[2].Data(), ntag215Version)
+	}
+	if want := make([]byte, 32); !bytes.Equal(evs[3].Data(), want) {
+		t.Errorf("got %x, want %x", evs[3].Data(), want)
+	}
+	if want := readTokenData(token); !bytes.Equal(evs[4].Data(), want) {
+		t.Errorf("got %x, want %x", evs[4].Data(), want)
+	}
+
+	r.placeToken(nil)
+	expectEvents(t, c, FrontLedOff, TokenRemoved)
+}
+
+func TestAcr122u_WriteTokenData(t *testing.T) {
+	c, r := newACR122UClient(t, testToken())
+	expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagVersion, TokenTagSignature, TokenTagData)
+
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: []byte{0x01, 0x02}})
+	expectEvents(t, c, TokenTagDataSizeError)
+
+	// Wrong password.
+	data := make([]byte, 540)
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{0x01}, data...)})
+	expectEvents(t, c, TokenTagWriteStart, TokenTagWriteError)
+
+	copy(data[532:538], testToken()[532:538])
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{0x01}, data...)})
+	evs := expectEvents(t, c, TokenTagWriteStart, TokenTagWriteLocked)
+	want := []byte{0x08, 0x09, 0x0b, 0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f}
+	if !bytes.Equal(evs[1].Data(), want) {
+		t.Errorf("got %#02x, want %#02x", evs[1].Data(), want)
+	}
+
+	// Locked pages must keep their data.
+	copy(data[0x08*4:0x0c*4], testToken()[0x08*4:0x0c*4])
+	copy(data[0x40*4:0x50*4], testToken()[0x40*4:0x50*4])
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{0x01}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, ntag215WriteOrder(true))
+	evs = expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+
+	want = testToken()
+	copy(want[16:520], data[16:520])
+	if got := r.tokenData(); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+	if !bytes.Equal(evs[1].Data(), readTokenData(want)) {
+		t.Errorf("got %x, want %x", evs[1].Data(), readTokenData(want))
+	}
+}
+
+func TestAcr122u_Commands(t *testing.T) {
+	c, _ := newACR122UClient(t, nil)
+
+	c.SendCommand(Command{Command: GetDeviceName})
+	evs := expectEvents(t, c, DeviceName)
+	if got, want := string(evs[0].Data()), "ACR122U207"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	c.SendCommand(Command{Command: FetchTokenData})
+	expectEvents(t, c, TokenTagDataError)
+
+	c.SendCommand(Command{Command: GetApiPassword})
+	expectEvents(t, c, UnknownCommand)
+}
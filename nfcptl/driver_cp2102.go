@@ -1,6 +1,7 @@
 package nfcptl
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log"
@@ -12,7 +13,7 @@ import (
 // init MUST be used in drivers to register the driver by calling RegisterDriver. If the driver is
 // not registered, it will not be recognised!
 func init() {
-	RegisterDriver(&cp2102{UART: &UART{}})
+	RegisterDriver(&cp2102{UARTProtocol: &UART{}})
 }
 
 type CP2102Register byte
@@ -107,17 +108,19 @@ var (
 	cp2102ErrInvalid         = errors.New("invalid")
 	cp2102ErrMifareNack      = errors.New("mifare NACK")
 	cp2102ErrNoRoom          = errors.New("no room")
+	cp2102ErrNoToken         = errors.New("no token present")
 	cp2102ErrTransferTimeout = errors.New("transfer timeout")
 	cp2102ErrWrongCRC        = errors.New("wrong CRC")
 )
 
 type cp2102 struct {
 	tokenMu     sync.Mutex
-	tokenPlaced bool // Keeps track of token state.
+	tokenPlaced bool   // Keeps track of token state.
+	uid         []byte // The UID of the token placed on the device.
 
 	c *Client
 
-	*UART // The protocol this driver works with
+	UARTProtocol // The protocol this driver works with
 }
 
 func (cp *cp2102) Supports() []Vendor {
@@ -158,13 +161,31 @@ func (cp *cp2102) ProductId(alias string) (uint16, error) {
 }
 
 func (cp *cp2102) Setup() any {
-	// TODO: how to get the port name here?
+	// The port depends on the system, use Client.SetSerial to set it.
 	return Serial{
 		Port: "",
 		Baud: 9600,
 	}
 }
 
+func (cp *cp2102) New() Driver {
+	return &cp2102{UARTProtocol: &UART{}}
+}
+
+func (cp *cp2102) Protocol() Protocol {
+	return cp.UARTProtocol
+}
+
+func (cp *cp2102) SetProtocol(p Protocol) error {
+	uart, ok := p.(UARTProtocol)
+	if !ok {
+		return errors.New("cp2102: protocol must implement UARTProtocol")
+	}
+	cp.UARTProtocol = uart
+
+	return nil
+}
+
 func (cp *cp2102) Drive(c *Client) {
 	cp.c = c
 
@@ -198,11 +219,7 @@ func (cp *cp2102) commandListener() {
 		case <-ticker.C:
 			select {
 			case cmd := <-cp.c.Commands():
-				if dc, err := cp.getDriverCommandForClientCommand(cmd.Command); err != nil {
-					cp.c.PublishEvent(NewEvent(UnknownCommand, []byte{}))
-				} else {
-					cp.sendCommand(dc)
-				}
+				cp.handleCommand(cmd)
 			default:
 				cp.scanForTags()
 			}
@@ -213,34 +230,163 @@ func (cp *cp2102) commandListener() {
 		}
 	}
 }
+
+// handleCommand executes the given client command.
+func (cp *cp2102) handleCommand(cmd Command) {
+	defer cp.c.Executing(cmd)()
+
+	if cmd.Context().Err() != nil {
+		cp.c.PublishEvent(NewEvent(CommandCancelled, []byte{byte(cmd.Command)}))
+		return
+	}
+
+	switch cmd.Command {
+	case ListBanks, SetActiveBank, SetBankCount, ReadBank, EraseBank:
+		cp.handleBankCommand(cmd)
+	case WriteBank:
+		cp.writeBank(cmd.Arguments)
+	default:
+		if dc, err := cp.getDriverCommandForClientCommand(cmd.Command); err != nil {
+			cp.c.PublishEvent(NewEvent(UnknownCommand, []byte{}))
+		} else {
+			cp.sendCommand(dc)
+		}
+	}
+}
+
+// handleBankCommand executes the given N2 Elite bank command. When the command fails, the BankError
+// event is published holding the ClientCommand.
+func (cp *cp2102) handleBankCommand(cmd Command) {
+	var e *Event
+	err := cp2102ErrNoToken
+	if cp.isTokenPlaced() {
+		switch {
+		case cmd.Command == ListBanks:
+			var data []byte
+			if data, err = cp.getAllCharIds(); err == nil {
+				e = NewEvent(BankList, data)
+			}
+		case len(cmd.Arguments) < 1:
+			err = cp2102ErrInvalid
+		case cmd.Command == SetActiveBank:
+			if err = cp.setActiveBank(cmd.Arguments[0]); err == nil {
+				e = NewEvent(ActiveBankSet, cmd.Arguments[:1])
+			}
+		case cmd.Command == SetBankCount:
+			if err = cp.setMaxBanks(cmd.Arguments[0]); err == nil {
+				e = NewEvent(BankCountSet, cmd.Arguments[:1])
+			}
+		case cmd.Command == ReadBank:
+			var data []byte
+			if data, err = cp.readBank(cmd.Arguments[0]); err == nil {
+				e = NewEvent(BankData, data)
+			}
+		case cmd.Command == EraseBank:
+			if err = cp.eraseBank(cmd.Arguments[0]); err == nil {
+				e = NewEvent(BankErased, cmd.Arguments[:1])
+			}
+		}
+	}
+
+	if err != nil {
+		log.Printf("cp2102: %s failed: %s", cmd.Command, err)
+		e = NewEvent(BankError, []byte{byte(cmd.Command)})
+	}
+	cp.c.PublishEvent(e)
+}
+
+// writeBank writes the amiibo data in the arguments to the bank in the first argument and publishes
+// the same events as the WriteTokenData command.
+func (cp *cp2102) writeBank(args []byte) {
+	if len(args) < 1 || (len(args[1:]) != 540 && len(args[1:]) != 572) {
+		log.Println("cp2102: data must be 540 or 572 bytes long")
+		var data []byte
+		if len(args) > 0 {
+			data = args[1:]
+		}
+		cp.c.PublishEvent(NewEvent(TokenTagDataSizeError, data))
+		return
+	}
+
+	cp.c.PublishEvent(NewEvent(TokenTagWriteStart, nil))
+	if !cp.isTokenPlaced() {
+		log.Println("cp2102: write failed, no token present")
+		cp.c.PublishEvent(NewEvent(TokenTagWriteError, nil))
+		return
+	}
+
+	if err := cp.writeTag(args[1:], args[0]); err != nil {
+		log.Printf("cp2102: writing bank #%d failed: %s", args[0]+1, err)
+		cp.c.PublishEvent(NewErrorEvent(TokenTagWriteError, nil, err))
+		return
+	}
+
+	cp.c.PublishEvent(NewEvent(TokenTagWriteFinish, nil))
+}
+
+// isTokenPlaced returns true when a token is present on the device.
+func (cp *cp2102) isTokenPlaced() bool {
+	cp.tokenMu.Lock()
+	defer cp.tokenMu.Unlock()
+
+	return cp.tokenPlaced
+}
+
+// scanForTags checks if a token is present and selects it so that it is ready to receive commands.
+// When a new token is detected, the TokenDetected event holding the token UID is published. When
+// the token is gone, the TokenRemoved event is published.
 func (cp *cp2102) scanForTags() {
+	uid, err := cp.selectTag()
+
+	cp.tokenMu.Lock()
+	defer cp.tokenMu.Unlock()
+
+	if cp.tokenPlaced && (err != nil || !bytes.Equal(uid, cp.uid)) {
+		cp.tokenPlaced = false
+		cp.uid = nil
+		cp.c.PublishEvent(NewEvent(TokenRemoved, nil))
+	}
+	if err == nil && !cp.tokenPlaced {
+		cp.tokenPlaced = true
+		cp.uid = uid
+		log.Printf("cp2102: token detected with id %#x", uid)
+		cp.c.PublishEvent(NewEvent(TokenDetected, uid))
+	}
+}
+
+// selectTag looks for a tag and selects it, returning its UID. A tag that has been selected before
+// does not reply to the first request since it only replies when idle. The request sets the tag
+// back to idle though, so a second request is sent before giving up.
+func (cp *cp2102) selectTag() ([]byte, error) {
 	cp.init()
-	if !cp.isNewCardPresent() {
-		// TODO: Send event tag gone
-	} else if uid, err := cp.readCardSerial(); err != nil {
-		// TODO: Send event tag gone
-	} else {
-		// TODO: Send event new tag with uid
-		_ = uid
+	if !cp.isNewCardPresent() && !cp.isNewCardPresent() {
+		return nil, cp2102ErrNoToken
 	}
+
+	return cp.readCardSerial()
 }
 
+// setActiveBank selects the N2 Elite bank that is presented to a reader.
 func (cp *cp2102) setActiveBank(bank byte) error {
-	return cp.transceiveDataWithCRC([]byte{0xa7, bank}, nil)
+	_, err := cp.transceiveDataWithCRC([]byte{0xa7, bank})
+	return err
 }
 
+// setMaxBanks sets the number of banks of the N2 Elite.
 func (cp *cp2102) setMaxBanks(max byte) error {
-	return cp.transceiveDataWithCRC([]byte{0xa9, max}, nil)
+	_, err := cp.transceiveDataWithCRC([]byte{0xa9, max})
+	return err
 }
 
+// readBank reads all 572 bytes of the given N2 Elite bank.
 func (cp *cp2102) readBank(bank byte) ([]byte, error) {
 	output := make([]byte, 572)
 	request := []byte{0x3b, 0x00, 0x00, bank}
 	for i := 0; i < 142; i += 14 {
 		request[1] = byte(i)
 		request[2] = byte(math.Min(142, float64(i+14)))
-		result := make([]byte, 64)
-		if err := cp.transceiveDataWithCRC(request, result); err != nil {
+		result, err := cp.transceiveDataWithCRC(request)
+		if err != nil {
 			return nil, err
 		}
 		copy(output[i*4:], result)
@@ -250,10 +396,13 @@ func (cp *cp2102) readBank(bank byte) ([]byte, error) {
 }
 
 func (cp *cp2102) unlock() error {
-	result := make([]byte, 64)
-	if err := cp.transceiveDataWithCRC([]byte{0x3a, 0x00, 0x00}, result); err != nil {
+	result, err := cp.transceiveDataWithCRC([]byte{0x3a, 0x00, 0x00})
+	if err != nil {
 		return err
 	}
+	if len(result) < 4 {
+		return cp2102ErrInvalid
+	}
 
 	data := make([]byte, 5)
 	data[0] = 0x1b
@@ -261,8 +410,8 @@ func (cp *cp2102) unlock() error {
 		data[i+1] = result[i]
 	}
 
-	result = make([]byte, 64)
-	if err := cp.transceiveDataWithCRC(data, result); err != nil {
+	result, err = cp.transceiveDataWithCRC(data)
+	if err != nil {
 		return err
 	}
 
@@ -273,6 +422,8 @@ func (cp *cp2102) unlock() error {
 	return errors.New("failed to authenticate")
 }
 
+// writeTag writes the given data to the given N2 Elite bank, the remaining pages of the bank are
+// erased. The TokenTagWriteProgress event is published for each page of the given data.
 func (cp *cp2102) writeTag(data []byte, bank byte) error {
 	if len(data) != 540 && len(data) != 572 {
 		return errors.New("data must be 540 or 572 bytes long")
@@ -298,21 +449,23 @@ func (cp *cp2102) writeTag(data []byte, bank byte) error {
 					return nil
 				}
 				buffer[1] = num3
-				if err := cp.transceiveDataWithCRC(data, make([]byte, 64)); err != nil {
+				if _, err := cp.transceiveDataWithCRC(buffer); err != nil {
 					return err
 				}
 				num3++
 			}
 		}
-		buffer[1] = (page * 4) / 4
+		publishWriteProgress(cp.c, int(page), int(page)+1, int(totalPages), 0)
+		buffer[1] = page
 		copy(buffer[3:7], data[page*4:])
-		if err := cp.transceiveDataWithCRC(buffer, make([]byte, 64)); err != nil {
+		if _, err := cp.transceiveDataWithCRC(buffer); err != nil {
 			return err
 		}
 		page++
 	}
 }
 
+// eraseBank erases the given N2 Elite bank by setting all bytes to 0xff.
 func (cp *cp2102) eraseBank(bank byte) error {
 	if err := cp.unlock(); err != nil {
 		return err
@@ -327,64 +480,56 @@ func (cp *cp2102) eraseBank(bank byte) error {
 			return nil
 		}
 		data[1] = page
-		if err := cp.transceiveDataWithCRC(data, make([]byte, 64)); err != nil {
+		if _, err := cp.transceiveDataWithCRC(data); err != nil {
 			return err
 		}
 		page++
 	}
 }
 
-// TODO: finish this function. We have to look at properly handling length and actually return the
-//  data read.
+// getAllCharIds returns the active bank and the number of banks followed by the eight byte
+// character ID of each bank as described by the BankList event. A normal NTAG215 is reported as an
+// N2 Elite with a single bank.
 func (cp *cp2102) getAllCharIds() ([]byte, error) {
-	if err := cp.transceiveDataWithCRC([]byte{0x60}, make([]byte, 64)); err != nil {
-		return nil, errors.New("cp2102: unsupported NFC tag found")
+	if _, err := cp.transceiveDataWithCRC([]byte{0x60}); err != nil {
+		return nil, errors.New("unsupported NFC tag found")
 	}
 
-	buffer := make([]byte, 64)
-	if err := cp.transceiveDataWithCRC([]byte{0x55}, buffer); err == nil {
+	if buffer, err := cp.transceiveDataWithCRC([]byte{0x55}); err == nil && len(buffer) >= 2 {
 		currentBank := buffer[0]
 		totalBanks := buffer[1]
-		data := make([]byte, totalBanks)
 		log.Printf("cp2102: n2elite found with %d banks, active bank %d", totalBanks, currentBank)
 		if (len(buffer) != 4 || buffer[3] == 3) && ((len(buffer) != 2 || currentBank != 100) || totalBanks != 0) {
+			data := []byte{currentBank, totalBanks}
 			for i := byte(0); i < totalBanks; i++ {
-				tagId := make([]byte, 64)
-				charId := make([]byte, 64)
-				if err := cp.transceiveDataWithCRC([]byte{0x3b, 0x00, 0x01, i}, tagId); err != nil {
-					log.Printf("cp2102: could not get tag ID from bank %d", i)
-					continue
-				}
-				if err := cp.transceiveDataWithCRC([]byte{0x3b, 0x15, 0x16, i}, charId); err != nil {
-					log.Printf("cp2102: could not get character ID from bank %d", i)
-					continue
+				charId, err := cp.transceiveDataWithCRC([]byte{0x3b, 0x15, 0x16, i})
+				if err != nil {
+					return nil, fmt.Errorf("could not get character ID from bank %d: %s", i, err)
 				}
-				if len(tagId) != 8 || len(charId) != 8 {
-					log.Printf("cp2102: invalid data length for bank %d", i)
-					continue
+				if len(charId) != 8 {
+					return nil, fmt.Errorf("invalid data length for bank %d", i)
 				}
-				// TODO: add to data variable.
+				data = append(data, charId...)
 			}
 			return data, nil
 		}
 		log.Println("cp2102: your tag's firmware is outdated, please upgrade")
 	}
 
-	cp.reset()
-	log.Println("cp2102: normal NTAG NFC tag found")
-	data := []byte{0x3a, 0x00, 0x01}
-	tagId := make([]byte, 64)
-	if err := cp.transceiveDataWithCRC(data, tagId); err != nil {
+	// The tag does not reply to unknown commands until it has been selected again.
+	if _, err := cp.selectTag(); err != nil {
 		return nil, err
 	}
-	charId := make([]byte, 64)
-	buffer6 := []byte{0x3a, 0x15, 0x16}
-	if err := cp.transceiveDataWithCRC(buffer6, charId); err != nil {
+	log.Println("cp2102: normal NTAG NFC tag found")
+	charId, err := cp.transceiveDataWithCRC([]byte{0x3a, 0x15, 0x16})
+	if err != nil {
 		return nil, err
 	}
+	if len(charId) != 8 {
+		return nil, errors.New("invalid character ID length")
+	}
 
-	// TODO: fill in tagId + charId
-	return []byte{}, nil
+	return append([]byte{0x00, 0x01}, charId...), nil
 }
 
 func (cp *cp2102) init() error {
@@ -472,110 +617,129 @@ func (cp *cp2102) calculateCRC(data []byte) ([]byte, error) {
 	return b, nil
 }
 
-// TODO: try to return result and validBits since they are 'filled in' by the original C# function
-//  The caller will need to know the length of the actual data returned!
-func (cp *cp2102) transceiveData(data []byte, result []byte, validBits byte, rxAlign byte, checkCRC bool) (byte, error) {
+// transceiveData sends data to the PICC and returns the data received along with the number of
+// valid bits in the last received byte, zero meaning the whole byte is valid. The length of result
+// is the maximum number of bytes that can be received, the received bytes are copied into it.
+func (cp *cp2102) transceiveData(data []byte, result []byte, validBits byte, rxAlign byte, checkCRC bool) ([]byte, byte, error) {
 	waitIRq := byte(0x30)
 	num2 := byte(0)
 	num4 := validBits
 	num5 := (rxAlign << 4) + num4
 	if err := cp.writeCommand(CP2102_Idle); err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	if err := cp.writeRegister(CP2102_ComIrqReg, []byte{0x7f}); err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	if err := cp.setRegisterBits(CP2102_FIFOLevelReg, 0x80); err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	if err := cp.writeRegister(CP2102_FIFODataReg, data); err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	if err := cp.writeRegister(CP2102_BitFramingReg, []byte{num5}); err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	if err := cp.writeCommand(CP2102_Transceive); err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	if err := cp.setRegisterBits(CP2102_BitFramingReg, 0x80); err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	num3 := uint(0x7d0)
 	for {
 		count, err := cp.readRegister(CP2102_ComIrqReg)
 		if err != nil {
-			return 0, err
+			return nil, 0, err
 		}
 		if (count & waitIRq) != 0 {
 			num6, err := cp.readRegister(CP2102_ErrorReg)
 			if err != nil {
-				return 0, err
+				return nil, 0, err
 			}
 			if (num6 & 0x13) != 0 {
-				return 0, cp2102ErrGeneric
+				return nil, 0, cp2102ErrGeneric
 			}
 			if result != nil && len(result) > 0 {
 				count, err = cp.readRegister(CP2102_FIFOLevelReg)
 				if err != nil {
-					return 0, err
+					return nil, 0, err
 				}
 				if int(count) > len(result) {
-					return 0, cp2102ErrNoRoom
+					return nil, 0, cp2102ErrNoRoom
 				}
-				if result, err = cp.readRegisterMultibyte(CP2102_FIFODataReg, count, rxAlign); err != nil {
-					return 0, err
+				b, err := cp.readRegisterMultibyte(CP2102_FIFODataReg, count, rxAlign)
+				if err != nil {
+					return nil, 0, err
 				}
+				result = result[:copy(result, b)]
 				res, err := cp.readRegister(CP2102_ControlReg)
 				if err != nil {
-					return 0, err
+					return nil, 0, err
 				}
 				validBits = res & 7
 				num2 = validBits
 			}
 			if (num6 & 8) != 0 {
-				return 0, cp2102ErrCollision
+				return nil, 0, cp2102ErrCollision
 			}
 			if result != nil && len(result) > 0 && checkCRC {
 				if len(result) == 1 && num2 == 4 {
-					return 0, cp2102ErrMifareNack
+					return nil, 0, cp2102ErrMifareNack
 				}
 				if len(result) < 2 || num2 != 0 {
-					return 0, cp2102ErrWrongCRC
+					return nil, 0, cp2102ErrWrongCRC
 				}
 				res, err := cp.calculateCRC(result[:len(result)-2])
 				if err != nil {
-					return 0, err
+					return nil, 0, err
 				}
 				if result[len(result)-2] != res[0] || result[len(result)-1] != res[1] {
-					return 0, cp2102ErrWrongCRC
+					return nil, 0, cp2102ErrWrongCRC
 				}
 			}
-			return validBits, nil
+			if result == nil || len(result) == 0 {
+				return nil, validBits, nil
+			}
+			return result, validBits, nil
 		}
 		if (count & 1) != 0 {
-			return 0, cp2102ErrTransferTimeout
+			return nil, 0, cp2102ErrTransferTimeout
 		}
 		num3--
 		if num3 == 0 {
-			return 0, cp2102ErrTransferTimeout
+			return nil, 0, cp2102ErrTransferTimeout
 		}
 	}
 }
 
-func (cp *cp2102) transceiveDataWithCRC(data []byte, result []byte) error {
+// transceiveDataWithCRC sends data followed by its CRC to the PICC and returns the reply without
+// the CRC. A four bit reply is an ACK or NAK, a NAK is returned as cp2102ErrMifareNack.
+func (cp *cp2102) transceiveDataWithCRC(data []byte) ([]byte, error) {
 	send := make([]byte, len(data)+2)
 	copy(send, data)
-	send[len(data)], send[len(data)+1] = cp.computeCRC(send)
-	_, err := cp.transceiveData(send, result, 0, 0, false)
-	return err
-}
-
-func (cp *cp2102) transceive(data []byte) ([]byte, error) {
-	result := make([]byte, 64)
-	if err := cp.transceiveDataWithCRC(data, result); err != nil {
+	send[len(data)], send[len(data)+1] = cp.computeCRC(data)
+	res, validBits, err := cp.transceiveData(send, make([]byte, 64), 0, 0, false)
+	if err != nil {
 		return nil, err
 	}
-	return result[:len(result)-2], nil
+
+	if validBits == 4 && len(res) == 1 {
+		if res[0]&0x0f != 0x0a {
+			return nil, cp2102ErrMifareNack
+		}
+		return res, nil
+	}
+	if len(res) < 3 {
+		return res, nil
+	}
+
+	l := len(res) - 2
+	if crc1, crc2 := cp.computeCRC(res[:l]); crc1 != res[l] || crc2 != res[l+1] {
+		return nil, cp2102ErrWrongCRC
+	}
+
+	return res[:l], nil
 }
 
 func (cp *cp2102) isNewCardPresent() bool {
@@ -592,9 +756,9 @@ func (cp *cp2102) requestAOrWakeupA(cmd DriverCommand, bufferATQA []byte) error
 		return err
 	}
 
-	validBits, err := cp.transceiveData([]byte{byte(cmd)}, bufferATQA, 7, 0, false)
+	atqa, validBits, err := cp.transceiveData([]byte{byte(cmd)}, bufferATQA, 7, 0, false)
 	if err == nil {
-		if len(bufferATQA) != 2 || validBits != 0 {
+		if len(atqa) != 2 || validBits != 0 {
 			return cp2102ErrGeneric
 		}
 		return nil
@@ -697,7 +861,7 @@ func (cp *cp2102) readCardSerial() ([]byte, error) {
 							return nil, cp2102ErrGeneric
 						}
 						result := make([]byte, 2)
-						result, err = cp.calculateCRC(data)
+						result, err = cp.calculateCRC(data[:1])
 						if err != nil {
 							return nil, err
 						}
@@ -719,6 +883,8 @@ func (cp *cp2102) readCardSerial() ([]byte, error) {
 				}
 				break
 			}
+			// Only the known part of the UID is sent in an anticollision command.
+			send := sourceArray
 			if num6 < 0x20 {
 				num9 = num6 % 8
 				sourceIndex = 2 + (num6 / 8)
@@ -726,10 +892,14 @@ func (cp *cp2102) readCardSerial() ([]byte, error) {
 				length = len(sourceArray) - int(sourceIndex)
 				data = make([]byte, length)
 				copy(data[:length], sourceArray[sourceIndex:])
+				send = sourceArray[:sourceIndex]
+				if num9 != 0 {
+					send = sourceArray[:sourceIndex+1]
+				}
 			} else {
 				sourceArray[1] = 0x70
 				sourceArray[6] = ((sourceArray[2] ^ sourceArray[3]) ^ sourceArray[4]) ^ sourceArray[5]
-				result, err := cp.calculateCRC(sourceArray)
+				result, err := cp.calculateCRC(sourceArray[:7])
 				if err != nil {
 					return nil, err
 				}
@@ -742,7 +912,7 @@ func (cp *cp2102) readCardSerial() ([]byte, error) {
 			if err := cp.writeRegister(CP2102_BitFramingReg, []byte{(rxAlign << 4) + num9}); err != nil {
 				return nil, err
 			}
-			num9, err = cp.transceiveData(sourceArray, data, num9, rxAlign, false)
+			_, num9, err = cp.transceiveData(send, data, num9, rxAlign, false)
 			if err != cp2102ErrCollision {
 				if err != nil {
 					return nil, err
@@ -779,8 +949,10 @@ func (cp *cp2102) readCardSerial() ([]byte, error) {
 		}
 	}
 
-	return uid, nil
+	// Each cascade level adds three bytes to the UID, except for the last one which adds four.
+	return uid[:3*num+1], nil
 }
+
 func (cp *cp2102) reset() error {
 	if err := cp.writeCommand(CP2102_SoftReset); err != nil {
 		return err
@@ -886,6 +1058,9 @@ func (cp *cp2102) clearRegisterBits(reg CP2102Register, mask byte) error {
 // sendCommand sends a command to the device.
 func (cp *cp2102) sendCommand(cmd DriverCommand) error {
 	if _, err := cp.Write([]byte{byte(cmd)}); err != nil {
+		if IsDeviceGone(err) {
+			cp.c.DeviceLost(err)
+		}
 		return err
 	}
 
@@ -900,6 +1075,9 @@ func (cp *cp2102) sendCommandAndReadResponse(cmd DriverCommand) (byte, error) {
 
 	b := make([]byte, 1)
 	if _, err := cp.Read(b); err != nil {
+		if IsDeviceGone(err) {
+			cp.c.DeviceLost(err)
+		}
 		return 0, err
 	}
 
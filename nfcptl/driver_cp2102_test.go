@@ -0,0 +1,364 @@
+package nfcptl
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCp2102_ReplayReset(t *testing.T) {
+	cp := &cp2102{}
+	r := NewReplay(loadRecording(t, "cp2102_reset.rec"))
+	if err := cp.SetProtocol(r); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	cp.Connect(nil)
+
+	if err := cp.reset(); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+
+	select {
+	case <-r.Done():
+	default:
+		t.Error("replay not done, want done")
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+}
+
+func TestCp2102_SetProtocol(t *testing.T) {
+	cp := &cp2102{}
+	if err := cp.SetProtocol(&USB{}); err == nil {
+		t.Error("got nil, want error")
+	}
+}
+
+// simN2Elite simulates an N2 Elite tag holding multiple banks of NTAG215 data. Its replies are
+// returned along with the number of valid bits in the last byte.
+type simN2Elite struct {
+	uid    []byte
+	banks  [][]byte
+	active byte
+	state  int // 0 is idle, 1 is ready and 2 is active.
+}
+
+func newSimN2Elite(banks int) *simN2Elite {
+	n2 := &simN2Elite{uid: []byte{0x04, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66}}
+	for i := 0; i < banks; i++ {
+		n2.banks = append(n2.banks, bytes.Repeat([]byte{0xff}, 572))
+	}
+	return n2
+}
+
+// withCRC appends the CRC to the given reply.
+func withCRC(b []byte) []byte {
+	crc1, crc2 := (&cp2102{}).computeCRC(b)
+	return append(b, crc1, crc2)
+}
+
+// pages returns the pages from start up to and including end of the given bank.
+func (n2 *simN2Elite) pages(bank, start, end byte) []byte {
+	return append([]byte{}, n2.banks[bank][int(start)*4:int(end)*4+4]...)
+}
+
+func (n2 *simN2Elite) transceive(tx []byte, lastBits byte) ([]byte, byte) {
+	ack := []byte{0x0a}
+	if lastBits == 7 && len(tx) == 1 {
+		// REQA or WUPA: a selected tag goes back to idle without replying.
+		if n2.state == 2 {
+			n2.state = 0
+			return nil, 0
+		}
+		n2.state = 1
+		return []byte{0x44, 0x00}, 0
+	}
+
+	switch {
+	case n2.state == 1 && tx[1] == 0x20:
+		bcc := func(b []byte) byte { return b[0] ^ b[1] ^ b[2] ^ b[3] }
+		if tx[0] == 0x93 {
+			ct := append([]byte{0x88}, n2.uid[:3]...)
+			return append(ct, bcc(ct)), 0
+		}
+		return append(append([]byte{}, n2.uid[3:]...), bcc(n2.uid[3:])), 0
+	case n2.state == 1 && tx[1] == 0x70:
+		if tx[0] == 0x93 {
+			return withCRC([]byte{0x04}), 0
+		}
+		n2.state = 2
+		return withCRC([]byte{0x00}), 0
+	case n2.state != 2 || !bytes.Equal(withCRC(append([]byte{}, tx[:len(tx)-2]...)), tx):
+		n2.state = 0
+		return nil, 0
+	}
+
+	switch cmd := tx[:len(tx)-2]; cmd[0] {
+	case 0x60:
+		return withCRC([]byte{0x00, 0x34, 0x21, 0x01, 0x01, 0x00, 0x11, 0x03}), 0
+	case 0x55:
+		return withCRC([]byte{n2.active, byte(len(n2.banks)), 0x00, 0x03}), 0
+	case 0x3a:
+		return withCRC(n2.pages(n2.active, cmd[1], cmd[2])), 0
+	case 0x3b:
+		return withCRC(n2.pages(cmd[3], cmd[1], cmd[2])), 0
+	case 0x1b:
+		return withCRC([]byte{0x80, 0x80}), 0
+	case 0xa5:
+		copy(n2.banks[cmd[2]][int(cmd[1])*4:], cmd[3:7])
+		return ack, 4
+	case 0xa7:
+		n2.active = cmd[1]
+		return ack, 4
+	case 0xa9:
+		for len(n2.banks) < int(cmd[1]) {
+			n2.banks = append(n2.banks, bytes.Repeat([]byte{0xff}, 572))
+		}
+		n2.banks = n2.banks[:cmd[1]]
+		return ack, 4
+	}
+
+	n2.state = 0
+	return []byte{0x00}, 4
+}
+
+// simMFRC522 simulates the MFRC522 behind the CP2102 UART bridge of the N2 Elite USB reader at the
+// Protocol level, passing the data it transmits to a simN2Elite.
+type simMFRC522 struct {
+	mu      sync.Mutex
+	tag     *simN2Elite // The tag on the reader, nil when there is no tag.
+	regs    [64]byte
+	fifo    []byte
+	pending int // The register of which the value will be written next, -1 if none.
+	replies []byte
+}
+
+func newSimMFRC522() *simMFRC522 {
+	return &simMFRC522{pending: -1}
+}
+
+func (s *simMFRC522) Connect(c *Client) error { return nil }
+func (s *simMFRC522) Disconnect() error       { return nil }
+func (s *simMFRC522) Speed(b int) error       { return nil }
+
+func (s *simMFRC522) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := copy(p, s.replies)
+	s.replies = s.replies[n:]
+	return n, nil
+}
+
+// placeTag places the given tag on the reader, passing nil removes the tag.
+func (s *simMFRC522) placeTag(tag *simN2Elite) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tag = tag
+}
+
+func (s *simMFRC522) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, b := range p {
+		switch {
+		case s.pending >= 0:
+			s.writeRegister(CP2102Register(s.pending), b)
+			s.pending = -1
+		case b&0x80 != 0:
+			s.replies = append(s.replies, s.readRegister(CP2102Register(b&0x3f)))
+		default:
+			s.replies = append(s.replies, 0x00)
+			s.pending = int(b & 0x3f)
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *simMFRC522) readRegister(reg CP2102Register) byte {
+	switch reg {
+	case CP2102_FIFOLevelReg:
+		return byte(len(s.fifo))
+	case CP2102_FIFODataReg:
+		if len(s.fifo) == 0 {
+			return 0
+		}
+		b := s.fifo[0]
+		s.fifo = s.fifo[1:]
+		return b
+	}
+
+	return s.regs[reg]
+}
+
+func (s *simMFRC522) writeRegister(reg CP2102Register, b byte) {
+	switch reg {
+	case CP2102_CommandReg:
+		s.regs[reg] = b & 0x0f
+		if DriverCommand(b&0x0f) == CP2102_CalcCRC {
+			s.regs[CP2102_CRCResultRegL], s.regs[CP2102_CRCResultRegH] = (&cp2102{}).computeCRC(s.fifo)
+			s.fifo = nil
+			s.regs[CP2102_DivIrqReg] |= 0x04
+		}
+	case CP2102_ComIrqReg, CP2102_DivIrqReg:
+		// Bit 7 tells whether the marked bits are set or cleared.
+		if b&0x80 != 0 {
+			s.regs[reg] |= b & 0x7f
+		} else {
+			s.regs[reg] &^= b
+		}
+	case CP2102_FIFOLevelReg:
+		if b&0x80 != 0 {
+			s.fifo = nil
+		}
+	case CP2102_FIFODataReg:
+		s.fifo = append(s.fifo, b)
+	case CP2102_BitFramingReg:
+		s.regs[reg] = b &^ 0x80
+		if b&0x80 != 0 && DriverCommand(s.regs[CP2102_CommandReg]) == CP2102_Transceive {
+			s.transceive()
+		}
+	default:
+		s.regs[reg] = b
+	}
+}
+
+// transceive passes the FIFO contents to the tag and puts the reply in the FIFO.
+func (s *simMFRC522) transceive() {
+	tx := s.fifo
+	s.fifo = nil
+	if s.tag == nil {
+		s.regs[CP2102_ComIrqReg] |= 0x01
+		return
+	}
+
+	rx, lastBits := s.tag.transceive(tx, s.regs[CP2102_BitFramingReg]&0x07)
+	if rx == nil {
+		s.regs[CP2102_ComIrqReg] |= 0x01
+		return
+	}
+	s.fifo = rx
+	s.regs[CP2102_ControlReg] = lastBits
+	s.regs[CP2102_ComIrqReg] |= 0x30
+}
+
+// newCP2102Client returns a connected Client using the cp2102 driver talking to a simMFRC522.
+func newCP2102Client(t *testing.T, tag *simN2Elite) (*Client, *simMFRC522) {
+	c, err := NewClient(VendorSiliconLabs, ProductN2EliteUSB, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	s := newSimMFRC522()
+	s.placeTag(tag)
+	if err := c.Driver().(ProtocolDriver).SetProtocol(s); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	t.Cleanup(func() {
+		go func() {
+			for range c.Events() {
+			}
+		}()
+		c.Disconnect()
+	})
+
+	return c, s
+}
+
+func TestCp2102_DetectToken(t *testing.T) {
+	tag := newSimN2Elite(2)
+	c, s := newCP2102Client(t, tag)
+
+	evs := expectEvents(t, c, TokenDetected)
+	if !bytes.Equal(evs[0].Data(), tag.uid) {
+		t.Errorf("got %x, want %x", evs[0].Data(), tag.uid)
+	}
+
+	// The selected tag must not be reported as removed.
+	select {
+	case e := <-c.Events():
+		t.Fatalf("got %s, want no event", e)
+	case <-time.After(1200 * time.Millisecond):
+	}
+
+	s.placeTag(nil)
+	expectEvents(t, c, TokenRemoved)
+}
+
+func TestCp2102_Banks(t *testing.T) {
+	tag := newSimN2Elite(2)
+	copy(tag.banks[1][84:92], []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08})
+	c, _ := newCP2102Client(t, tag)
+	expectEvents(t, c, TokenDetected)
+
+	c.SendCommand(Command{Command: ListBanks})
+	evs := expectEvents(t, c, BankList)
+	want := []byte{0x00, 0x02, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	if !bytes.Equal(evs[0].Data(), want) {
+		t.Errorf("got %x, want %x", evs[0].Data(), want)
+	}
+
+	c.SendCommand(Command{Command: SetActiveBank, Arguments: []byte{0x01}})
+	evs = expectEvents(t, c, ActiveBankSet)
+	if !bytes.Equal(evs[0].Data(), []byte{0x01}) || tag.active != 0x01 {
+		t.Errorf("got %x and active bank %d, want 01 and 1", evs[0].Data(), tag.active)
+	}
+
+	c.SendCommand(Command{Command: SetBankCount, Arguments: []byte{0x03}})
+	expectEvents(t, c, BankCountSet)
+	if len(tag.banks) != 3 {
+		t.Errorf("got %d, want 3", len(tag.banks))
+	}
+
+	token := testToken()
+	c.SendCommand(Command{Command: WriteBank, Arguments: append([]byte{0x02}, token...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	var pages []int
+	for page := 0; page < 540/4; page++ {
+		pages = append(pages, page)
+	}
+	expectWriteProgress(t, c, pages)
+	expectEvents(t, c, TokenTagWriteFinish)
+	wantBank := append(append([]byte{}, token...), bytes.Repeat([]byte{0xff}, 32)...)
+	if !bytes.Equal(tag.banks[2], wantBank) {
+		t.Errorf("got %x, want %x", tag.banks[2], wantBank)
+	}
+
+	c.SendCommand(Command{Command: ReadBank, Arguments: []byte{0x02}})
+	evs = expectEvents(t, c, BankData)
+	if !bytes.Equal(evs[0].Data(), wantBank) {
+		t.Errorf("got %x, want %x", evs[0].Data(), wantBank)
+	}
+
+	c.SendCommand(Command{Command: EraseBank, Arguments: []byte{0x02}})
+	expectEvents(t, c, BankErased)
+	if want := bytes.Repeat([]byte{0xff}, 572); !bytes.Equal(tag.banks[2], want) {
+		t.Errorf("got %x, want %x", tag.banks[2], want)
+	}
+
+	c.SendCommand(Command{Command: WriteBank, Arguments: []byte{0x02, 0x01}})
+	expectEvents(t, c, TokenTagDataSizeError)
+
+	c.SendCommand(Command{Command: ReadBank})
+	evs = expectEvents(t, c, BankError)
+	if !bytes.Equal(evs[0].Data(), []byte{byte(ReadBank)}) {
+		t.Errorf("got %x, want %x", evs[0].Data(), []byte{byte(ReadBank)})
+	}
+}
+
+func TestCp2102_BanksWithoutToken(t *testing.T) {
+	c, _ := newCP2102Client(t, nil)
+
+	c.SendCommand(Command{Command: ListBanks})
+	expectEvents(t, c, BankError)
+
+	c.SendCommand(Command{Command: WriteBank, Arguments: append([]byte{0x00}, testToken()...)})
+	expectEvents(t, c, TokenTagWriteStart, TokenTagWriteError)
+}
@@ -0,0 +1,519 @@
+package nfcptl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// init MUST be used in drivers to register the driver by calling RegisterDriver. If the driver is
+// not registered, it will not be recognised!
+func init() {
+	RegisterDriver(&pn532{UARTProtocol: &UART{}})
+}
+
+const (
+	// PN532_HostToPN532 is the frame identifier (TFI) of frames sent from the host to the PN532.
+	PN532_HostToPN532 = 0xd4
+	// PN532_PN532ToHost is the frame identifier (TFI) of frames sent from the PN532 to the host.
+	PN532_PN532ToHost = 0xd5
+
+	// PN532_GetFirmwareVersion returns the IC type, firmware version, revision and supported
+	// features of the PN532.
+	PN532_GetFirmwareVersion = 0x02
+	// PN532_SAMConfiguration selects the data flow path. It is also used to wake up the PN532 after
+	// power on.
+	PN532_SAMConfiguration = 0x14
+	// PN532_RFConfiguration configures the RF settings of the PN532. It is used to limit the number
+	// of retries when looking for a target.
+	PN532_RFConfiguration = 0x32
+	// PN532_InDataExchange sends the data following the command to the target. The PN532 adds the
+	// CRC and handles the MIFARE specifics of the NTAG21x commands.
+	PN532_InDataExchange = 0x40
+	// PN532_InCommunicateThru sends the data following the command as is to the card. It is used
+	// for the NTAG21x commands that have no pseudo-APDU equivalent.
+	PN532_InCommunicateThru = 0x42
+	// PN532_InListPassiveTarget detects a target in passive mode. The driver uses it to look for a
+	// single ISO/IEC14443 Type A target at 106 kbps.
+	PN532_InListPassiveTarget = 0x4a
+
+	// pn532PollInterval is the interval at which the PN532 is polled for a token.
+	pn532PollInterval = 100 * time.Millisecond
+	// pn532MaxFrame is the maximum size of a normal information frame.
+	pn532MaxFrame = 262
+)
+
+var (
+	// pn532Ack is the ACK frame the PN532 sends when it received a valid command frame.
+	pn532Ack = []byte{0x00, 0x00, 0xff, 0x00, 0xff, 0x00}
+	// pn532Wakeup is sent before the first command to wake the PN532 up from power down mode when
+	// using the high speed UART.
+	pn532Wakeup = []byte{0x55, 0x55, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	errPN532ShortFrame  = errors.New("pn532: frame too short")
+	errPN532Checksum    = errors.New("pn532: checksum mismatch")
+	errPN532ErrorFrame  = errors.New("pn532: application level error")
+	errPN532Nack        = errors.New("pn532: frame not acknowledged")
+	errPN532NoToken     = errors.New("pn532: no token present")
+	errPN532InvalidData = errors.New("pn532: invalid reply")
+)
+
+// ErrPN532 defines the error structure returned when the PN532 reports an error communicating with
+// the target.
+type ErrPN532 struct {
+	Command byte // Command holds the PN532 command that failed.
+	Status  byte // Status holds the error code in the status byte of the reply.
+}
+
+// Error implements the error interface
+func (e ErrPN532) Error() string {
+	return fmt.Sprintf("pn532: command %#02x failed with status %#02x", e.Command, e.Status)
+}
+
+// pn532FrameType defines the type of a frame received from the PN532.
+type pn532FrameType int
+
+const (
+	pn532FrameInfo pn532FrameType = iota
+	pn532FrameAck
+	pn532FrameNack
+	pn532FrameError
+)
+
+// pn532Frame describes a frame received from the PN532.
+type pn532Frame struct {
+	typ  pn532FrameType
+	data []byte // The TFI followed by the packet data of an information frame.
+}
+
+// marshalPN532Frame returns the normal information frame carrying the given TFI and packet data:
+//
+//	0x00 0x00 0xff <LEN> <LCS> <TFI> <PD0> ... <PDn> <DCS> 0x00
+//
+// LEN is the length of TFI and the packet data, LCS is its checksum: LEN + LCS = 0x00. DCS is the
+// checksum of TFI and the packet data: TFI + PD0 + ... + PDn + DCS = 0x00.
+func marshalPN532Frame(data []byte) []byte {
+	l := byte(len(data))
+	b := []byte{0x00, 0x00, 0xff, l, -l}
+	b = append(b, data...)
+	var sum byte
+	for _, d := range data {
+		sum += d
+	}
+
+	return append(b, -sum, 0x00)
+}
+
+// unmarshalPN532Frame parses the first frame in b. It returns the frame and the number of bytes of
+// b it consumed, including any garbage preceding the start code. When b does not hold a complete
+// frame, errPN532ShortFrame is returned.
+func unmarshalPN532Frame(b []byte) (*pn532Frame, int, error) {
+	start := bytes.Index(b, []byte{0x00, 0xff})
+	if start < 0 || len(b) < start+4 {
+		return nil, 0, errPN532ShortFrame
+	}
+
+	l, lcs := b[start+2], b[start+3]
+	switch {
+	case l == 0x00 && lcs == 0xff:
+		return &pn532Frame{typ: pn532FrameAck}, start + 5, nil
+	case l == 0xff && lcs == 0x00:
+		return &pn532Frame{typ: pn532FrameNack}, start + 5, nil
+	case l+lcs != 0x00:
+		// Not a frame after all, look for the next start code.
+		f, n, err := unmarshalPN532Frame(b[start+2:])
+		if n == 0 {
+			return nil, 0, err
+		}
+		return f, start + 2 + n, err
+	}
+
+	end := start + 4 + int(l) + 2
+	if len(b) < end {
+		return nil, 0, errPN532ShortFrame
+	}
+
+	data := b[start+4 : start+4+int(l)]
+	sum := b[end-2]
+	for _, d := range data {
+		sum += d
+	}
+	if sum != 0x00 {
+		return nil, end, errPN532Checksum
+	}
+
+	f := &pn532Frame{typ: pn532FrameInfo, data: append([]byte{}, data...)}
+	if len(data) == 1 && data[0] == 0x7f {
+		f.typ = pn532FrameError
+	}
+
+	return f, end, nil
+}
+
+// pn532 implements the Driver interface for the PN532 NFC controller as found on the cheap breakout
+// boards. The PN532 is connected to a serial port using its high speed UART, usually through a USB
+// to UART bridge. The serial port to use depends on the system so it must be set using
+// Client.SetSerial.
+type pn532 struct {
+	tokenPlaced bool   // Keeps track of token state.
+	uid         []byte // The UID of the token placed on the PN532.
+	target      byte   // The logical number the PN532 assigned to the token.
+	buf         []byte // Bytes read from the PN532 that are not part of a processed frame yet.
+
+	c *Client
+
+	UARTProtocol // The protocol this driver works with
+}
+
+// Supports implements the PN532. It has no USB IDs of its own since it is connected through a USB
+// to UART bridge, so the vendor and product alias must be used to select this driver.
+func (pn *pn532) Supports() []Vendor {
+	return []Vendor{
+		{
+			ID:    VIDNXPSemiconductors,
+			Alias: VendorNXPSemiconductors,
+			Products: []Product{
+				{
+					ID:    PIDPN532,
+					Alias: ProductPN532,
+				},
+			},
+		},
+	}
+}
+
+func (pn *pn532) VendorId(alias string) (uint16, error) {
+	for _, v := range pn.Supports() {
+		if v.Alias == alias {
+			return v.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("pn532: unknown vendor %s", alias)
+}
+
+func (pn *pn532) ProductId(alias string) (uint16, error) {
+	for _, v := range pn.Supports() {
+		for _, pr := range v.Products {
+			if pr.Alias == alias {
+				return pr.ID, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("pn532: unknown product %s", alias)
+}
+
+func (pn *pn532) Setup() any {
+	// The port depends on the system, use Client.SetSerial to set it.
+	return Serial{
+		Port: "/dev/ttyUSB0",
+		Baud: 115200,
+	}
+}
+
+func (pn *pn532) New() Driver {
+	return &pn532{UARTProtocol: &UART{}}
+}
+
+func (pn *pn532) Protocol() Protocol {
+	return pn.UARTProtocol
+}
+
+func (pn *pn532) SetProtocol(p Protocol) error {
+	uart, ok := p.(UARTProtocol)
+	if !ok {
+		return errors.New("pn532: protocol must implement UARTProtocol")
+	}
+	pn.UARTProtocol = uart
+
+	return nil
+}
+
+func (pn *pn532) Drive(c *Client) {
+	pn.c = c
+	if pn.c.Debug() {
+		log.Println("pn532: driving")
+	}
+
+	if err := pn.init(); err != nil {
+		log.Printf("%s", err)
+		pn.c.PublishEvent(NewErrorEvent(Error, nil, err))
+	}
+
+	pn.commandListener()
+}
+
+// init wakes up the PN532 and configures it to return immediately when no token is present.
+func (pn *pn532) init() error {
+	if _, err := pn.Write(pn532Wakeup); err != nil {
+		return fmt.Errorf("pn532: %v", err)
+	}
+	// Normal mode, no timeout and no use of the IRQ pin.
+	if _, err := pn.transceive(PN532_SAMConfiguration, 0x01, 0x00, 0x00); err != nil {
+		return err
+	}
+	// Config item 0x05 holds the retries: MxRtyATR, MxRtyPSL and MxRtyPassiveActivation.
+	_, err := pn.transceive(PN532_RFConfiguration, 0x05, 0xff, 0x01, 0x01)
+
+	return err
+}
+
+// commandListener listens for commands sent by the Client. If no commands are received it will
+// poll the PN532 to check if a token is placed on the device.
+func (pn *pn532) commandListener() {
+	ticker := time.NewTicker(pn532PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case cmd := <-pn.c.Commands():
+				pn.handleCommand(cmd)
+			default:
+				pn.pollForToken()
+			}
+		case <-pn.c.Terminate():
+			// Signal the client we're done with this goroutine informing it that it's safe to
+			// disconnect.
+			pn.c.Done()
+			return
+		}
+	}
+}
+
+// handleCommand executes the given client command.
+func (pn *pn532) handleCommand(cmd Command) {
+	defer pn.c.Executing(cmd)()
+
+	if cmd.Context().Err() != nil {
+		pn.c.PublishEvent(NewEvent(CommandCancelled, []byte{byte(cmd.Command)}))
+		return
+	}
+
+	switch cmd.Command {
+	case GetDeviceName, GetHardwareInfo:
+		pn.getFirmwareVersion(cmd.Command)
+	case FetchTokenData:
+		if !pn.tokenPlaced {
+			log.Println("pn532: no token to read")
+			pn.c.PublishEvent(NewEvent(TokenTagDataError, nil))
+			return
+		}
+		fetchNTAG215(pn.c, pn, "pn532")
+	case WriteTokenData:
+		if cmd.Arguments == nil {
+			log.Println("pn532: no data to write")
+			pn.c.PublishEvent(NewEvent(TokenTagWriteError, nil))
+		} else {
+			writeNTAG215(pn.c, pn, "pn532", pn.tokenPlaced, cmd.Arguments[1:], cmd.Arguments[0])
+		}
+	case ReadPages, WritePage, PwdAuth:
+		handlePageCommand(pn.c, pn, "pn532", pn.tokenPlaced, cmd)
+	default:
+		pn.c.PublishEvent(NewEvent(UnknownCommand, []byte{}))
+	}
+}
+
+// pollForToken looks for a target using PN532_InListPassiveTarget. When a new token is detected,
+// it will send a TokenDetected event holding the token UID, a TokenTagVersion event holding the
+// GET_VERSION response and a TokenTagSignature event holding the originality signature followed by
+// reading the token contents and sending it to the client using the TokenTagData event. When the token has been removed, a TokenRemoved event is sent.
+func (pn *pn532) pollForToken() {
+	// One target at 106 kbps type A.
+	res, err := pn.transceive(PN532_InListPassiveTarget, 0x01, 0x00)
+	if err != nil {
+		log.Printf("%s", err)
+		return
+	}
+
+	// The reply is <NbTg> <Tg> <SENS_RES> <SEL_RES> <NFCIDLength> <NFCID1>.
+	var uid []byte
+	if len(res) > 0 && res[0] > 0 {
+		if len(res) < 6 || len(res) < 6+int(res[5]) {
+			log.Printf("pn532: invalid target data %x", res)
+			return
+		}
+		pn.target = res[1]
+		uid = res[6 : 6+int(res[5])]
+	}
+
+	if pn.tokenPlaced && bytes.Equal(uid, pn.uid) {
+		return
+	}
+
+	if pn.tokenPlaced {
+		pn.tokenPlaced = false
+		pn.uid = nil
+		pn.c.PublishEvent(NewEvent(TokenRemoved, nil))
+	}
+
+	if uid == nil {
+		return
+	}
+
+	pn.tokenPlaced = true
+	pn.uid = append([]byte{}, uid...)
+	log.Printf("pn532: token detected with id %#x", uid)
+	pn.c.PublishEvent(NewEvent(TokenDetected, pn.uid))
+
+	if version, err := pn.readVersion(); err == nil {
+		pn.c.PublishEvent(NewEvent(TokenTagVersion, version))
+	} else if pn.c.Debug() {
+		log.Printf("%s", err)
+	}
+	if sig, err := pn.readSignature(); err == nil {
+		pn.c.PublishEvent(NewEvent(TokenTagSignature, sig))
+	} else if pn.c.Debug() {
+		log.Printf("%s", err)
+	}
+
+	fetchNTAG215(pn.c, pn, "pn532")
+}
+
+// getFirmwareVersion publishes the DeviceName event holding the IC type and firmware version, e.g.
+// "PN532 v1.6", for the GetDeviceName command. For the GetHardwareInfo command, the HardwareInfo
+// event is published holding the raw reply: the IC type, version, revision and supported features.
+func (pn *pn532) getFirmwareVersion(cc ClientCommand) {
+	res, err := pn.transceive(PN532_GetFirmwareVersion)
+	if err == nil && len(res) != 4 {
+		err = errPN532InvalidData
+	}
+	if err != nil {
+		log.Printf("%s", err)
+		pn.c.PublishEvent(NewErrorEvent(Error, nil, err))
+		return
+	}
+
+	if cc == GetHardwareInfo {
+		pn.c.PublishEvent(NewEvent(HardwareInfo, res))
+		return
+	}
+	pn.c.PublishEvent(NewEvent(DeviceName, []byte(fmt.Sprintf("PN5%02x v%d.%d", res[0], res[1], res[2]))))
+}
+
+// readPages reads four pages starting from the given page.
+func (pn *pn532) readPages(page byte) ([]byte, error) {
+	res, err := pn.dataExchange(NTAG_Read, page)
+	if err == nil && len(res) != 16 {
+		return nil, fmt.Errorf("pn532: read of page %#02x returned %d bytes", page, len(res))
+	}
+
+	return res, err
+}
+
+// writePage writes four bytes of data to the given page.
+func (pn *pn532) writePage(page byte, data []byte) error {
+	_, err := pn.dataExchange(append([]byte{NTAG_Write, page}, data...)...)
+	return err
+}
+
+// pwdAuth authenticates to the token using the given password and returns the password
+// acknowledge.
+func (pn *pn532) pwdAuth(pwd []byte) ([]byte, error) {
+	return pn.dataExchange(append([]byte{NTAG_PwdAuth}, pwd...)...)
+}
+
+// readVersion returns the eight byte GET_VERSION response of the token.
+func (pn *pn532) readVersion() ([]byte, error) {
+	return pn.dataExchange(NTAG_GetVersion)
+}
+
+// readSignature returns the 32 byte originality signature of the token.
+func (pn *pn532) readSignature() ([]byte, error) {
+	return pn.dataExchange(NTAG_ReadSig, 0x00)
+}
+
+// dataExchange sends the given NTAG21x command to the token using PN532_InDataExchange and returns
+// the reply of the token. An ErrPN532 error is returned when the PN532 reports an error.
+func (pn *pn532) dataExchange(cmd ...byte) ([]byte, error) {
+	if !pn.tokenPlaced {
+		return nil, errPN532NoToken
+	}
+
+	res, err := pn.transceive(PN532_InDataExchange, append([]byte{pn.target}, cmd...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reply is <status> <data>, the lower six bits of the status byte hold the error code.
+	if len(res) < 1 {
+		return nil, errPN532InvalidData
+	}
+	if status := res[0] & 0x3f; status != 0x00 {
+		return nil, ErrPN532{Command: cmd[0], Status: status}
+	}
+
+	return res[1:], nil
+}
+
+// transceive sends the given PN532 command with its parameters to the PN532 and waits for the ACK
+// frame followed by the reply. It returns the data of the reply following the reply code.
+func (pn *pn532) transceive(cmd byte, params ...byte) ([]byte, error) {
+	frame := marshalPN532Frame(append([]byte{PN532_HostToPN532, cmd}, params...))
+	if pn.c.Debug() {
+		log.Println("pn532: sending frame:")
+		log.Println(hex.Dump(frame))
+	}
+	if _, err := pn.Write(frame); err != nil {
+		if IsDeviceGone(err) {
+			pn.c.DeviceLost(err)
+		}
+		return nil, fmt.Errorf("pn532: %v", err)
+	}
+
+	f, err := pn.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if f.typ != pn532FrameAck {
+		return nil, errPN532Nack
+	}
+
+	f, err = pn.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if f.typ == pn532FrameError {
+		return nil, errPN532ErrorFrame
+	}
+	if f.typ != pn532FrameInfo || len(f.data) < 2 || f.data[0] != PN532_PN532ToHost || f.data[1] != cmd+1 {
+		return nil, fmt.Errorf("pn532: invalid reply to command %#02x: %x", cmd, f.data)
+	}
+
+	return f.data[2:], nil
+}
+
+// readFrame reads a single frame from the PN532.
+func (pn *pn532) readFrame() (*pn532Frame, error) {
+	for {
+		f, n, err := unmarshalPN532Frame(pn.buf)
+		pn.buf = pn.buf[n:]
+		if err == nil {
+			if pn.c.Debug() && f.typ != pn532FrameAck {
+				log.Printf("pn532: reply: %x", f.data)
+			}
+			return f, nil
+		}
+		if err != errPN532ShortFrame {
+			return nil, err
+		}
+
+		buf := make([]byte, pn532MaxFrame)
+		n, err = pn.Read(buf)
+		if err != nil {
+			if IsDeviceGone(err) {
+				pn.c.DeviceLost(err)
+			}
+			return nil, fmt.Errorf("pn532: %v", err)
+		}
+		if n == 0 {
+			return nil, errors.New("pn532: empty reply")
+		}
+		pn.buf = append(pn.buf, buf[:n]...)
+	}
+}
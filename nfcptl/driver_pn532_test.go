@@ -0,0 +1,429 @@
+package nfcptl
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// simPN532 simulates a PN532 with an NTAG215 token at the Protocol level. Replies are returned in
+// small chunks to make sure the driver reassembles the frames.
+type simPN532 struct {
+	mu       sync.Mutex
+	token    []byte // The NTAG215 memory of the token on the PN532, nil when there is no token.
+	authed   bool
+	written  []int  // The pages written to the token since the last call to writtenPages.
+	nakPage  int    // The page of which the next write is NAKed, zero for none.
+	dropPage int    // The page of which the next write is acknowledged but not stored, zero for none.
+	in       []byte // Bytes written by the driver that are not part of a processed frame yet.
+
+	replies chan []byte
+	pending []byte
+}
+
+func newSimPN532() *simPN532 {
+	return &simPN532{replies: make(chan []byte, 4)}
+}
+
+func (s *simPN532) Connect(c *Client) error { return nil }
+func (s *simPN532) Disconnect() error       { return nil }
+func (s *simPN532) Speed(b int) error       { return nil }
+
+func (s *simPN532) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 {
+		s.pending = <-s.replies
+	}
+	if len(p) > 7 {
+		p = p[:7]
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+
+	return n, nil
+}
+
+// placeToken places the given token on the PN532, passing nil removes the token.
+func (s *simPN532) placeToken(token []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+	s.authed = false
+}
+
+// tokenData returns the memory of the token on the PN532.
+func (s *simPN532) tokenData() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// writtenPages returns the pages written to the token, in order, since the last call.
+func (s *simPN532) writtenPages() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	written := s.written
+	s.written = nil
+	return written
+}
+
+func (s *simPN532) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.in = append(s.in, p...)
+	for {
+		f, n, err := unmarshalPN532Frame(s.in)
+		s.in = s.in[n:]
+		if err == errPN532ShortFrame {
+			return len(p), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		res := append([]byte{PN532_PN532ToHost, f.data[1] + 1}, s.command(f.data[1], f.data[2:])...)
+		s.replies <- append(append([]byte{}, pn532Ack...), marshalPN532Frame(res)...)
+	}
+}
+
+// command returns the reply to the given PN532 command.
+func (s *simPN532) command(cmd byte, params []byte) []byte {
+	switch cmd {
+	case PN532_GetFirmwareVersion:
+		return []byte{0x32, 0x01, 0x06, 0x07}
+	case PN532_InListPassiveTarget:
+		if s.token == nil {
+			s.authed = false
+			return []byte{0x00}
+		}
+		uid := virtualUid(s.token)
+		return append([]byte{0x01, 0x01, 0x00, 0x44, 0x00, byte(len(uid))}, uid...)
+	case PN532_InDataExchange:
+		return s.dataExchange(params[1:])
+	}
+
+	return nil
+}
+
+// dataExchange returns the reply to the given NTAG21x command.
+func (s *simPN532) dataExchange(cmd []byte) []byte {
+	ok := []byte{0x00}
+	timeout := []byte{0x01}
+	if s.token == nil {
+		return timeout
+	}
+
+	switch cmd[0] {
+	case NTAG_Read:
+		data := ok
+		for i := 0; i < 4; i++ {
+			page := (int(cmd[1]) + i) % 0x87
+			if page >= 0x85 {
+				// PWD and PACK always read as zero.
+				data = append(data, 0x00, 0x00, 0x00, 0x00)
+			} else {
+				data = append(data, s.token[page*4:page*4+4]...)
+			}
+		}
+		return data
+	case NTAG_Write:
+		page := int(cmd[1])
+		if auth0 := int(s.token[0x83*4+3]); page >= auth0 && !s.authed {
+			// The token NAKs the write which the PN532 reports as a MIFARE error.
+			return []byte{0x14}
+		}
+		if page == s.nakPage {
+			s.nakPage = 0
+			return []byte{0x14}
+		}
+		if page == s.dropPage {
+			s.dropPage = 0
+			return ok
+		}
+		copy(s.token[page*4:], cmd[2:6])
+		s.written = append(s.written, page)
+		return ok
+	case NTAG_PwdAuth:
+		if !bytes.Equal(cmd[1:5], s.token[532:536]) {
+			return timeout
+		}
+		s.authed = true
+		return append(ok, s.token[536:538]...)
+	case NTAG_GetVersion:
+		return append(ok, ntag215Version...)
+	case NTAG_ReadSig:
+		return append(ok, bytes.Repeat([]byte{0x5a}, 32)...)
+	}
+
+	return []byte{0x27}
+}
+
+// newPN532Client returns a connected Client using the pn532 driver talking to a simPN532.
+func newPN532Client(t *testing.T, token []byte) (*Client, *simPN532) {
+	c, err := NewClient(VendorNXPSemiconductors, ProductPN532, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	s := newSimPN532()
+	s.placeToken(token)
+	if err := c.Driver().(ProtocolDriver).SetProtocol(s); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	t.Cleanup(func() {
+		go func() {
+			for range c.Events() {
+			}
+		}()
+		c.Disconnect()
+	})
+
+	return c, s
+}
+
+func TestMarshalPN532Frame(t *testing.T) {
+	got := marshalPN532Frame([]byte{PN532_HostToPN532, PN532_GetFirmwareVersion})
+	want := []byte{0x00, 0x00, 0xff, 0x02, 0xfe, 0xd4, 0x02, 0x2a, 0x00}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
+
+func TestUnmarshalPN532Frame(t *testing.T) {
+	f, n, err := unmarshalPN532Frame(pn532Ack)
+	if err != nil || f.typ != pn532FrameAck || n != 6 {
+		t.Errorf("got %v %d %v, want an ACK frame of 6 bytes", f, n, err)
+	}
+
+	// A firmware version reply preceded by garbage and a false start code.
+	b := []byte{0x55, 0x00, 0xff, 0x01, 0x01, 0x00, 0x00, 0xff, 0x06, 0xfa, 0xd5, 0x03, 0x32, 0x01, 0x06, 0x07, 0xe8, 0x00}
+	f, n, err = unmarshalPN532Frame(b)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if n != len(b) {
+		t.Errorf("got %d, want %d", n, len(b))
+	}
+	if want := []byte{0xd5, 0x03, 0x32, 0x01, 0x06, 0x07}; f.typ != pn532FrameInfo || !bytes.Equal(f.data, want) {
+		t.Errorf("got %d %x, want %d %x", f.typ, f.data, pn532FrameInfo, want)
+	}
+
+	if _, n, err := unmarshalPN532Frame(b[:len(b)-1]); err != errPN532ShortFrame || n != 0 {
+		t.Errorf("got %d %v, want 0 %s", n, err, errPN532ShortFrame)
+	}
+
+	b[len(b)-2]++
+	if _, _, err := unmarshalPN532Frame(b); err != errPN532Checksum {
+		t.Errorf("got %v, want %s", err, errPN532Checksum)
+	}
+
+	f, _, err = unmarshalPN532Frame([]byte{0x00, 0x00, 0xff, 0x01, 0xff, 0x7f, 0x81, 0x00})
+	if err != nil || f.typ != pn532FrameError {
+		t.Errorf("got %v %v, want an error frame", f, err)
+	}
+}
+
+func TestPn532_DetectToken(t *testing.T) {
+	token := testToken()
+	c, s := newPN532Client(t, token)
+
+	evs := expectEvents(t, c, TokenDetected, TokenTagVersion, TokenTagSignature, TokenTagData)
+	if want := virtualUid(token); !bytes.Equal(evs[0].Data(), want) {
+		t.Errorf("got %x, want %x", evs[0].Data(), want)
+	}
+	if !bytes.Equal(evs[1].Data(), ntag215Version) {
+		t.Errorf("got %x, want %x", evs[1].Data(), ntag215Version)
+	}
+	if want := bytes.Repeat([]byte{0x5a}, 32); !bytes.Equal(evs[2].Data(), want) {
+		t.Errorf("got %x, want %x", evs[2].Data(), want)
+	}
+	if want := readTokenData(token); !bytes.Equal(evs[3].Data(), want) {
+		t.Errorf("got %x, want %x", evs[3].Data(), want)
+	}
+
+	s.placeToken(nil)
+	expectEvents(t, c, TokenRemoved)
+}
+
+func TestPn532_WriteTokenData(t *testing.T) {
+	c, s := newPN532Client(t, testToken())
+	expectEvents(t, c, TokenDetected, TokenTagVersion, TokenTagSignature, TokenTagData)
+
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: []byte{0x01, 0x02}})
+	expectEvents(t, c, TokenTagDataSizeError)
+
+	// Wrong password.
+	data := make([]byte, 540)
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{0x01}, data...)})
+	expectEvents(t, c, TokenTagWriteStart, TokenTagWriteError)
+
+	copy(data[532:538], testToken()[532:538])
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{0x01}, data...)})
+	evs := expectEvents(t, c, TokenTagWriteStart, TokenTagWriteLocked)
+	want := []byte{0x08, 0x09, 0x0b, 0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f}
+	if !bytes.Equal(evs[1].Data(), want) {
+		t.Errorf("got %#02x, want %#02x", evs[1].Data(), want)
+	}
+
+	// Locked pages must keep their data.
+	copy(data[0x08*4:0x0c*4], testToken()[0x08*4:0x0c*4])
+	copy(data[0x40*4:0x50*4], testToken()[0x40*4:0x50*4])
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{0x01}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, ntag215WriteOrder(true))
+	evs = expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+
+	want = testToken()
+	copy(want[16:520], data[16:520])
+	if got := s.tokenData(); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+	if !bytes.Equal(evs[1].Data(), readTokenData(want)) {
+		t.Errorf("got %x, want %x", evs[1].Data(), readTokenData(want))
+	}
+}
+
+func TestPn532_WriteTokenDataSmart(t *testing.T) {
+	c, s := newPN532Client(t, testToken())
+	expectEvents(t, c, TokenDetected, TokenTagVersion, TokenTagSignature, TokenTagData)
+
+	data := testToken()
+	data[0x04*4] ^= 0xff
+	data[0x20*4+3] ^= 0xff
+	s.mu.Lock()
+	s.nakPage = 0x20
+	s.mu.Unlock()
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteUserData | WriteSmart}, data...)})
+	evs := expectEvents(t, c, TokenTagWriteStart, TokenTagWriteProgress, TokenTagWriteProgress, TokenTagWriteProgress, TokenTagWriteFinish, TokenTagData)
+	// The first write of page 0x20 is NAKed and retried.
+	for i, want := range [][]byte{{0x04, 0x01, 0x02, 0x00}, {0x20, 0x02, 0x02, 0x00}, {0x20, 0x02, 0x02, 0x01}} {
+		if got := evs[i+1].Data(); !bytes.Equal(got, want) {
+			t.Errorf("got %x, want %x", got, want)
+		}
+	}
+	if got, want := s.writtenPages(), []int{0x04, 0x20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+	if got := s.tokenData(); !bytes.Equal(got, data) {
+		t.Errorf("got %x, want %x", got, data)
+	}
+
+	// PWD and PACK read as zero so these are always written, the last page first.
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteFull | WriteSmart}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, []int{0x86, 0x85})
+	expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+	if got, want := s.writtenPages(), []int{0x86, 0x85}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+
+	// The first dynamic lock byte of the test token locks pages 0x40 up to and including 0x4f.
+	data[0x40*4] ^= 0xff
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteUserData | WriteSmart}, data...)})
+	evs = expectEvents(t, c, TokenTagWriteStart, TokenTagWriteLocked)
+	if got, want := evs[1].Data(), []byte{0x40}; !bytes.Equal(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+	if got := s.writtenPages(); got != nil {
+		t.Errorf("got %#02x, want nil", got)
+	}
+}
+
+func TestPn532_WriteTokenDataVerify(t *testing.T) {
+	c, s := newPN532Client(t, testToken())
+	expectEvents(t, c, TokenDetected, TokenTagVersion, TokenTagSignature, TokenTagData)
+
+	data := testToken()
+	copy(data[0x10*4:0x20*4], make([]byte, 0x10*4))
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteUserData | WriteVerify}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, ntag215WriteOrder(true))
+	evs := expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+	if !bytes.Equal(evs[1].Data(), readTokenData(data)) {
+		t.Errorf("got %x, want %x", evs[1].Data(), readTokenData(data))
+	}
+
+	s.mu.Lock()
+	s.dropPage = 0x12
+	s.mu.Unlock()
+	data[0x12*4] = 0xff
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteFull | WriteSmart | WriteVerify}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, []int{0x86, 0x12, 0x85})
+	evs = expectEvents(t, c, TokenTagVerifyError, TokenTagData)
+	if got, want := evs[0].Data(), []byte{0x12}; !bytes.Equal(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+}
+
+// blankToken returns the memory of a blank NTAG215 token having the UID of testToken.
+func blankToken() []byte {
+	token := make([]byte, 540)
+	copy(token, testToken()[:10])
+	copy(token[12:16], []byte{0xe1, 0x10, 0x3e, 0x00})
+	copy(token[0x83*4:], []byte{0x04, 0x00, 0x00, 0xff})
+	return token
+}
+
+func TestPn532_WriteTokenDataProvision(t *testing.T) {
+	c, s := newPN532Client(t, blankToken())
+	expectEvents(t, c, TokenDetected, TokenTagVersion, TokenTagSignature, TokenTagData)
+
+	// Amiibo data as returned by amiibo.Provision for the blank token.
+	data := testToken()
+	copy(data[10:16], []byte{0x0f, 0xe0, 0xf1, 0x10, 0xff, 0xee})
+	copy(data[0x82*4:], []byte{0x01, 0x00, 0x0f, 0xbd, 0x00, 0x00, 0x00, 0x04, 0x5f, 0x00, 0x00, 0x00})
+	copy(data[0x85*4:], []byte{0x01, 0x02, 0x03, 0x04, 0x80, 0x80, 0x00, 0x00})
+
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteProvision}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, ntag215ProvisionOrder())
+	evs := expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+	if !bytes.Equal(evs[1].Data(), readTokenData(data)) {
+		t.Errorf("got %x, want %x", evs[1].Data(), readTokenData(data))
+	}
+	if got, want := s.writtenPages(), ntag215ProvisionOrder(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+
+	// The UID of the token cannot be changed.
+	data[4] = 0xff
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteProvision}, data...)})
+	evs = expectEvents(t, c, TokenTagWriteStart, TokenTagWriteLocked)
+	if got, want := evs[1].Data(), []byte{0x01}; !bytes.Equal(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+	if got := s.writtenPages(); got != nil {
+		t.Errorf("got %#02x, want nil", got)
+	}
+}
+
+func TestPn532_Commands(t *testing.T) {
+	c, _ := newPN532Client(t, nil)
+
+	c.SendCommand(Command{Command: GetDeviceName})
+	evs := expectEvents(t, c, DeviceName)
+	if got, want := string(evs[0].Data()), "PN532 v1.6"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	c.SendCommand(Command{Command: GetHardwareInfo})
+	evs = expectEvents(t, c, HardwareInfo)
+	if got, want := evs[0].Data(), []byte{0x32, 0x01, 0x06, 0x07}; !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	c.SendCommand(Command{Command: FetchTokenData})
+	expectEvents(t, c, TokenTagDataError)
+
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{0x01}, testToken()...)})
+	expectEvents(t, c, TokenTagWriteStart, TokenTagWriteError)
+
+	c.SendCommand(Command{Command: SetLedState, Arguments: []byte{0x01}})
+	expectEvents(t, c, UnknownCommand)
+}
@@ -0,0 +1,222 @@
+package nfcptl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// init MUST be used in drivers to register the driver by calling RegisterDriver. If the driver is
+// not registered, it will not be recognised!
+func init() {
+	RegisterDriver(&Remote{})
+}
+
+// remoteDialTimeout is the time the Remote driver waits for the server to accept the connection
+// and to reply to the hello.
+const remoteDialTimeout = 10 * time.Second
+
+// ErrNoServer is returned by the Remote driver when connecting without a server address.
+var ErrNoServer = errors.New("remote: no server address set")
+
+// Remote implements the Driver interface for a portal exposed by a Server on another machine. It
+// forwards all commands to the server and publishes the events the server sends, so that it
+// behaves exactly like the portal connected to the server. The events keep the time they were
+// published on the server and their originating command.
+// The server address and auth token MUST be set using SetServer before connecting. When the
+// connection to the server is lost, the client publishes the DeviceLeft event and disconnects just
+// like it does when a local portal is unplugged.
+// The Remote driver of a Client can be obtained by a type assertion on Client.Driver().
+type Remote struct {
+	addr  string // The address of the server.
+	token string // The auth token to send to the server.
+
+	mu     sync.Mutex
+	portal DeviceInfo // The portal exposed by the server.
+
+	conn      net.Conn
+	enc       *json.Encoder
+	dec       *json.Decoder
+	listening chan struct{} // Closed when the event listener has returned.
+
+	c *Client
+}
+
+// Supports implements the remote portal which is not an actual USB device.
+func (r *Remote) Supports() []Vendor {
+	return []Vendor{
+		{
+			ID:    VIDRemote,
+			Alias: VendorRemote,
+			Products: []Product{
+				{
+					ID:    PIDServer,
+					Alias: ProductServer,
+				},
+			},
+		},
+	}
+}
+
+func (r *Remote) VendorId(alias string) (uint16, error) {
+	for _, vnd := range r.Supports() {
+		if vnd.Alias == alias {
+			return vnd.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("remote: unknown vendor %s", alias)
+}
+
+func (r *Remote) ProductId(alias string) (uint16, error) {
+	for _, vnd := range r.Supports() {
+		for _, pr := range vnd.Products {
+			if pr.Alias == alias {
+				return pr.ID, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("remote: unknown product %s", alias)
+}
+
+// Setup returns nil since the Remote driver does not use a Protocol.
+func (r *Remote) Setup() any {
+	return nil
+}
+
+func (r *Remote) New() Driver {
+	return &Remote{}
+}
+
+// SetServer sets the address of the server, e.g. "lab:7390", and the auth token to send to it. It
+// MUST be called before connecting.
+func (r *Remote) SetServer(addr, token string) {
+	r.addr = addr
+	r.token = token
+}
+
+// Portal returns the vendor and product alias and ID of the portal exposed by the server. They are
+// only known once connected.
+// Portal is thread safe.
+func (r *Remote) Portal() DeviceInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.portal
+}
+
+// Connect connects to the server and authenticates using the auth token. ErrInvalidToken is
+// returned when the server refused the token.
+func (r *Remote) Connect(c *Client) error {
+	r.c = c
+	if r.addr == "" {
+		return ErrNoServer
+	}
+
+	conn, err := net.DialTimeout("tcp", r.addr, remoteDialTimeout)
+	if err != nil {
+		return fmt.Errorf("remote: %w", err)
+	}
+	r.conn = conn
+	r.enc = json.NewEncoder(conn)
+	r.dec = json.NewDecoder(conn)
+
+	conn.SetDeadline(time.Now().Add(remoteDialTimeout))
+	if err := r.enc.Encode(remoteMessage{Token: r.token}); err != nil {
+		return fmt.Errorf("remote: %w", err)
+	}
+	var reply remoteMessage
+	if err := r.dec.Decode(&reply); err != nil {
+		return fmt.Errorf("remote: no reply from server: %w", err)
+	}
+	if reply.Error != "" {
+		if reply.Error == ErrInvalidToken.Error() {
+			return ErrInvalidToken
+		}
+		return fmt.Errorf("remote: %s", reply.Error)
+	}
+	conn.SetDeadline(time.Time{})
+
+	r.mu.Lock()
+	r.portal = DeviceInfo{Vendor: reply.Vendor, Product: reply.Product, VendorId: reply.VendorId, ProductId: reply.ProductId}
+	r.mu.Unlock()
+	log.Printf("remote: connected to %s serving %s/%s", r.addr, reply.Vendor, reply.Product)
+
+	return nil
+}
+
+// Disconnect closes the connection to the server.
+func (r *Remote) Disconnect() error {
+	if r.conn == nil {
+		return nil
+	}
+
+	err := r.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (r *Remote) Drive(c *Client) {
+	r.c = c
+	if r.c.Debug() {
+		log.Println("remote: driving")
+	}
+
+	r.listening = make(chan struct{})
+	go r.eventListener()
+	r.commandListener()
+}
+
+// commandListener sends the commands of the client to the server until the client terminates.
+func (r *Remote) commandListener() {
+	for {
+		select {
+		case cmd := <-r.c.Commands():
+			if cmd.Context().Err() != nil {
+				done := r.c.Executing(cmd)
+				r.c.PublishEvent(NewEvent(CommandCancelled, []byte{byte(cmd.Command)}))
+				done()
+				continue
+			}
+			if err := r.enc.Encode(remoteMessage{Command: &cmd.Command, Arguments: cmd.Arguments}); err != nil {
+				r.c.DeviceLost(err)
+			}
+		case <-r.c.Terminate():
+			// Unblock the event listener and wait for it to return.
+			r.conn.Close()
+			<-r.listening
+			// Signal the client we're done with this goroutine informing it that it's safe to
+			// disconnect.
+			r.c.Done()
+			return
+		}
+	}
+}
+
+// eventListener publishes the events received from the server until the connection is closed.
+// The DeviceLeft and Disconnect events of the server are not published: the server closes the
+// connection right after those, which makes the client publish its own.
+func (r *Remote) eventListener() {
+	defer close(r.listening)
+
+	for {
+		var m remoteMessage
+		if err := r.dec.Decode(&m); err != nil {
+			r.c.DeviceLost(err)
+			return
+		}
+
+		switch m.Event {
+		case NoEvent, DeviceLeft, Disconnect:
+		default:
+			r.c.PublishEvent(messageToEvent(m))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package nfcptl
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+const testServerToken = "s3cr3t"
+
+// newTestServer serves a Client using the Virtual driver on a loopback address. Events of the
+// served client are drained in the background.
+func newTestServer(t *testing.T) (string, *Client, *Virtual) {
+	c, err := NewClient(VendorVirtual, ProductEmulator, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	go func() {
+		for range c.Events() {
+		}
+	}()
+
+	s, err := NewServer(c, testServerToken)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	go s.Serve(l)
+
+	t.Cleanup(func() {
+		l.Close()
+		c.Disconnect()
+	})
+
+	return l.Addr().String(), c, c.Driver().(*Virtual)
+}
+
+// newRemoteClient returns a Client using the Remote driver connected to the given server.
+func newRemoteClient(t *testing.T, addr, token string) (*Client, error) {
+	c, err := NewClient(VendorRemote, ProductServer, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	c.Driver().(*Remote).SetServer(addr, token)
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() {
+		go func() {
+			for range c.Events() {
+			}
+		}()
+		c.Disconnect()
+	})
+
+	return c, nil
+}
+
+func TestNewServer(t *testing.T) {
+	if _, err := NewServer(&Client{}, ""); err != ErrNoToken {
+		t.Errorf("got %v, want %s", err, ErrNoToken)
+	}
+}
+
+func TestRemote_Connect(t *testing.T) {
+	addr, _, _ := newTestServer(t)
+
+	if _, err := newRemoteClient(t, addr, "wrong"); err != ErrInvalidToken {
+		t.Errorf("got %v, want %s", err, ErrInvalidToken)
+	}
+	if _, err := newRemoteClient(t, "", testServerToken); err != ErrNoServer {
+		t.Errorf("got %v, want %s", err, ErrNoServer)
+	}
+
+	c, err := newRemoteClient(t, addr, testServerToken)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	want := DeviceInfo{Vendor: VendorVirtual, Product: ProductEmulator, VendorId: VIDVirtual, ProductId: PIDEmulator}
+	if got := c.Driver().(*Remote).Portal(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRemote_Events(t *testing.T) {
+	addr, _, v := newTestServer(t)
+	c, err := newRemoteClient(t, addr, testServerToken)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	token := unlockedTestToken()
+	v.PlaceToken(token)
+	evs := expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagData)
+	if want := tokenRead(token); !bytes.Equal(evs[2].Data(), want) {
+		t.Errorf("got %x, want %x", evs[2].Data(), want)
+	}
+	if _, ok := evs[2].Command(); ok {
+		t.Error("got a command, want none for a token placed on the portal")
+	}
+
+	c.SendCommand(Command{Command: SetLedState, Arguments: []byte{0x00}})
+	e := expectEvents(t, c, FrontLedOff)[0]
+	if cmd, ok := e.Command(); !ok || cmd != SetLedState {
+		t.Errorf("got command %s and %v, want %s and true", cmd, ok, SetLedState)
+	}
+	if e.Time().IsZero() {
+		t.Error("got a zero time, want the time the event was published on the server")
+	}
+
+	data := make([]byte, 540)
+	done := make(chan error)
+	go func() {
+		done <- c.WriteToken(context.Background(), data, WriteUserData)
+	}()
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, ntag215WriteOrder(true))
+	expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+	if err := <-done; err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+
+	want := unlockedTestToken()
+	copy(want[16:520], data[16:520])
+	if got := v.Token(); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	name, err := c.DeviceName(context.Background())
+	if err != nil || string(name) != virtualDeviceName {
+		t.Errorf("got %s and %v, want %s and nil", name, err, virtualDeviceName)
+	}
+	expectEvents(t, c, DeviceName)
+}
+
+func TestServer_HelloTooLong(t *testing.T) {
+	addr, _, _ := newTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	defer conn.Close()
+
+	// A hello without a line end that never ends.
+	go func() {
+		conn.Write([]byte(`{"token":"`))
+		for {
+			if _, err := conn.Write(bytes.Repeat([]byte{'a'}, serverHelloSize)); err != nil {
+				return
+			}
+		}
+	}()
+
+	// The server closes the connection which is either seen as EOF or as a reset.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); err == nil || ok && ne.Timeout() {
+		t.Errorf("got %v, want the connection to be closed", err)
+	}
+}
+
+func TestServer_UnknownCommand(t *testing.T) {
+	addr, _, _ := newTestServer(t)
+	c, err := newRemoteClient(t, addr, testServerToken)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	// The unknown command is dropped by the server, so the first event is the reply to SetLedState.
+	c.SendCommand(Command{Command: 200})
+	c.SendCommand(Command{Command: SetLedState, Arguments: []byte{0x00}})
+	expectEvents(t, c, FrontLedOff)
+}
+
+func TestRemote_ServerGone(t *testing.T) {
+	addr, served, _ := newTestServer(t)
+	c, err := newRemoteClient(t, addr, testServerToken)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	served.Disconnect()
+	expectEvents(t, c, DeviceLeft, Disconnect)
+
+	select {
+	case <-c.Terminate():
+	case <-time.After(time.Second):
+		t.Error("got a running client, want it to be terminated")
+	}
+}
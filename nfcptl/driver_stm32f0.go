@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"strings"
 	"sync"
 	"time"
 )
@@ -14,7 +13,7 @@ import (
 // init MUST be used in drivers to register the driver by calling RegisterDriver. If the driver is
 // not registered, it will not be recognised!
 func init() {
-	RegisterDriver(&stm32f0{totalErrors: 10, USB: &USB{}})
+	RegisterDriver(&stm32f0{totalErrors: 10, readRetries: 2, writeRetries: 2, USBProtocol: &USB{}})
 }
 
 const (
@@ -84,6 +83,8 @@ const (
 	//   00000030  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
 	// This is used after detecting a token on the portal, right after enabling the LED with
 	// STM32F0_SetLedState.
+	// The reply holds the NTAG21x GET_VERSION response of the token: 00 04 04 02 01 00 11 03 is an
+	// NTAG215.
 	STM32F0_Unknown1 DriverCommand = 0x1f
 
 	// STM32F0_SetLedState controls the LED on the NFC portal. Sending STM32F0_SetLedState without
@@ -132,8 +133,8 @@ const (
 	//   auth = "Basic " + base64encode(Token:ResultOfCmd0x80)
 	STM32F0_GenerateApiPassword DriverCommand = 0x80
 
-	// STM32F0_GetHardwareInfo used as the payload in an interrupt message returns a yet unknown
-	// sequence but this is assumed to be hardware/firmware related info.
+	// STM32F0_GetHardwareInfo used as the payload in an interrupt message returns hardware and
+	// firmware related info. Use ParseSTM32F0HardwareInfo to decode it.
 	//   00000000  00 00 02 bf 3f 4c 17 60  3b 45 06 bd 1d be d2 0b  |....?L.`;E......|
 	//   00000010  c1 32 80 ad 41 00 00 00  00 00 00 00 00 00 00 00  |.2..A...........|
 	//   00000020  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
@@ -147,9 +148,26 @@ const (
 
 	// STM32F0_Dfu puts the STM32F0 MCU in device firmware update mode. The arguments are:
 	//   0x44 0x46 0x55 0x20
-	// After this command, the image (size 0x8000) can be sent.
+	// After this command, the image (size 0x8000) can be sent. The device does not reply: it
+	// detaches and attaches again as a USB DFU device, see OpenDFU.
 	STM32F0_Dfu DriverCommand = 0x99
 
+	// The MaxLander handles MIFARE Classic 1K tokens. Since the original software never uses
+	// STM32F0_Unknown5 and the first read/write alternatives with NTAG215 tokens, these are assumed
+	// to be the MIFARE Classic commands. This has NOT been verified on a MaxLander yet, so they are
+	// only used when the ExperimentalMFC option is set!
+
+	// STM32F0_MfcAuthenticate authenticates the sector of a MIFARE Classic token holding the given
+	// block. The arguments are the block number, the key type (MFC_AuthKeyA or MFC_AuthKeyB) and
+	// the six byte key. Like all other commands, it returns 0x01 0x02 on failure.
+	STM32F0_MfcAuthenticate DriverCommand = STM32F0_Unknown5
+	// STM32F0_MfcRead reads a single block from an authenticated sector of a MIFARE Classic token.
+	// It only takes one argument being the block number returning 16 bytes of data.
+	STM32F0_MfcRead DriverCommand = STM32F0_ReadAlt1
+	// STM32F0_MfcWrite writes 16 bytes to a single block of an authenticated sector of a MIFARE
+	// Classic token. The first argument is the block number, the second is the 16 byte payload.
+	STM32F0_MfcWrite DriverCommand = STM32F0_WriteAlt1
+
 	// STM32F0_LedOff represents the off state of the front LED.
 	STM32F0_LedOff = 0x00
 
@@ -160,6 +178,13 @@ const (
 
 var validationError = errors.New("stm32f0: token data does not match first read")
 
+// errSTM32F0PwdAuth is returned for the PwdAuth command which the STM32F0 cannot execute.
+var errSTM32F0PwdAuth = errors.New("PWD_AUTH using a given password is not supported")
+
+// errSTM32F0MFCDisabled is returned when a MIFARE Classic token is placed on the portal without
+// setting the ExperimentalMFC option.
+var errSTM32F0MFCDisabled = errors.New("stm32f0: MIFARE Classic support is experimental and disabled")
+
 // stm32f0 implements the Driver interface for STM32F0 based devices.
 type stm32f0 struct {
 	tokenMu     sync.Mutex
@@ -168,11 +193,21 @@ type stm32f0 struct {
 
 	totalErrors uint8 // Total consecutive errors before a token is to be considered removed.
 
+	mfc             bool // True when the token on the portal is a MIFARE Classic 1K token as used by the MaxLander.
+	experimentalMFC bool // Enables the unverified MIFARE Classic commands.
+
 	optimised bool // Defines the driver behavior. Setting to false mimics the original software as closely as possible.
 
+	dfu bool // True once the device has been put in DFU mode.
+
+	pollInterval     time.Duration // Overrides the poll interval of the device when not zero.
+	removalThreshold uint8         // Overrides totalErrors when not zero.
+	readRetries      int           // The number of times reading a page is retried.
+	writeRetries     int           // The number of times writing a page is retried.
+
 	c *Client
 
-	*USB // The protocol this driver works with
+	USBProtocol // The protocol this driver works with
 }
 
 // Supports implements these USB devices:
@@ -301,6 +336,71 @@ func (stm *stm32f0) Setup() any {
 	}
 }
 
+func (stm *stm32f0) New() Driver {
+	return &stm32f0{totalErrors: 10, readRetries: 2, writeRetries: 2, USBProtocol: &USB{}}
+}
+
+func (stm *stm32f0) Protocol() Protocol {
+	return stm.USBProtocol
+}
+
+func (stm *stm32f0) SetProtocol(p Protocol) error {
+	usb, ok := p.(USBProtocol)
+	if !ok {
+		return errors.New("stm32f0: protocol must implement USBProtocol")
+	}
+	stm.USBProtocol = usb
+
+	return nil
+}
+
+// Configure implements the Configurable interface supporting the Optimised, PollInterval,
+// RemovalThreshold, ReadRetries, WriteRetries and ExperimentalMFC options. In optimised mode, the token is considered removed
+// after two failed polls instead of ten unless a RemovalThreshold is set.
+func (stm *stm32f0) Configure(opts ...Option) error {
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case Optimised:
+			stm.optimised = bool(o)
+		case PollInterval:
+			if o <= 0 {
+				return &ErrInvalidOption{Option: opt}
+			}
+			stm.pollInterval = time.Duration(o)
+		case RemovalThreshold:
+			if o < 1 || o > 0xff {
+				return &ErrInvalidOption{Option: opt}
+			}
+			stm.removalThreshold = uint8(o)
+		case ReadRetries:
+			if o < 0 {
+				return &ErrInvalidOption{Option: opt}
+			}
+			stm.readRetries = int(o)
+		case WriteRetries:
+			if o < 0 {
+				return &ErrInvalidOption{Option: opt}
+			}
+			stm.writeRetries = int(o)
+		case ExperimentalMFC:
+			stm.experimentalMFC = bool(o)
+		default:
+			return &ErrUnsupportedOption{Option: opt}
+		}
+	}
+
+	return nil
+}
+
+// PollInterval returns the interval at which the device is polled: the configured poll interval
+// or the poll interval of the device when none has been configured.
+func (stm *stm32f0) PollInterval() time.Duration {
+	if stm.pollInterval > 0 {
+		return stm.pollInterval
+	}
+	return stm.USBProtocol.PollInterval()
+}
+
 func (stm *stm32f0) Drive(c *Client) {
 	stm.c = c
 	if stm.c.Debug() {
@@ -309,8 +409,9 @@ func (stm *stm32f0) Drive(c *Client) {
 
 	stm.SetIdle(0, 0)
 
-	// TODO: how to set optimised to true? Another interface function SetOptimised?
-	if stm.optimised {
+	if stm.removalThreshold > 0 {
+		stm.totalErrors = stm.removalThreshold
+	} else if stm.optimised {
 		stm.totalErrors = 2
 	}
 
@@ -376,6 +477,7 @@ func (stm *stm32f0) getDriverCommandForClientCommand(cc ClientCommand) (DriverCo
 		FetchTokenData:  STM32F0_Read,
 		WriteTokenData:  STM32F0_Write,
 		SetLedState:     STM32F0_SetLedState,
+		EnterDfu:        STM32F0_Dfu,
 	}[cc]
 	if !ok {
 		return 0, &ErrUnsupportedCommand{Command: cc}
@@ -405,26 +507,18 @@ func (stm *stm32f0) commandListener() {
 		case <-ticker.C:
 			select {
 			case cmd := <-stm.c.Commands():
-				if dc, err := stm.getDriverCommandForClientCommand(cmd.Command); err != nil {
-					stm.c.PublishEvent(NewEvent(UnknownCommand, []byte{}))
-				} else if dc == STM32F0_Write {
-					if cmd.Arguments == nil {
-						log.Println("stm32f0: no data to write")
-						stm.c.PublishEvent(NewEvent(TokenTagWriteError, nil))
-					} else {
-						stm.write(cmd.Arguments[1:], cmd.Arguments[0] == 1)
-					}
-				} else {
-					stm.sendCommand(dc, cmd.Arguments)
-				}
+				stm.handleCommand(cmd)
 			default:
 				stm.pollForToken(ticker)
 			}
 		case <-stm.c.Terminate():
-			// Ensure the NFC field is off before termination.
-			stm.sendCommand(STM32F0_RFFieldOff, []byte{})
-			// Ensure front LED is off before termination.
-			stm.sendCommand(STM32F0_SetLedState, []byte{STM32F0_LedOff})
+			// A device in DFU mode no longer accepts our commands.
+			if !stm.dfu {
+				// Ensure the NFC field is off before termination.
+				stm.sendCommand(STM32F0_RFFieldOff, []byte{})
+				// Ensure front LED is off before termination.
+				stm.sendCommand(STM32F0_SetLedState, []byte{STM32F0_LedOff})
+			}
 			// Signal the client we're done with this goroutine informing it that it's safe to
 			// disconnect.
 			stm.c.Done()
@@ -433,6 +527,32 @@ func (stm *stm32f0) commandListener() {
 	}
 }
 
+// handleCommand executes the given client command.
+func (stm *stm32f0) handleCommand(cmd Command) {
+	defer stm.c.Executing(cmd)()
+
+	if cmd.Context().Err() != nil {
+		stm.c.PublishEvent(NewEvent(CommandCancelled, []byte{byte(cmd.Command)}))
+	} else if cmd.Command == ReadPages || cmd.Command == WritePage || cmd.Command == PwdAuth {
+		handlePageCommand(stm.c, stm, "stm32f0", stm.isTokenPlaced(), cmd)
+	} else if dc, err := stm.getDriverCommandForClientCommand(cmd.Command); err != nil {
+		stm.c.PublishEvent(NewEvent(UnknownCommand, []byte{}))
+	} else if dc == STM32F0_Read {
+		stm.fetchToken()
+	} else if dc == STM32F0_Dfu {
+		stm.enterDfu()
+	} else if dc == STM32F0_Write {
+		if cmd.Arguments == nil {
+			log.Println("stm32f0: no data to write")
+			stm.c.PublishEvent(NewEvent(TokenTagWriteError, nil))
+		} else {
+			stm.write(cmd.Arguments[1:], cmd.Arguments[0])
+		}
+	} else {
+		stm.sendCommand(dc, cmd.Arguments)
+	}
+}
+
 // pollForToken executes an optimised token poll or a single three-step poll sequence to check if a
 // token is present on the NFC portal. The original software does a three step token poll but after
 // experimenting, this can be optimised to a single message on each poll.
@@ -509,6 +629,16 @@ func (stm *stm32f0) handleToken(buff []byte) {
 	}
 	stm.sendCommand(STM32F0_SetLedState, []byte{STM32F0_LedOnFull})
 
+	// MIFARE Classic tokens carry a four byte NUID, NTAG215 tokens a seven byte one.
+	stm.mfc = len(uid) == mfcUidSize
+	if stm.mfc && !stm.experimentalMFC {
+		if stm.c.Debug() {
+			log.Printf("%s", errSTM32F0MFCDisabled)
+		}
+		stm.c.PublishEvent(NewErrorEvent(TokenTagDataError, nil, errSTM32F0MFCDisabled))
+		return
+	}
+
 	//MsgOneAfterTokenDetect = []byte{0x20, 0xff}
 	//  set led to full brightness
 	//MsgTwoAfterTokenDetect byte = 0x1f
@@ -553,9 +683,15 @@ func (stm *stm32f0) handleToken(buff []byte) {
 		{STM32F0_RFFieldOn: {}},
 		{STM32F0_GetTokenUid: {}},
 	}
+	// The sequence above is only done for NTAG215 tokens, MIFARE Classic tokens can be read right
+	// away.
+	if stm.mfc {
+		cmds = nil
+	}
 
 	page16 := make([]byte, 16)
 	key := make([]byte, 16)
+	var version []byte
 
 	// Prepare read.
 	for _, item := range cmds {
@@ -567,9 +703,19 @@ func (stm *stm32f0) handleToken(buff []byte) {
 				args = append([]byte{0x00}, key...)
 			}
 
-			r, _ := stm.sendCommand(cmd, args)
+			r, isErr := stm.sendCommand(cmd, args)
 
 			switch cmd {
+			case STM32F0_Unknown1:
+				// Only the first call returns the GET_VERSION response.
+				if version == nil && !isErr && r != nil {
+					version = r[2:10]
+					stm.c.PublishEvent(NewEvent(TokenTagVersion, version))
+				}
+			case STM32F0_ReadSignature:
+				if !isErr && r != nil {
+					stm.c.PublishEvent(NewEvent(TokenTagSignature, r[2:34]))
+				}
 			case STM32F0_Read:
 				copy(page16, r[2:])
 			case STM32F0_MakeKey:
@@ -584,7 +730,7 @@ func (stm *stm32f0) handleToken(buff []byte) {
 		if stm.c.Debug() {
 			log.Printf("%s", err)
 		}
-		stm.c.PublishEvent(NewEvent(TokenTagDataError, token))
+		stm.c.PublishEvent(NewErrorEvent(TokenTagDataError, token, err))
 		if err == validationError {
 			return
 		}
@@ -597,8 +743,34 @@ func (stm *stm32f0) handleToken(buff []byte) {
 	stm.c.PublishEvent(NewEvent(TokenTagData, token))
 }
 
-// readToken actually reads the token data and returns it as a byte slice.
+// fetchToken reads the token placed on the portal on request of the client. It publishes the
+// TokenTagData event or the TokenTagDataError event when the token could not be read.
+func (stm *stm32f0) fetchToken() {
+	token, err := stm.readTokenWithValidation()
+	if err != nil {
+		if stm.c.Debug() {
+			log.Printf("%s", err)
+		}
+		stm.c.PublishEvent(NewErrorEvent(TokenTagDataError, token, err))
+		return
+	}
+
+	stm.c.PublishEvent(NewEvent(TokenTagData, token))
+}
+
+// readToken actually reads the token data and returns it as a byte slice. For a MIFARE Classic
+// token, the amiibo image stored on it is returned when the ExperimentalMFC option is set.
 func (stm *stm32f0) readToken() ([]byte, error) {
+	if stm.mfc && !stm.experimentalMFC {
+		return nil, errSTM32F0MFCDisabled
+	} else if stm.mfc {
+		token, err := readMFC1K(stm)
+		if err != nil {
+			err = fmt.Errorf("stm32f0: %v", err)
+		}
+		return token, err
+	}
+
 	var i byte
 	token := make([]byte, 540)
 	n := 0
@@ -607,7 +779,7 @@ func (stm *stm32f0) readToken() ([]byte, error) {
 	read:
 		res, isErr := stm.sendCommand(STM32F0_Read, []byte{i})
 		if isErr {
-			if pageErrors++; pageErrors > 2 {
+			if pageErrors++; pageErrors > stm.readRetries {
 				return token, fmt.Errorf("stm32f0: failed to read page %#02x", i)
 			} else {
 				// Try reading the same page again.
@@ -623,6 +795,31 @@ func (stm *stm32f0) readToken() ([]byte, error) {
 	return token, nil
 }
 
+// readPages reads the four pages starting at the given page using STM32F0_Read. A failed read is
+// retried readRetries times.
+func (stm *stm32f0) readPages(page byte) ([]byte, error) {
+	for i := 0; i <= stm.readRetries; i++ {
+		if res, isErr := stm.sendCommand(STM32F0_Read, []byte{page}); !isErr {
+			return res[2:18], nil
+		}
+	}
+	return nil, fmt.Errorf("read of page %#02x failed", page)
+}
+
+// writePage writes four bytes of data to the given page using STM32F0_Write.
+func (stm *stm32f0) writePage(page byte, data []byte) error {
+	if _, isErr := stm.sendCommand(STM32F0_Write, append([]byte{page}, data...)); isErr {
+		return fmt.Errorf("write of page %#02x failed", page)
+	}
+	return nil
+}
+
+// pwdAuth is not supported: STM32F0_Unlock does not take a password, it always authenticates using
+// the amiibo password.
+func (stm *stm32f0) pwdAuth(pwd []byte) ([]byte, error) {
+	return nil, errSTM32F0PwdAuth
+}
+
 // readTokenWithValidation will read the token data. After a successful read, it will be read again
 // and compared to the first read to see if the data matches. This is the original software behavior
 // as observed on the wire.
@@ -642,14 +839,26 @@ func (stm *stm32f0) readTokenWithValidation() ([]byte, error) {
 	return token, nil
 }
 
-// write writes the given amiibo data to the PUC. When userdataOnly is false, all 540 bytes will be
-// written to the PUC. When userdataOnly is true, only pages 0x04 up to and including 0x81 (the
-// NTAG215 user data area) will be written. The original software labels this as 'restoring a
-// backup'.
-// When userdataOnly is true, it is imperative that the given token matches the data that is already
+// write writes the given amiibo data to the PUC using the given WriteTokenData mode. For a full
+// write, all 540 bytes will be written to the PUC. For a user data write, only pages 0x04 up to and
+// including 0x81 (the NTAG215 user data area) will be written. The original software labels this as
+// 'restoring a backup'.
+// For a user data write, it is imperative that the given token matches the data that is already
 // present on the PUC. Failing to do so will result in an invalid amiibo. It is the responsibility
 // of the caller to do this validation beforehand!
-func (stm *stm32f0) write(data []byte, userdataOnly bool) {
+// In smart mode, the token is read after unlocking it and only the pages that differ from the given
+// data are written, still respecting the write order of a full write.
+// In provisioning mode, a blank NTAG215 token is turned into an amiibo by writing the pages in the
+// order of ntag215ProvisionOrder. The token is unlocked again using STM32F0_Unlock right after
+// writing AUTH0 and the write is always verified.
+// Before writing an NTAG215 token, its lock bytes are read and the write is refused using the
+// TokenTagWriteLocked event when a locked page would have to change.
+// In verify mode, the token data read after the write is compared to the given data. The
+// TokenTagWriteFinish event is only published when they match, otherwise the write ends with the
+// TokenTagVerifyError event.
+// A MIFARE Classic token is written using the same amiibo image layout readToken uses when the
+// ExperimentalMFC option is set, otherwise the write is refused.
+func (stm *stm32f0) write(data []byte, mode byte) {
 	got := len(data)
 	want := 540
 	if got != want {
@@ -658,10 +867,19 @@ func (stm *stm32f0) write(data []byte, userdataOnly bool) {
 		return
 	}
 
+	userdataOnly := mode&WriteUserData != 0
+	provision := mode&WriteProvision != 0
+	smart := mode&WriteSmart != 0 && !provision
 	msg := "full"
 	if userdataOnly {
 		msg = "user"
 	}
+	if provision {
+		msg = "provisioning"
+	}
+	if smart {
+		msg = "smart " + msg
+	}
 
 	if stm.c.Debug() {
 		var dump string
@@ -716,8 +934,6 @@ func (stm *stm32f0) write(data []byte, userdataOnly bool) {
 		{STM32F0_GetTokenUid: {}},
 	}
 
-	startPage := 0x04
-	lastPage := 0x81
 	if !userdataOnly {
 		// To get a successful FULL write, we must do an init dance similar to the read init, but not entirely equal.
 		cmds = append(cmds, []map[DriverCommand][]byte{
@@ -725,9 +941,6 @@ func (stm *stm32f0) write(data []byte, userdataOnly bool) {
 			{STM32F0_MakeKey: {}},
 			{STM32F0_Unknown4: {}},
 		}...)
-
-		startPage = 0
-		lastPage = 0x86
 	}
 
 	cmds = append(cmds, map[DriverCommand][]byte{STM32F0_Unlock: {}})
@@ -737,6 +950,7 @@ func (stm *stm32f0) write(data []byte, userdataOnly bool) {
 	key := make([]byte, 16)
 	var uid []byte
 
+prepare:
 	for _, item := range cmds {
 		for cmd, args := range item {
 			switch cmd {
@@ -756,6 +970,10 @@ func (stm *stm32f0) write(data []byte, userdataOnly bool) {
 					stm.c.PublishEvent(NewEvent(TokenTagWriteError, nil))
 					return
 				}
+				// The rest of the sequence is only needed for NTAG215 tokens.
+				if stm.mfc = len(uid) == mfcUidSize; stm.mfc {
+					break prepare
+				}
 			case STM32F0_Read:
 				copy(page16, r[2:])
 			case STM32F0_MakeKey:
@@ -765,40 +983,66 @@ func (stm *stm32f0) write(data []byte, userdataOnly bool) {
 	}
 
 	// Actual write.
-	totalWrites := startPage
-	page := totalWrites
-	for totalWrites < lastPage+1 {
-		// For a full write, we need to write a total of 135 pages, but we write the last page first and the first
-		// page last as the original software does too.
-		// For a user data write, we never start at page 0 nor will we reach page 0x86.
-		switch totalWrites {
-		case 0:
-			page = 0x86
-		case 0x86:
-			page = 0
-		}
-		i := page * 4 // Convert page number to index: one page has four bytes of data.
-		pageErrors := 0
-	write:
-		// TODO: should we send events here for each page that we're writing so that clients can display progress?
-		// byte(page) conversion is safe here since we stick to NTAG215 pages
-		_, isErr := stm.sendCommand(STM32F0_Write, append([]byte{byte(page)}, data[i:i+4]...))
-		if isErr {
-			if pageErrors++; pageErrors > 2 {
-				log.Printf("stm32f0: failed to write page %#02x", page)
-				stm.c.PublishEvent(NewEvent(TokenTagWriteError, []byte{byte(page)}))
-				return
-			} else {
-				// Try writing the same page again.
-				goto write
+	if stm.mfc && provision {
+		log.Println("stm32f0: provisioning is only supported for NTAG215 tokens")
+		stm.c.PublishEvent(NewEvent(TokenTagWriteError, nil))
+		return
+	} else if stm.mfc && !stm.experimentalMFC {
+		log.Printf("%s", errSTM32F0MFCDisabled)
+		stm.c.PublishEvent(NewErrorEvent(TokenTagWriteError, nil, errSTM32F0MFCDisabled))
+		return
+	} else if stm.mfc {
+		if block, err := writeMFC1K(stm.c, stm, data, userdataOnly, smart); err != nil {
+			log.Printf("stm32f0: %s", err)
+			var args []byte
+			if block >= 0 {
+				args = []byte{byte(block)}
 			}
+			stm.c.PublishEvent(NewErrorEvent(TokenTagWriteError, args, err))
+			return
+		}
+	} else {
+		// Make sure the write is possible before writing anything. In smart mode the full token is
+		// needed anyway.
+		pages := ntag215Pages(mode)
+		var token []byte
+		var err error
+		if smart {
+			token, err = stm.readToken()
+		} else if token, err = readNTAG215Locks(stm.readPages, pages); err != nil {
+			err = fmt.Errorf("stm32f0: %v", err)
+		}
+		if err != nil {
+			log.Printf("%s", err)
+			stm.c.PublishEvent(NewErrorEvent(TokenTagWriteError, nil, err))
+			return
+		}
+		locked := ntag215LockedChanges(token, data, pages)
+		if provision {
+			locked = append(ntag215UIDChanges(token, data), locked...)
+		}
+		if locked != nil {
+			log.Printf("stm32f0: write refused, locked pages %x would have to change", locked)
+			stm.c.PublishEvent(NewEvent(TokenTagWriteLocked, locked))
+			return
+		}
+		if smart {
+			pages = ntag215ChangedPages(token, data, pages)
+			log.Printf("stm32f0: %d pages differ from the token", len(pages))
+		}
+		if page, ok := stm.writePages(data, pages, provision); !ok {
+			log.Printf("stm32f0: failed to write page %#02x", page)
+			stm.c.PublishEvent(NewEvent(TokenTagWriteError, []byte{page}))
+			return
 		}
-		totalWrites++
-		page = totalWrites
 	}
 
-	stm.c.PublishEvent(NewEvent(TokenTagWriteFinish, nil))
 	log.Println("stm32f0: successfully finished write procedure")
+	// In verify mode the write only finishes once the token data read back has been verified.
+	verify := mode&(WriteVerify|WriteProvision) != 0
+	if !verify {
+		stm.c.PublishEvent(NewEvent(TokenTagWriteFinish, nil))
+	}
 
 	// Validate write.
 	token, err := stm.readTokenWithValidation()
@@ -806,7 +1050,7 @@ func (stm *stm32f0) write(data []byte, userdataOnly bool) {
 		if stm.c.Debug() {
 			log.Printf("%s", err)
 		}
-		stm.c.PublishEvent(NewEvent(TokenTagDataError, token))
+		stm.c.PublishEvent(NewErrorEvent(TokenTagDataError, token, err))
 		return
 	}
 
@@ -814,11 +1058,70 @@ func (stm *stm32f0) write(data []byte, userdataOnly bool) {
 		log.Println("stm32f0: full token data read after write:")
 		log.Println(hex.Dump(token))
 	}
+	if verify {
+		verifyWrite(stm.c, "stm32f0", token, data, ntag215Pages(mode))
+	}
 	stm.c.PublishEvent(NewEvent(TokenTagData, token))
 
 	return
 }
 
+// writePages writes the given pages of the given amiibo data to the token in the given order. See
+// ntag215WriteOrder for the order the original software uses. The TokenTagWriteProgress event is
+// published for each write attempt. It returns false together with the page that failed on error.
+// When provisioning, the token is unlocked again before writing CFG1 in page 0x84 since AUTH0 has
+// been written by then.
+func (stm *stm32f0) writePages(data []byte, pages []int, provision bool) (byte, bool) {
+	for n, page := range pages {
+		i := page * 4 // Convert page number to index: one page has four bytes of data.
+		if provision && page == 0x84 {
+			if _, isErr := stm.sendCommand(STM32F0_Unlock, nil); isErr {
+				return byte(page), false
+			}
+		}
+		pageErrors := 0
+	write:
+		publishWriteProgress(stm.c, page, n+1, len(pages), pageErrors)
+		// byte(page) conversion is safe here since we stick to NTAG215 pages
+		_, isErr := stm.sendCommand(STM32F0_Write, append([]byte{byte(page)}, data[i:i+4]...))
+		if isErr {
+			if pageErrors++; pageErrors > stm.writeRetries {
+				return byte(page), false
+			} else {
+				// Try writing the same page again.
+				goto write
+			}
+		}
+	}
+
+	return 0, true
+}
+
+// authenticate implements the mfcDevice interface using STM32F0_MfcAuthenticate.
+func (stm *stm32f0) authenticate(block, keyType byte, key []byte) error {
+	if _, isErr := stm.sendCommand(STM32F0_MfcAuthenticate, append([]byte{block, keyType}, key...)); isErr {
+		return fmt.Errorf("authentication failed for block %#02x", block)
+	}
+	return nil
+}
+
+// readBlock implements the mfcDevice interface using STM32F0_MfcRead.
+func (stm *stm32f0) readBlock(block byte) ([]byte, error) {
+	res, isErr := stm.sendCommand(STM32F0_MfcRead, []byte{block})
+	if isErr || len(res) < 2+mfcBlockSize {
+		return nil, fmt.Errorf("read failed for block %#02x", block)
+	}
+	return res[2 : 2+mfcBlockSize], nil
+}
+
+// writeBlock implements the mfcDevice interface using STM32F0_MfcWrite.
+func (stm *stm32f0) writeBlock(block byte, data []byte) error {
+	if _, isErr := stm.sendCommand(STM32F0_MfcWrite, append([]byte{block}, data...)); isErr {
+		return fmt.Errorf("write failed for block %#02x", block)
+	}
+	return nil
+}
+
 // Write sequence:
 //    0x11 -> turn off nfc field
 //    0x10 -> turn on nfc field
@@ -858,7 +1161,8 @@ func (stm *stm32f0) getEventForDriverCommand(dc DriverCommand, args []byte) Even
 
 // sendCommand sends a command to the device and reads the response. It will return the response
 // together with a boolean value indicating if the response contains an error (first two bytes 0x01
-// 0x02) or not.
+// 0x02) or not. When the device is gone, the client is told so and an empty response is returned as
+// an error.
 func (stm *stm32f0) sendCommand(cmd DriverCommand, args []byte) ([]byte, bool) {
 	maxSize := stm.MaxPacketSize()
 
@@ -871,12 +1175,13 @@ func (stm *stm32f0) sendCommand(cmd DriverCommand, args []byte) ([]byte, bool) {
 		log.Println("stm32f0: sending command:")
 		log.Println(hex.Dump(usbCmd.Marshal())) // No Println here since hex.Dump() prints a newline.
 	}
+	b := make([]byte, maxSize)
 	n, err := stm.Write(usbCmd.Marshal())
 	if err != nil {
 		log.Printf("stm32f0: %s", err)
-		if strings.Contains(err.Error(), "no device") {
-			stm.c.Disconnect()
-			return nil, false
+		if IsDeviceGone(err) {
+			stm.c.DeviceLost(err)
+			return b, true
 		}
 	}
 	if stm.c.Debug() {
@@ -884,10 +1189,13 @@ func (stm *stm32f0) sendCommand(cmd DriverCommand, args []byte) ([]byte, bool) {
 	}
 
 	// Read response.
-	b := make([]byte, maxSize)
-	// STM32F0_SetLedState does not get a response!
-	if cmd != STM32F0_SetLedState {
-		stm.Read(b) // TODO: error handling?
+	// STM32F0_SetLedState and STM32F0_Dfu do not get a response!
+	if cmd != STM32F0_SetLedState && cmd != STM32F0_Dfu {
+		if _, err := stm.Read(b); IsDeviceGone(err) {
+			log.Printf("stm32f0: %s", err)
+			stm.c.DeviceLost(err)
+			return b, true
+		}
 		if stm.c.Debug() {
 			log.Println("stm32f0: command reply:")
 			log.Println(hex.Dump(b))
@@ -0,0 +1,108 @@
+package nfcptl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// STM32F0FirmwareSize is the size of a firmware image for STM32F0 based devices.
+	STM32F0FirmwareSize = 0x8000
+
+	// VIDSTMicroelectronics is the vendor ID of the STM32 system memory bootloader.
+	VIDSTMicroelectronics uint16 = 0x0483
+	// PIDSTM32DFU is the product ID of the STM32 system memory bootloader in DFU mode.
+	PIDSTM32DFU uint16 = 0xdf11
+
+	// stm32f0HardwareInfoSize is the size of the hardware info in the reply to
+	// STM32F0_GetHardwareInfo, not counting the two leading status bytes.
+	stm32f0HardwareInfoSize = 19
+)
+
+// stm32f0DfuMagic is the argument of STM32F0_Dfu: "DFU ".
+var stm32f0DfuMagic = []byte{0x44, 0x46, 0x55, 0x20}
+
+var (
+	// ErrInvalidHardwareInfo is returned by ParseSTM32F0HardwareInfo when the data is not a reply
+	// to STM32F0_GetHardwareInfo.
+	ErrInvalidHardwareInfo = errors.New("stm32f0: invalid hardware info")
+	// ErrInvalidFirmware is returned by ValidateSTM32F0Firmware when the image is not a firmware
+	// image for an STM32F0 MCU.
+	ErrInvalidFirmware = errors.New("stm32f0: invalid firmware image")
+
+	// errDfuMode is passed to Client.DeviceLost once the device has been put in DFU mode.
+	errDfuMode = errors.New("stm32f0: device has been put in DFU mode")
+)
+
+// STM32F0HardwareInfo holds the decoded reply to STM32F0_GetHardwareInfo. The layout has been
+// derived from the replies of two devices: after the two status bytes, a single byte holds the
+// firmware version, being 0x01 on an older device and 0x02 on a newer one, followed by 18 bytes
+// that are unique to each device.
+type STM32F0HardwareInfo struct {
+	FirmwareVersion int
+	Serial          []byte
+}
+
+// String returns the hardware info as a human readable string.
+func (hi STM32F0HardwareInfo) String() string {
+	return fmt.Sprintf("firmware v%d, serial %x", hi.FirmwareVersion, hi.Serial)
+}
+
+// ParseSTM32F0HardwareInfo decodes the data of the HardwareInfo event published by the STM32F0
+// driver.
+func ParseSTM32F0HardwareInfo(data []byte) (*STM32F0HardwareInfo, error) {
+	if len(data) < 2+stm32f0HardwareInfoSize || data[0] != 0x00 || data[1] != 0x00 {
+		return nil, ErrInvalidHardwareInfo
+	}
+
+	serial := make([]byte, stm32f0HardwareInfoSize-1)
+	copy(serial, data[3:])
+
+	return &STM32F0HardwareInfo{
+		FirmwareVersion: int(data[2]),
+		Serial:          serial,
+	}, nil
+}
+
+// STM32F0DFUTarget returns the DFUTarget of the given STM32F0 based device once it has been put in
+// DFU mode: the STM32 system memory bootloader attaching to the same USB port the device was
+// attached to. The DeviceInfo MUST be the one returned by ListDevices, holding the port path.
+// Note that the bootloader uses DfuSe, so DFU.Flash refuses to flash it.
+func STM32F0DFUTarget(di DeviceInfo) DFUTarget {
+	return DFUTarget{VendorId: VIDSTMicroelectronics, ProductId: PIDSTM32DFU, Bus: di.Bus, Path: di.Path}
+}
+
+// ValidateSTM32F0Firmware guards against flashing anything but an STM32F0 firmware image to the
+// device. The image must be STM32F0FirmwareSize bytes long and start with a vector table holding
+// an initial stack pointer in SRAM and a reset handler in flash memory.
+func ValidateSTM32F0Firmware(image []byte) error {
+	if len(image) != STM32F0FirmwareSize {
+		return fmt.Errorf("%w: size is %d bytes, want %d", ErrInvalidFirmware, len(image), STM32F0FirmwareSize)
+	}
+
+	sp := binary.LittleEndian.Uint32(image[0:4])
+	if sp < 0x20000000 || sp > 0x20008000 {
+		return fmt.Errorf("%w: initial stack pointer %#08x is not in SRAM", ErrInvalidFirmware, sp)
+	}
+	// The reset handler is Thumb code, so its address must be odd.
+	reset := binary.LittleEndian.Uint32(image[4:8])
+	if reset < 0x08000000 || reset >= 0x08020000 || reset&0x01 == 0 {
+		return fmt.Errorf("%w: reset handler %#08x is not in flash memory", ErrInvalidFirmware, reset)
+	}
+
+	return nil
+}
+
+// enterDfu puts the device in DFU mode. The device detaches right away, so the client is told the
+// device is gone after publishing the DfuMode event.
+func (stm *stm32f0) enterDfu() {
+	if _, isErr := stm.sendCommand(STM32F0_Dfu, stm32f0DfuMagic); isErr {
+		// The device was gone already.
+		return
+	}
+
+	stm.dfu = true
+	stm.c.PublishEvent(NewEvent(DfuMode, []byte{}))
+	stm.c.DeviceLost(errDfuMode)
+}
@@ -0,0 +1,81 @@
+package nfcptl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// testFirmware returns a valid STM32F0 firmware image.
+func testFirmware() []byte {
+	image := bytes.Repeat([]byte{0xff}, STM32F0FirmwareSize)
+	binary.LittleEndian.PutUint32(image[0:4], 0x20001000)
+	binary.LittleEndian.PutUint32(image[4:8], 0x080000c1)
+	for i := 8; i < 0x400; i++ {
+		image[i] = byte(i)
+	}
+	return image
+}
+
+func TestParseSTM32F0HardwareInfo(t *testing.T) {
+	tests := map[string]struct {
+		data    string
+		version int
+		serial  string
+	}{
+		"newer": {"000002bf3f4c17603b4506bd1dbed20bc13280ad4100000000", 2, "bf3f4c17603b4506bd1dbed20bc13280ad41"},
+		"older": {"000001ffff16a3663043626c23bd695dc333f02d3f00000000", 1, "ffff16a3663043626c23bd695dc333f02d3f"},
+	}
+
+	for name, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		hi, err := ParseSTM32F0HardwareInfo(data)
+		if err != nil {
+			t.Fatalf("%s: got %s, want nil", name, err)
+		}
+		if hi.FirmwareVersion != test.version {
+			t.Errorf("%s: got %d, want %d", name, hi.FirmwareVersion, test.version)
+		}
+		if got := hex.EncodeToString(hi.Serial); got != test.serial {
+			t.Errorf("%s: got %s, want %s", name, got, test.serial)
+		}
+	}
+
+	for _, data := range [][]byte{nil, {0x00, 0x00, 0x02}, append([]byte{0x01, 0x02}, make([]byte, 62)...)} {
+		if _, err := ParseSTM32F0HardwareInfo(data); err != ErrInvalidHardwareInfo {
+			t.Errorf("got %v, want %s", err, ErrInvalidHardwareInfo)
+		}
+	}
+}
+
+func TestValidateSTM32F0Firmware(t *testing.T) {
+	if err := ValidateSTM32F0Firmware(testFirmware()); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+
+	short := testFirmware()[:0x4000]
+	noStack := testFirmware()
+	binary.LittleEndian.PutUint32(noStack[0:4], 0xffffffff)
+	noReset := testFirmware()
+	binary.LittleEndian.PutUint32(noReset[4:8], 0x20000001)
+	noThumb := testFirmware()
+	binary.LittleEndian.PutUint32(noThumb[4:8], 0x080000c0)
+
+	for name, image := range map[string][]byte{"short": short, "no stack": noStack, "no reset": noReset, "no thumb": noThumb} {
+		if err := ValidateSTM32F0Firmware(image); !errors.Is(err, ErrInvalidFirmware) {
+			t.Errorf("%s: got %v, want %s", name, err, ErrInvalidFirmware)
+		}
+	}
+}
+
+func TestStm32f0_ReplayEnterDfu(t *testing.T) {
+	c, r := replayStm32f0(t, VendorDatelElextronicsLtd, ProductPowerSavesForAmiibo, "stm32f0_dfu.rec", Command{Command: EnterDfu})
+
+	evs := expectEvents(t, c, DfuMode, DeviceLeft, Disconnect)
+	if cmd, ok := evs[0].Command(); !ok || cmd != EnterDfu {
+		t.Errorf("got command %s and %v, want %s and true", cmd, ok, EnterDfu)
+	}
+	replayDone(t, r)
+}
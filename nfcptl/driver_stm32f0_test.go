@@ -3,6 +3,7 @@ package nfcptl
 import (
 	"bytes"
 	"testing"
+	"time"
 )
 
 func TestStm32f0_VendorId(t *testing.T) {
@@ -308,3 +309,333 @@ func TestStm32f0_createArguments(t *testing.T) {
 		t.Errorf("createArguments() returned %#x, want %#x", got, want)
 	}
 }
+
+func TestStm32f0_ReplayDeviceName(t *testing.T) {
+	c, err := NewClient(VendorDatelElextronicsLtd, ProductPowerSavesForAmiibo, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	r := NewReplay(loadRecording(t, "stm32f0_device_name.rec"))
+	if err := c.Replay(r); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	c.SendCommand(Command{Command: GetDeviceName})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	defer func() {
+		go func() {
+			for range c.Events() {
+			}
+		}()
+		c.Disconnect()
+	}()
+
+	select {
+	case e := <-c.Events():
+		if e.Name() != DeviceName {
+			t.Fatalf("got %s, want %s", e.Name(), DeviceName)
+		}
+		if got, want := string(e.Data()[2:12]), "NFC-Portal"; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("got no event, want %s", DeviceName)
+	}
+
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second):
+		t.Fatal("replay not done, want done")
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+}
+
+// replayStm32f0 returns a connected Client for the given device replaying the given recording. The
+// given commands are sent before connecting.
+func replayStm32f0(t *testing.T, vendor, product, name string, cmds ...Command) (*Client, *Replay) {
+	return replayStm32f0Options(t, vendor, product, name, nil, cmds...)
+}
+
+// replayStm32f0Options is replayStm32f0 configuring the driver using the given options first.
+func replayStm32f0Options(t *testing.T, vendor, product, name string, opts []Option, cmds ...Command) (*Client, *Replay) {
+	c, err := NewClient(vendor, product, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if len(opts) > 0 {
+		if err := c.Configure(opts...); err != nil {
+			t.Fatalf("got %s, want nil", err)
+		}
+	}
+
+	r := NewReplay(loadRecording(t, name))
+	if err := c.Replay(r); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	for _, cmd := range cmds {
+		c.SendCommand(cmd)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	t.Cleanup(func() {
+		go func() {
+			for range c.Events() {
+			}
+		}()
+		c.Disconnect()
+	})
+
+	return c, r
+}
+
+// replayDone fails the test when the given Replay did not finish without errors.
+func replayDone(t *testing.T, r *Replay) {
+	t.Helper()
+
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second):
+		t.Fatal("replay not done, want done")
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+}
+
+func TestStm32f0_ReplayNtag215Read(t *testing.T) {
+	c, r := replayStm32f0(t, VendorDatelElextronicsLtd, ProductPowerSavesForAmiibo, "stm32f0_ntag215_read.rec")
+
+	evs := expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagVersion, TokenTagSignature, TokenTagData)
+	if !bytes.Equal(evs[2].Data(), ntag215Version) {
+		t.Errorf("got %x, want %x", evs[2].Data(), ntag215Version)
+	}
+	if want := bytes.Repeat([]byte{0xa5}, 32); !bytes.Equal(evs[3].Data(), want) {
+		t.Errorf("got %x, want %x", evs[3].Data(), want)
+	}
+	if want := readTokenData(testToken()); !bytes.Equal(evs[4].Data(), want) {
+		t.Errorf("got %x, want %x", evs[4].Data(), want)
+	}
+
+	replayDone(t, r)
+}
+
+func TestStm32f0_ReplayNtag215SmartWrite(t *testing.T) {
+	data := testToken()
+	data[0x04*4] ^= 0xff
+	data[0x20*4+3] ^= 0xff
+	c, r := replayStm32f0(t, VendorDatelElextronicsLtd, ProductPowerSavesForAmiibo, "stm32f0_ntag215_smart_write.rec", Command{Command: WriteTokenData, Arguments: append([]byte{WriteUserData | WriteSmart}, data...)})
+
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, []int{0x04, 0x20})
+	evs := expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+	if want := readTokenData(data); !bytes.Equal(evs[1].Data(), want) {
+		t.Errorf("got %x, want %x", evs[1].Data(), want)
+	}
+
+	replayDone(t, r)
+}
+
+func TestStm32f0_ReplayNtag215SmartWriteVerify(t *testing.T) {
+	data := testToken()
+	data[0x04*4] ^= 0xff
+	data[0x20*4+3] ^= 0xff
+	c, r := replayStm32f0(t, VendorDatelElextronicsLtd, ProductPowerSavesForAmiibo, "stm32f0_ntag215_smart_write.rec", Command{Command: WriteTokenData, Arguments: append([]byte{WriteUserData | WriteSmart | WriteVerify}, data...)})
+
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, []int{0x04, 0x20})
+	// The write only finishes once the token data read back has been verified.
+	evs := expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+	if want := readTokenData(data); !bytes.Equal(evs[1].Data(), want) {
+		t.Errorf("got %x, want %x", evs[1].Data(), want)
+	}
+
+	replayDone(t, r)
+}
+
+func TestStm32f0_ReplayMfc1kRead(t *testing.T) {
+	c, r := replayStm32f0Options(t, VendorMaxlander, ProductMaxLander, "stm32f0_mfc1k_read.rec", []Option{ExperimentalMFC(true)})
+
+	evs := expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagData)
+	p := &stm32f0{}
+	if got, want := p.extractNuid(evs[0].Data()), []byte{0x5a, 0x3c, 0x91, 0x0e}; !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+	if got, want := evs[2].Data(), testToken(); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	replayDone(t, r)
+}
+
+func TestStm32f0_ReplayMfc1kWrite(t *testing.T) {
+	c, r := replayStm32f0Options(t, VendorMaxlander, ProductMaxLander, "stm32f0_mfc1k_write.rec", []Option{ExperimentalMFC(true)}, Command{Command: WriteTokenData, Arguments: append([]byte{0x00}, testToken()...)})
+
+	expectEvents(t, c, TokenTagWriteStart)
+	var blocks []int
+	for _, b := range mfc1kAmiiboBlocks {
+		blocks = append(blocks, int(b))
+	}
+	expectWriteProgress(t, c, blocks)
+	evs := expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+	if got, want := evs[1].Data(), testToken(); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	replayDone(t, r)
+}
+
+func TestStm32f0_ReplayMfc1kDisabled(t *testing.T) {
+	c, r := replayStm32f0(t, VendorMaxlander, ProductMaxLander, "stm32f0_mfc1k_disabled.rec")
+
+	evs := expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagDataError)
+	if err := evs[2].Err(); err != errSTM32F0MFCDisabled {
+		t.Errorf("got %v, want %s", err, errSTM32F0MFCDisabled)
+	}
+
+	replayDone(t, r)
+}
+
+func TestStm32f0_ReplayMfc1kWriteDisabled(t *testing.T) {
+	c, r := replayStm32f0(t, VendorMaxlander, ProductMaxLander, "stm32f0_mfc1k_write_disabled.rec", Command{Command: WriteTokenData, Arguments: append([]byte{0x00}, testToken()...)})
+
+	evs := expectEvents(t, c, TokenTagWriteStart, TokenTagWriteError)
+	if err := evs[1].Err(); err != errSTM32F0MFCDisabled {
+		t.Errorf("got %v, want %s", err, errSTM32F0MFCDisabled)
+	}
+
+	replayDone(t, r)
+}
+
+func TestStm32f0_ReplayUnplugged(t *testing.T) {
+	c, r := replayStm32f0(t, VendorDatelElextronicsLtd, ProductPowerSavesForAmiibo, "stm32f0_unplugged.rec")
+
+	evs := expectEvents(t, c, DeviceLeft)
+	di, ok := DeviceInfoFromEvent(evs[0])
+	if want := (DeviceInfo{Vendor: VendorDatelElextronicsLtd, Product: ProductPowerSavesForAmiibo, VendorId: VIDDatelElectronicsLtd, ProductId: PIDPowerSavesForAmiibo}); !ok || di != want {
+		t.Errorf("got %+v, want %+v", di, want)
+	}
+	replayDone(t, r)
+
+	// The client shuts down by itself.
+	disconnected := false
+	for e := range c.Events() {
+		disconnected = disconnected || e.Name() == Disconnect
+	}
+	if !disconnected {
+		t.Error("got no Disconnect event, want Disconnect")
+	}
+}
+
+// unsupportedOption is an Option no driver supports.
+type unsupportedOption struct{}
+
+func (unsupportedOption) OptionName() string { return "unsupported" }
+
+func TestStm32f0_Configure(t *testing.T) {
+	stm := &stm32f0{readRetries: 2, writeRetries: 2}
+	if err := stm.Configure(Optimised(true), PollInterval(100*time.Millisecond), RemovalThreshold(5), ReadRetries(4), WriteRetries(0), ExperimentalMFC(true)); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if !stm.optimised {
+		t.Error("got false, want true")
+	}
+	if stm.PollInterval() != 100*time.Millisecond {
+		t.Errorf("got %s, want %s", stm.PollInterval(), 100*time.Millisecond)
+	}
+	if stm.removalThreshold != 5 {
+		t.Errorf("got %d, want 5", stm.removalThreshold)
+	}
+	if stm.readRetries != 4 {
+		t.Errorf("got %d, want 4", stm.readRetries)
+	}
+	if stm.writeRetries != 0 {
+		t.Errorf("got %d, want 0", stm.writeRetries)
+	}
+	if !stm.experimentalMFC {
+		t.Error("got false, want true")
+	}
+
+	invalid := []Option{PollInterval(0), RemovalThreshold(0), RemovalThreshold(256), ReadRetries(-1), WriteRetries(-1)}
+	for _, opt := range invalid {
+		err := stm.Configure(opt)
+		if _, ok := err.(*ErrInvalidOption); !ok {
+			t.Errorf("got %v, want ErrInvalidOption for %s %v", err, opt.OptionName(), opt)
+		}
+	}
+
+	err := stm.Configure(unsupportedOption{})
+	if _, ok := err.(*ErrUnsupportedOption); !ok {
+		t.Errorf("got %v, want ErrUnsupportedOption", err)
+	}
+}
+
+// failingUSB implements the USBProtocol interface replying to each command with an error.
+type failingUSB struct {
+	writes int
+}
+
+func (f *failingUSB) Connect(c *Client) error      { return nil }
+func (f *failingUSB) Disconnect() error            { return nil }
+func (f *failingUSB) Write(p []byte) (int, error)  { f.writes++; return len(p), nil }
+func (f *failingUSB) SetIdle(val, idx uint16)      {}
+func (f *failingUSB) PollInterval() time.Duration  { return time.Millisecond }
+func (f *failingUSB) MaxPacketSize() int           { return 64 }
+func (f *failingUSB) Read(buf []byte) (int, error) { return copy(buf, []byte{0x01, 0x02}), nil }
+
+func TestStm32f0_ReadRetries(t *testing.T) {
+	c, _ := newTestClient(t)
+	usb := &failingUSB{}
+	stm := &stm32f0{readRetries: 2, writeRetries: 5, USBProtocol: usb, c: c}
+	if err := stm.Configure(ReadRetries(1)); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	if _, err := stm.readPages(0x10); err == nil {
+		t.Error("got nil, want an error")
+	}
+	if usb.writes != 2 {
+		t.Errorf("got %d reads, want 2", usb.writes)
+	}
+
+	usb.writes = 0
+	if _, err := stm.readToken(); err == nil {
+		t.Error("got nil, want an error")
+	}
+	if usb.writes != 2 {
+		t.Errorf("got %d reads, want 2", usb.writes)
+	}
+}
+
+func TestStm32f0_ReplayOptimised(t *testing.T) {
+	c, err := NewClient(VendorDatelElextronicsLtd, ProductPowerSavesForAmiibo, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if err := c.Configure(Optimised(true)); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	r := NewReplay(loadRecording(t, "stm32f0_optimised.rec"))
+	if err := c.Replay(r); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	t.Cleanup(func() {
+		go func() {
+			for range c.Events() {
+			}
+		}()
+		c.Disconnect()
+	})
+
+	replayDone(t, r)
+}
@@ -0,0 +1,474 @@
+package nfcptl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// init MUST be used in drivers to register the driver by calling RegisterDriver. If the driver is
+// not registered, it will not be recognised!
+func init() {
+	RegisterDriver(&Virtual{})
+}
+
+const (
+	// virtualTokenSize is the size of the NTAG215 memory the Virtual driver keeps in memory.
+	virtualTokenSize = 540
+	// virtualPollInterval is the interval at which the Virtual driver checks for commands, token
+	// changes and the watched directory.
+	virtualPollInterval = 50 * time.Millisecond
+	// virtualDeviceName is returned in reply to the GetDeviceName command.
+	virtualDeviceName = "NFC-Emulator"
+)
+
+// ErrInvalidTokenSize is returned by Virtual.PlaceToken when the token data is too short to hold
+// an NTAG215 memory dump.
+var ErrInvalidTokenSize = errors.New("virtual: token data must be at least 540 bytes")
+
+// Virtual implements the Driver interface for an emulated NFC portal without any USB or UART
+// communication. It keeps the NTAG215 memory of the token placed on the portal in memory and
+// publishes the same events a real portal does when a token is placed, removed or written to. This
+// allows developing and testing clients without access to the hardware.
+// The token is read and written using the same procedures the drivers of real portals use. It
+// behaves like an NTAG215: the UID pages are read-only, the lock bytes are honored, pages
+// protected by the password require authentication and PWD and PACK read as zero.
+// A token can be placed or removed by calling PlaceToken and RemoveToken. Alternatively, a
+// directory can be watched using WatchDir: a token file placed in that directory will be placed on
+// the portal.
+// The Virtual driver of a Client can be obtained by a type assertion on Client.Driver().
+type Virtual struct {
+	mu      sync.Mutex
+	token   []byte // The NTAG215 memory of the token on the portal, nil when there is no token.
+	file    string // The file the token was read from when placed through the watched directory.
+	gen     int    // Incremented each time the token is placed or removed.
+	dir     string // The directory to watch for token files.
+	dirFile string // The token file last found in the watched directory.
+	auth    bool   // Set when the password of the token has been authenticated.
+	dirty   bool   // Set when the token has been written to since it was last saved to its file.
+
+	seen   int  // The token generation the driver published events for.
+	placed bool // Whether the driver has published a TokenDetected event.
+
+	c *Client
+}
+
+// Supports implements the virtual NFC emulator which is not an actual USB device.
+func (v *Virtual) Supports() []Vendor {
+	return []Vendor{
+		{
+			ID:    VIDVirtual,
+			Alias: VendorVirtual,
+			Products: []Product{
+				{
+					ID:    PIDEmulator,
+					Alias: ProductEmulator,
+				},
+			},
+		},
+	}
+}
+
+func (v *Virtual) VendorId(alias string) (uint16, error) {
+	for _, vnd := range v.Supports() {
+		if vnd.Alias == alias {
+			return vnd.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("virtual: unknown vendor %s", alias)
+}
+
+func (v *Virtual) ProductId(alias string) (uint16, error) {
+	for _, vnd := range v.Supports() {
+		for _, pr := range vnd.Products {
+			if pr.Alias == alias {
+				return pr.ID, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("virtual: unknown product %s", alias)
+}
+
+// Setup returns nil since the Virtual driver does not use a Protocol.
+func (v *Virtual) Setup() any {
+	return nil
+}
+
+func (v *Virtual) New() Driver {
+	return &Virtual{}
+}
+
+// Connect does not connect to anything, there is no device to connect to.
+func (v *Virtual) Connect(c *Client) error {
+	v.c = c
+	return nil
+}
+
+// Disconnect does not disconnect from anything, there is no device to disconnect from.
+func (v *Virtual) Disconnect() error {
+	return nil
+}
+
+func (v *Virtual) Drive(c *Client) {
+	v.c = c
+	if v.c.Debug() {
+		log.Println("virtual: driving")
+	}
+
+	v.commandListener()
+}
+
+// PlaceToken places a token holding the given NTAG215 memory dump on the portal. Only the first
+// 540 bytes of data are used. Any token that is already present will be removed first.
+// PlaceToken is thread safe.
+func (v *Virtual) PlaceToken(data []byte) error {
+	if len(data) < virtualTokenSize {
+		return ErrInvalidTokenSize
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.place(data, "")
+
+	return nil
+}
+
+// RemoveToken removes the token from the portal. It is a no-op when there is no token.
+// RemoveToken is thread safe.
+func (v *Virtual) RemoveToken() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.remove()
+}
+
+// Token returns a copy of the NTAG215 memory of the token placed on the portal or nil when there
+// is no token.
+// Token is thread safe.
+func (v *Virtual) Token() []byte {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.token == nil {
+		return nil
+	}
+
+	return append([]byte{}, v.token...)
+}
+
+// WatchDir makes the driver watch the given directory for token files. The first file in lexical
+// order holding at least 540 bytes is placed on the portal. Removing the file from the directory
+// removes the token from the portal. Data written to the token is written back to the file.
+// Passing an empty string stops watching. WatchDir is thread safe.
+func (v *Virtual) WatchDir(dir string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.dir = dir
+}
+
+// place replaces the token on the portal. The caller MUST hold the lock.
+func (v *Virtual) place(data []byte, file string) {
+	v.token = make([]byte, virtualTokenSize)
+	copy(v.token, data)
+	v.file = file
+	v.auth, v.dirty = false, false
+	v.gen++
+}
+
+// remove removes the token from the portal. The caller MUST hold the lock.
+func (v *Virtual) remove() {
+	if v.token == nil {
+		return
+	}
+
+	v.token = nil
+	v.file = ""
+	v.auth, v.dirty = false, false
+	v.gen++
+}
+
+// commandListener listens for commands sent by the Client. If no commands are received it will
+// check if the token on the portal has changed.
+func (v *Virtual) commandListener() {
+	ticker := time.NewTicker(virtualPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case cmd := <-v.c.Commands():
+				v.handleCommand(cmd)
+			default:
+				v.scanDir()
+				v.pollForToken()
+			}
+		case <-v.c.Terminate():
+			// Signal the client we're done with this goroutine informing it that it's safe to
+			// disconnect.
+			v.c.Done()
+			return
+		}
+	}
+}
+
+// handleCommand executes the given client command.
+func (v *Virtual) handleCommand(cmd Command) {
+	defer v.c.Executing(cmd)()
+
+	if cmd.Context().Err() != nil {
+		v.c.PublishEvent(NewEvent(CommandCancelled, []byte{byte(cmd.Command)}))
+		return
+	}
+
+	switch cmd.Command {
+	case GetDeviceName:
+		v.c.PublishEvent(NewEvent(DeviceName, []byte(virtualDeviceName)))
+	case SetLedState:
+		if len(cmd.Arguments) > 0 && cmd.Arguments[0] == 0x00 {
+			v.c.PublishEvent(NewEvent(FrontLedOff, nil))
+		} else {
+			v.c.PublishEvent(NewEvent(FrontLedOn, nil))
+		}
+	case FetchTokenData:
+		fetchNTAG215(v.c, v, "virtual")
+	case ReadPages, WritePage, PwdAuth:
+		handlePageCommand(v.c, v, "virtual", v.Token() != nil, cmd)
+		v.save()
+	case WriteTokenData:
+		if len(cmd.Arguments) == 0 {
+			log.Println("virtual: no data to write")
+			v.c.PublishEvent(NewEvent(TokenTagWriteError, nil))
+		} else {
+			writeNTAG215(v.c, v, "virtual", v.Token() != nil, cmd.Arguments[1:], cmd.Arguments[0])
+			v.save()
+		}
+	default:
+		v.c.PublishEvent(NewEvent(UnknownCommand, []byte{}))
+	}
+}
+
+// scanDir places the token found in the watched directory on the portal or removes the token when
+// its file has disappeared. It only acts on changes in the watched directory, so a token placed
+// through PlaceToken will not be replaced until the token file in the directory changes.
+func (v *Virtual) scanDir() {
+	v.mu.Lock()
+	dir := v.dir
+	v.mu.Unlock()
+
+	if dir == "" {
+		return
+	}
+
+	found, data := findTokenFile(dir)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if found == v.dirFile {
+		return
+	}
+	v.dirFile = found
+
+	switch {
+	case found == "" && v.file != "":
+		v.remove()
+	case found != "":
+		if v.c.Debug() {
+			log.Printf("virtual: placing token from %s", found)
+		}
+		v.place(data, found)
+	}
+}
+
+// findTokenFile returns the path and contents of the first file in dir holding an NTAG215 memory
+// dump.
+func findTokenFile(dir string) (string, []byte) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil || len(data) < virtualTokenSize {
+			continue
+		}
+		return path, data
+	}
+
+	return "", nil
+}
+
+// pollForToken publishes the events for the token that has been placed on or removed from the
+// portal since the previous poll.
+func (v *Virtual) pollForToken() {
+	v.mu.Lock()
+	gen := v.gen
+	var token []byte
+	if v.token != nil {
+		token = append([]byte{}, v.token...)
+	}
+	v.mu.Unlock()
+
+	if gen == v.seen {
+		return
+	}
+	v.seen = gen
+
+	if v.placed {
+		v.placed = false
+		v.c.PublishEvent(NewEvent(FrontLedOff, nil))
+		v.c.PublishEvent(NewEvent(TokenRemoved, nil))
+	}
+
+	if token != nil {
+		v.placed = true
+		v.c.PublishEvent(NewEvent(TokenDetected, virtualUid(token)))
+		v.c.PublishEvent(NewEvent(FrontLedOn, nil))
+		fetchNTAG215(v.c, v, "virtual")
+	}
+}
+
+// virtualUid returns the seven byte UID from the given NTAG215 memory, skipping the check byte
+// BCC0.
+func virtualUid(token []byte) []byte {
+	return append(append([]byte{}, token[0:3]...), token[4:8]...)
+}
+
+// readPages implements the ntagDevice interface returning the four pages of the emulated token
+// starting from the given page. Just like on an NTAG215, the read rolls over to page 0x00 after the
+// last page and PWD and PACK read as zero. When PROT is set in the ACCESS byte, the pages protected
+// by the password can only be read after authenticating.
+func (v *Virtual) readPages(page byte) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	pages := virtualTokenSize / 4
+	if v.token == nil {
+		return nil, errNTAGNoToken
+	}
+	if int(page) >= pages {
+		return nil, fmt.Errorf("virtual: page %#02x does not exist", page)
+	}
+	if v.protected(page, true) {
+		return nil, fmt.Errorf("virtual: page %#02x is password protected", page)
+	}
+
+	res := make([]byte, 16)
+	for i := 0; i < 4; i++ {
+		if p := (int(page) + i) % pages; p < 0x85 {
+			copy(res[i*4:], v.token[p*4:p*4+4])
+		}
+	}
+	return res, nil
+}
+
+// writePage implements the ntagDevice interface writing four bytes of data to the given page of the
+// emulated token. Just like on an NTAG215, the UID pages are read-only, the pages locked according
+// to ntag215LockedPages cannot be written, the pages protected by the password can only be written
+// after authenticating and the bits of the lock bytes and the capability container can only be
+// set. Rewriting a read-only or locked page with the data it already holds succeeds, which is what
+// the lock check of writeNTAG215 relies on.
+func (v *Virtual) writePage(page byte, data []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	p := int(page)
+	switch {
+	case v.token == nil:
+		return errNTAGNoToken
+	case p >= virtualTokenSize/4 || len(data) != 4:
+		return fmt.Errorf("virtual: page %#02x does not exist", page)
+	case bytes.Equal(v.token[p*4:p*4+4], data):
+		return nil
+	case p <= 0x01 || ntag215LockedPages(v.token)[p]:
+		return fmt.Errorf("virtual: page %#02x is locked", page)
+	case v.protected(page, false):
+		return fmt.Errorf("virtual: page %#02x is password protected", page)
+	}
+
+	mem := v.token[p*4 : p*4+4]
+	switch p {
+	case 0x02:
+		// BCC1 and the internal byte are read-only, the static lock bits can only be set.
+		mem[2] |= data[2]
+		mem[3] |= data[3]
+	case 0x03:
+		// The capability container is one time programmable.
+		for i := range mem {
+			mem[i] |= data[i]
+		}
+	case 0x82:
+		// The dynamic lock bits can only be set, the last byte is reserved.
+		for i := 0; i < 3; i++ {
+			mem[i] |= data[i]
+		}
+	default:
+		copy(mem, data)
+	}
+	v.dirty = true
+
+	return nil
+}
+
+// pwdAuth implements the ntagDevice interface comparing the given password to PWD in page 0x85 of
+// the emulated token. It returns PACK from page 0x86 when they match, after which the pages
+// protected by the password can be accessed until the token is removed.
+func (v *Virtual) pwdAuth(pwd []byte) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.token == nil {
+		return nil, errNTAGNoToken
+	}
+	v.auth = bytes.Equal(pwd, v.token[532:536])
+	if !v.auth {
+		return nil, errors.New("virtual: password mismatch")
+	}
+	return append([]byte{}, v.token[536:538]...), nil
+}
+
+// protected returns true when accessing the given page of the emulated token requires
+// authentication. AUTH0, the last byte of page 0x83, holds the first protected page and PROT, the
+// highest bit of the ACCESS byte in page 0x84, tells whether reads are protected as well. The
+// caller MUST hold the lock.
+func (v *Virtual) protected(page byte, read bool) bool {
+	if v.auth || page < v.token[0x83*4+3] {
+		return false
+	}
+	return !read || v.token[0x84*4]&0x80 != 0
+}
+
+// save writes the emulated token to its file when it was placed through the watched directory and
+// it has been written to.
+func (v *Virtual) save() {
+	v.mu.Lock()
+	if !v.dirty || v.file == "" {
+		v.mu.Unlock()
+		return
+	}
+	token := append([]byte{}, v.token...)
+	file := v.file
+	v.dirty = false
+	v.mu.Unlock()
+
+	if err := os.WriteFile(file, token, 0644); err != nil {
+		log.Printf("virtual: %s", err)
+	}
+}
@@ -0,0 +1,384 @@
+package nfcptl
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newVirtualClient returns a connected Client using the Virtual driver.
+func newVirtualClient(t *testing.T) (*Client, *Virtual) {
+	c, err := NewClient(VendorVirtual, ProductEmulator, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	t.Cleanup(func() {
+		go func() {
+			for range c.Events() {
+			}
+		}()
+		c.Disconnect()
+	})
+
+	return c, c.Driver().(*Virtual)
+}
+
+// expectEvents reads events from the client and fails when they do not match the given types.
+func expectEvents(t *testing.T, c *Client, want ...EventType) []*Event {
+	t.Helper()
+
+	var got []*Event
+	for _, typ := range want {
+		select {
+		case e := <-c.Events():
+			if e.Name() != typ {
+				t.Fatalf("got %s, want %s", e.Name(), typ)
+			}
+			got = append(got, e)
+		case <-time.After(time.Second):
+			t.Fatalf("got no event, want %s", typ)
+		}
+	}
+
+	return got
+}
+
+// expectWriteProgress expects a TokenTagWriteProgress event for each of the given pages, in order,
+// written without retries.
+func expectWriteProgress(t *testing.T, c *Client, pages []int) {
+	t.Helper()
+
+	for n, page := range pages {
+		e := expectEvents(t, c, TokenTagWriteProgress)[0]
+		if want := []byte{byte(page), byte(n + 1), byte(len(pages)), 0x00}; !bytes.Equal(e.Data(), want) {
+			t.Fatalf("got %x, want %x", e.Data(), want)
+		}
+	}
+}
+
+// testToken returns an NTAG215 dump where each byte holds the lower byte of its index. It has pages
+// 0x03, 0x08, 0x09, 0x0b and 0x40 up to 0x4f locked and is password protected from page 0x0f
+// onwards.
+func testToken() []byte {
+	token := make([]byte, 540)
+	for i := range token {
+		token[i] = byte(i)
+	}
+	return token
+}
+
+// unlockedTestToken returns the test token without locked pages and without password protection.
+func unlockedTestToken() []byte {
+	token := testToken()
+	token[10], token[11] = 0x00, 0x00 // The static lock bytes.
+	token[0x82*4] = 0x00              // The first dynamic lock byte.
+	token[0x83*4+3] = 0xff            // AUTH0 beyond the last page.
+	return token
+}
+
+// tokenRead returns the given NTAG215 dump as it is read from a token: with PWD and PACK being zero.
+func tokenRead(token []byte) []byte {
+	read := append([]byte{}, token...)
+	copy(read[0x85*4:], make([]byte, 8))
+	return read
+}
+
+func TestVirtual_PlaceToken(t *testing.T) {
+	c, v := newVirtualClient(t)
+
+	if err := v.PlaceToken(make([]byte, 520)); err != ErrInvalidTokenSize {
+		t.Errorf("got %v, want %s", err, ErrInvalidTokenSize)
+	}
+
+	token := testToken()
+	if err := v.PlaceToken(token); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	evs := expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagData)
+	if want := []byte{0x00, 0x01, 0x02, 0x04, 0x05, 0x06, 0x07}; !bytes.Equal(evs[0].Data(), want) {
+		t.Errorf("got %x, want %x", evs[0].Data(), want)
+	}
+	if want := tokenRead(token); !bytes.Equal(evs[2].Data(), want) {
+		t.Errorf("got %x, want %x", evs[2].Data(), want)
+	}
+
+	v.RemoveToken()
+	expectEvents(t, c, FrontLedOff, TokenRemoved)
+
+	if got := v.Token(); got != nil {
+		t.Errorf("got %x, want nil", got)
+	}
+}
+
+func TestVirtual_WriteTokenData(t *testing.T) {
+	c, v := newVirtualClient(t)
+
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteFull}, testToken()...)})
+	expectEvents(t, c, TokenTagWriteStart, TokenTagWriteError)
+
+	token := unlockedTestToken()
+	v.PlaceToken(token)
+	expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagData)
+
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: []byte{0x01, 0x02}})
+	expectEvents(t, c, TokenTagDataSizeError)
+
+	data := make([]byte, 540)
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteUserData}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, ntag215WriteOrder(true))
+	evs := expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+
+	want := unlockedTestToken()
+	copy(want[16:520], data[16:520])
+	if !bytes.Equal(evs[1].Data(), tokenRead(want)) {
+		t.Errorf("got %x, want %x", evs[1].Data(), tokenRead(want))
+	}
+
+	// The UID pages are read-only, writing page 0x01 fails after writing page 0x86.
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteFull}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	progress := [][]byte{{0x86, 0x01, 0x87, 0x00}}
+	for i := 0; i < ntagRetries; i++ {
+		progress = append(progress, []byte{0x01, 0x02, 0x87, byte(i)})
+	}
+	for _, want := range progress {
+		e := expectEvents(t, c, TokenTagWriteProgress)[0]
+		if !bytes.Equal(e.Data(), want) {
+			t.Errorf("got %x, want %x", e.Data(), want)
+		}
+	}
+	evs = expectEvents(t, c, TokenTagWriteError)
+	if want := []byte{0x01}; !bytes.Equal(evs[0].Data(), want) {
+		t.Errorf("got %x, want %x", evs[0].Data(), want)
+	}
+
+	data = unlockedTestToken()
+	copy(data[0x10*4:], []byte{0xde, 0xad, 0xbe, 0xef})
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteFull}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, ntag215WriteOrder(false))
+	evs = expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+	if !bytes.Equal(evs[1].Data(), tokenRead(data)) {
+		t.Errorf("got %x, want %x", evs[1].Data(), tokenRead(data))
+	}
+	if got := v.Token(); !bytes.Equal(got, data) {
+		t.Errorf("got %x, want %x", got, data)
+	}
+}
+
+func TestVirtual_WriteTokenDataLocked(t *testing.T) {
+	c, v := newVirtualClient(t)
+
+	token := testToken()
+	v.PlaceToken(token)
+	expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagData)
+
+	data := make([]byte, 540)
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteUserData}, data...)})
+	evs := expectEvents(t, c, TokenTagWriteStart, TokenTagWriteError)
+	if evs[1].Err() == nil {
+		t.Error("got nil, want the password authentication error")
+	}
+
+	copy(data[532:], token[532:])
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteUserData}, data...)})
+	evs = expectEvents(t, c, TokenTagWriteStart, TokenTagWriteLocked)
+	want := []byte{0x08, 0x09, 0x0b}
+	for page := byte(0x40); page <= 0x4f; page++ {
+		want = append(want, page)
+	}
+	if !bytes.Equal(evs[1].Data(), want) {
+		t.Errorf("got %#02x, want %#02x", evs[1].Data(), want)
+	}
+	if got := v.Token(); !bytes.Equal(got, token) {
+		t.Errorf("got %x, want %x", got, token)
+	}
+}
+
+func TestVirtual_WriteTokenDataVerify(t *testing.T) {
+	c, v := newVirtualClient(t)
+
+	v.PlaceToken(unlockedTestToken())
+	expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagData)
+
+	// The capability container is one time programmable, so its bits cannot be cleared. PWD and
+	// PACK read as zero, so a smart write always writes them.
+	data := unlockedTestToken()
+	copy(data[0x03*4:], make([]byte, 4))
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteSmart | WriteVerify}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, []int{0x86, 0x03, 0x85})
+	evs := expectEvents(t, c, TokenTagVerifyError, TokenTagData)
+	if want := []byte{0x03}; !bytes.Equal(evs[0].Data(), want) {
+		t.Errorf("got %#02x, want %#02x", evs[0].Data(), want)
+	}
+	if want := unlockedTestToken(); !bytes.Equal(v.Token(), want) {
+		t.Errorf("got %x, want %x", v.Token(), want)
+	}
+}
+
+func TestVirtual_PageCommands(t *testing.T) {
+	c, v := newVirtualClient(t)
+
+	c.SendCommand(Command{Command: ReadPages, Arguments: []byte{0x00, 0x01}})
+	evs := expectEvents(t, c, TokenTagPageError)
+	if err := evs[0].Err(); err != errNTAGNoToken {
+		t.Errorf("got %v, want %s", err, errNTAGNoToken)
+	}
+
+	token := testToken()
+	v.PlaceToken(token)
+	expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagData)
+
+	// PWD and PACK read as zero and the read rolls over to page 0x00 after the last page.
+	c.SendCommand(Command{Command: ReadPages, Arguments: []byte{0x85, 0x04}})
+	evs = expectEvents(t, c, TokenTagPages)
+	if want := append(make([]byte, 9), token[:8]...); !bytes.Equal(evs[0].Data()[1:], want[1:]) {
+		t.Errorf("got %x, want %x", evs[0].Data()[1:], want[1:])
+	}
+
+	// Page 0x10 is password protected.
+	data := []byte{0xde, 0xad, 0xbe, 0xef}
+	c.SendCommand(Command{Command: WritePage, Arguments: append([]byte{0x10}, data...)})
+	expectEvents(t, c, TokenTagPageError)
+
+	c.SendCommand(Command{Command: PwdAuth, Arguments: token[532:536]})
+	evs = expectEvents(t, c, TokenTagAuthenticated)
+	if !bytes.Equal(evs[0].Data(), token[536:538]) {
+		t.Errorf("got %x, want %x", evs[0].Data(), token[536:538])
+	}
+
+	c.SendCommand(Command{Command: WritePage, Arguments: append([]byte{0x10}, data...)})
+	evs = expectEvents(t, c, TokenTagPageWritten)
+	if want := []byte{0x10}; !bytes.Equal(evs[0].Data(), want) {
+		t.Errorf("got %x, want %x", evs[0].Data(), want)
+	}
+	if got := v.Token()[0x10*4 : 0x11*4]; !bytes.Equal(got, data) {
+		t.Errorf("got %x, want %x", got, data)
+	}
+
+	// Page 0x40 is locked.
+	c.SendCommand(Command{Command: WritePage, Arguments: append([]byte{0x40}, data...)})
+	expectEvents(t, c, TokenTagPageError)
+
+	c.SendCommand(Command{Command: PwdAuth, Arguments: data})
+	evs = expectEvents(t, c, TokenTagPageError)
+	if want := []byte{byte(PwdAuth)}; !bytes.Equal(evs[0].Data(), want) {
+		t.Errorf("got %x, want %x", evs[0].Data(), want)
+	}
+
+	for _, cmd := range []Command{
+		{Command: ReadPages, Arguments: []byte{0x00, 0x00}},
+		{Command: ReadPages, Arguments: []byte{0xff, 0x02}},
+		{Command: WritePage, Arguments: []byte{0x10}},
+		{Command: PwdAuth, Arguments: nil},
+	} {
+		c.SendCommand(cmd)
+		evs = expectEvents(t, c, TokenTagPageError)
+		if err := evs[0].Err(); err != errNTAGInvalidArgs {
+			t.Errorf("%s: got %v, want %s", cmd.Command, err, errNTAGInvalidArgs)
+		}
+	}
+}
+
+func TestVirtual_Commands(t *testing.T) {
+	c, _ := newVirtualClient(t)
+
+	c.SendCommand(Command{Command: FetchTokenData})
+	expectEvents(t, c, TokenTagDataError)
+
+	c.SendCommand(Command{Command: GetDeviceName})
+	evs := expectEvents(t, c, DeviceName)
+	if got, want := string(evs[0].Data()), virtualDeviceName; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	c.SendCommand(Command{Command: GetApiPassword})
+	expectEvents(t, c, UnknownCommand)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.SendCommand(Command{Command: GetDeviceName, ctx: ctx})
+	expectEvents(t, c, CommandCancelled)
+}
+
+func TestVirtual_EventCommand(t *testing.T) {
+	c, v := newVirtualClient(t)
+
+	start := time.Now()
+	v.PlaceToken(testToken())
+	for _, e := range expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagData) {
+		if cmd, ok := e.Command(); ok {
+			t.Errorf("%s: got command %s, want none", e, cmd)
+		}
+		if e.Time().Before(start) {
+			t.Errorf("%s: got time %s, want after %s", e, e.Time(), start)
+		}
+	}
+
+	c.SendCommand(Command{Command: FetchTokenData})
+	e := expectEvents(t, c, TokenTagData)[0]
+	if cmd, ok := e.Command(); !ok || cmd != FetchTokenData {
+		t.Errorf("got command %s and %v, want %s and true", cmd, ok, FetchTokenData)
+	}
+	if got, ok := e.Payload().(TokenDataEvent); !ok || !got.Complete || got.Command != FetchTokenData {
+		t.Errorf("got payload %+v, want a complete TokenDataEvent for %s", e.Payload(), FetchTokenData)
+	}
+
+	v.RemoveToken()
+	for _, e := range expectEvents(t, c, FrontLedOff, TokenRemoved) {
+		if cmd, ok := e.Command(); ok {
+			t.Errorf("%s: got command %s, want none", e, cmd)
+		}
+	}
+}
+
+func TestVirtual_WatchDir(t *testing.T) {
+	c, v := newVirtualClient(t)
+
+	dir := t.TempDir()
+	v.WatchDir(dir)
+
+	// Files that are too small are ignored.
+	os.WriteFile(filepath.Join(dir, "a.bin"), []byte{0x01}, 0644)
+
+	file := filepath.Join(dir, "b.bin")
+	if err := os.WriteFile(file, unlockedTestToken(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, c, TokenDetected, FrontLedOn, TokenTagData)
+
+	data := make([]byte, 540)
+	c.SendCommand(Command{Command: WriteTokenData, Arguments: append([]byte{WriteUserData}, data...)})
+	expectEvents(t, c, TokenTagWriteStart)
+	expectWriteProgress(t, c, ntag215WriteOrder(true))
+	expectEvents(t, c, TokenTagWriteFinish, TokenTagData)
+
+	want := unlockedTestToken()
+	copy(want[16:520], data[16:520])
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	os.Remove(file)
+	expectEvents(t, c, FrontLedOff, TokenRemoved)
+}
+
+func TestVirtual_NotConfigurable(t *testing.T) {
+	c, _ := newVirtualClient(t)
+
+	if err := c.Configure(Optimised(true)); err != ErrNotConfigurable {
+		t.Errorf("got %v, want %s", err, ErrNotConfigurable)
+	}
+}
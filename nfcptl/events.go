@@ -1,5 +1,7 @@
 package nfcptl
 
+import "time"
+
 type EventType string
 
 const (
@@ -32,6 +34,13 @@ const (
 	// TokenTagData is sent when the driver has read the full token tag data which will be present
 	// in the event data.
 	TokenTagData EventType = "TokenTagData"
+	// TokenTagVersion is sent when the driver has read the eight byte GET_VERSION response of the
+	// token identifying the chip.
+	TokenTagVersion EventType = "TokenTagVersion"
+	// TokenTagSignature is sent when the driver has read the 32 byte ECC originality signature of
+	// the token. It can be verified against the token UID using amiibo.VerifySignature to tell
+	// genuine NXP tags from clones.
+	TokenTagSignature EventType = "TokenTagSignature"
 	// TokenTagDataError is sent when the driver encountered an error reading the token tag data.
 	// The token tag data that has been read will be present in the event data but will be
 	// incomplete or corrupted.
@@ -41,29 +50,108 @@ const (
 	TokenTagDataSizeError EventType = "TokenTagDataSizeError"
 	// TokenTagWriteStart is sent right before the driver starts the writing procedure.
 	TokenTagWriteStart EventType = "TokenTagWriteStart"
-	// TokenTagWriteFinish is sent when the driver successfully finishes the write sequence.
+	// TokenTagWriteProgress is sent right before the driver attempts to write a page of the token,
+	// or a block for MIFARE Classic tokens. The event data holds four bytes: the page being
+	// written, the one based position of that page in the write procedure, the total number of
+	// pages to write and the number of retries for that page so far.
+	TokenTagWriteProgress EventType = "TokenTagWriteProgress"
+	// TokenTagWriteFinish is sent when the driver successfully finishes the write sequence. When
+	// the write is verified, it is only sent after the token data read back has been verified.
 	TokenTagWriteFinish EventType = "TokenTagWriteFinish"
 	// TokenTagWriteError is sent when the driver received an error after two consecutive write
 	// failures of the same page.
 	TokenTagWriteError EventType = "TokenTagWriteError"
+	// TokenTagWriteLocked is sent after TokenTagWriteStart when the driver refuses to write the
+	// token because pages that are locked on the token would have to change. The event data holds
+	// those pages. Nothing has been written to the token.
+	TokenTagWriteLocked EventType = "TokenTagWriteLocked"
+	// TokenTagVerifyError is sent instead of TokenTagWriteFinish when the token data read after a
+	// write using the WriteVerify mode differs from the data that should have been written. The
+	// event data holds the numbers of the pages that differ. PWD and PACK are not verified since
+	// they cannot be read.
+	TokenTagVerifyError EventType = "TokenTagVerifyError"
+	// BankList is sent in reply to the ListBanks command. The first byte of the event data holds
+	// the zero based number of the active bank, the second byte holds the number of banks. They
+	// are followed by the eight byte character ID of each bank.
+	BankList EventType = "BankList"
+	// ActiveBankSet is sent when the active bank has been changed. The event data holds the zero
+	// based number of the active bank.
+	ActiveBankSet EventType = "ActiveBankSet"
+	// BankCountSet is sent when the number of banks has been changed. The event data holds the new
+	// number of banks.
+	BankCountSet EventType = "BankCountSet"
+	// BankData is sent in reply to the ReadBank command. The event data holds all 572 bytes of the
+	// bank.
+	BankData EventType = "BankData"
+	// BankErased is sent when a bank has been erased. The event data holds the zero based number of
+	// the erased bank.
+	BankErased EventType = "BankErased"
+	// BankError is sent when a bank command failed. The event data holds the ClientCommand that
+	// failed.
+	BankError EventType = "BankError"
+	// TokenTagPages is sent in reply to the ReadPages command. The event data holds the first page
+	// that has been read followed by the data of the pages.
+	TokenTagPages EventType = "TokenTagPages"
+	// TokenTagPageWritten is sent in reply to the WritePage command. The event data holds the page
+	// that has been written.
+	TokenTagPageWritten EventType = "TokenTagPageWritten"
+	// TokenTagAuthenticated is sent in reply to the PwdAuth command. The event data holds the two
+	// byte password acknowledge (PACK) returned by the token.
+	TokenTagAuthenticated EventType = "TokenTagAuthenticated"
+	// TokenTagPageError is sent when a ReadPages, WritePage or PwdAuth command failed. The event
+	// data holds the ClientCommand that failed.
+	TokenTagPageError EventType = "TokenTagPageError"
+	// DfuMode is sent when the device has been put in device firmware update mode by the EnterDfu
+	// command. The device detaches right away, so the client sends DeviceLeft and disconnects.
+	DfuMode EventType = "DfuMode"
 	// UnknownCommand is sent when the driver has received an unknown command.
 	UnknownCommand EventType = "UnknownCommand"
-	// Disconnect is sent when the Client.Disconnect method is called.
+	// CommandCancelled is sent when the context of a command was done before the driver could
+	// execute it. The event data will hold the ClientCommand that has been cancelled.
+	CommandCancelled EventType = "CommandCancelled"
+	// Disconnect is sent when the Client.Disconnect method is called or when the context passed to
+	// Client.ConnectContext is done.
 	Disconnect EventType = "Disconnect"
+	// DeviceArrived is sent by Watch when a supported device has been attached. The event data
+	// describes the device, use DeviceInfoFromEvent to decode it.
+	DeviceArrived EventType = "DeviceArrived"
+	// DeviceLeft is sent by Watch when a supported device has been detached. A connected client
+	// sends it as well when its device is gone, right before the Disconnect event. The event data
+	// describes the device, use DeviceInfoFromEvent to decode it.
+	DeviceLeft EventType = "DeviceLeft"
 )
 
+// Event is published by the driver on the events channel of the Client. Besides its type and raw
+// data, it holds the time it was created and the ClientCommand that caused it, if any. Use Payload
+// to get the event data decoded into one of the typed event structs.
 type Event struct {
 	name EventType
 	data []byte
+	err  error     // The error that caused the event, if known.
+	time time.Time // The time the event was created.
+
+	cmd    ClientCommand // The command the driver was executing when the event was published.
+	hasCmd bool          // Set when cmd holds the originating command.
+	req    uint64        // The request the originating command was sent for, 0 when unknown.
 }
 
+// NewEvent creates a new event of the given type holding the given data.
 func NewEvent(name EventType, data []byte) *Event {
 	return &Event{
 		name: name,
 		data: data,
+		time: time.Now(),
 	}
 }
 
+// NewErrorEvent creates a new event of the given type holding the given data and the error that
+// caused the event.
+func NewErrorEvent(name EventType, data []byte, err error) *Event {
+	e := NewEvent(name, data)
+	e.err = err
+	return e
+}
+
 func (e *Event) String() string {
 	return string(e.name)
 }
@@ -75,3 +163,33 @@ func (e *Event) Name() EventType {
 func (e *Event) Data() []byte {
 	return e.data
 }
+
+// Err returns the error that caused the event. It returns nil when there is none or when the driver
+// did not pass it along.
+func (e *Event) Err() error {
+	return e.err
+}
+
+// Time returns the time the event was created.
+func (e *Event) Time() time.Time {
+	return e.time
+}
+
+// Command returns the ClientCommand the driver was executing when it published the event. False is
+// returned for events that were not caused by a command, such as the TokenDetected event that is
+// published when the driver detects a token by itself.
+func (e *Event) Command() (ClientCommand, bool) {
+	return e.cmd, e.hasCmd
+}
+
+// setCommand sets the originating command of the event.
+func (e *Event) setCommand(cmd ClientCommand) {
+	e.cmd = cmd
+	e.hasCmd = true
+}
+
+// setRequest sets the originating command of the event including the request it was sent for.
+func (e *Event) setRequest(cmd Command) {
+	e.setCommand(cmd.Command)
+	e.req = cmd.req
+}
@@ -0,0 +1,243 @@
+package nfcptl
+
+import (
+	"strings"
+	"time"
+)
+
+// EventInfo holds the fields every typed event has in common. It is the payload of the events
+// that do not carry any data, such as TokenRemoved or TokenTagWriteFinish, and it is embedded in
+// all other typed events.
+type EventInfo struct {
+	Type       EventType
+	Time       time.Time     // The time the event was created.
+	Command    ClientCommand // The originating command, only valid when HasCommand is true.
+	HasCommand bool
+}
+
+// ApiPasswordEvent is the payload of the ApiPassword event.
+type ApiPasswordEvent struct {
+	EventInfo
+	Password []byte
+}
+
+// DeviceNameEvent is the payload of the DeviceName event.
+type DeviceNameEvent struct {
+	EventInfo
+	Name string
+}
+
+// HardwareInfoEvent is the payload of the HardwareInfo event. The format of the hardware info is
+// device specific, use ParseSTM32F0HardwareInfo for STM32F0 based devices.
+type HardwareInfoEvent struct {
+	EventInfo
+	Raw []byte
+}
+
+// ErrorEvent is the payload of the Error event. Err is nil when the driver did not pass along the
+// error.
+type ErrorEvent struct {
+	EventInfo
+	Err error
+}
+
+// TokenDetectedEvent is the payload of the TokenDetected event.
+type TokenDetectedEvent struct {
+	EventInfo
+	UID []byte
+}
+
+// TokenDataEvent is the payload of the TokenTagData and TokenTagDataError events. When Complete is
+// false, Data holds the token data that has been read before the error occurred, if any.
+type TokenDataEvent struct {
+	EventInfo
+	Data     []byte
+	Complete bool
+	Err      error
+}
+
+// TokenVersionEvent is the payload of the TokenTagVersion event.
+type TokenVersionEvent struct {
+	EventInfo
+	Version []byte
+}
+
+// TokenSignatureEvent is the payload of the TokenTagSignature event.
+type TokenSignatureEvent struct {
+	EventInfo
+	Signature []byte
+}
+
+// DataSizeErrorEvent is the payload of the TokenTagDataSizeError event holding the data that was
+// passed to write.
+type DataSizeErrorEvent struct {
+	EventInfo
+	Data []byte
+}
+
+// WriteProgressEvent is the payload of the TokenTagWriteProgress event.
+type WriteProgressEvent struct {
+	EventInfo
+	Page     int // The page being written, or the block for MIFARE Classic tokens.
+	Position int // The one based position of Page in the write procedure.
+	Total    int // The total number of pages to write.
+	Retries  int // The number of retries for Page so far.
+}
+
+// WriteErrorEvent is the payload of the TokenTagWriteError event. Page is -1 when the write did
+// not fail on a specific page, Err is nil when the driver did not pass along the error.
+type WriteErrorEvent struct {
+	EventInfo
+	Page int
+	Err  error
+}
+
+// PagesEvent is the payload of the TokenTagWriteLocked and TokenTagVerifyError events holding the
+// pages that are locked or that differ.
+type PagesEvent struct {
+	EventInfo
+	Pages []int
+}
+
+// BankListEvent is the payload of the BankList event.
+type BankListEvent struct {
+	EventInfo
+	Active int      // The zero based number of the active bank.
+	Count  int      // The number of banks.
+	Banks  [][]byte // The eight byte character ID of each bank.
+}
+
+// BankEvent is the payload of the ActiveBankSet, BankCountSet and BankErased events. For
+// BankCountSet, Bank holds the number of banks.
+type BankEvent struct {
+	EventInfo
+	Bank int
+}
+
+// BankDataEvent is the payload of the BankData event.
+type BankDataEvent struct {
+	EventInfo
+	Data []byte
+}
+
+// TokenPagesEvent is the payload of the TokenTagPages event.
+type TokenPagesEvent struct {
+	EventInfo
+	Page int    // The first page that has been read.
+	Data []byte // The data of the pages, four bytes per page.
+}
+
+// PageEvent is the payload of the TokenTagPageWritten event.
+type PageEvent struct {
+	EventInfo
+	Page int
+}
+
+// PwdAuthEvent is the payload of the TokenTagAuthenticated event.
+type PwdAuthEvent struct {
+	EventInfo
+	Pack []byte
+}
+
+// PageErrorEvent is the payload of the TokenTagPageError event holding the command that failed.
+type PageErrorEvent struct {
+	EventInfo
+	Failed ClientCommand
+	Err    error
+}
+
+// CommandEvent is the payload of the BankError and CommandCancelled events holding the command
+// that failed or that has been cancelled.
+type CommandEvent struct {
+	EventInfo
+	Failed ClientCommand
+}
+
+// DeviceEvent is the payload of the DeviceArrived and DeviceLeft events.
+type DeviceEvent struct {
+	EventInfo
+	Device DeviceInfo
+}
+
+// Payload returns the event decoded into its typed event struct, e.g. TokenDetectedEvent for the
+// TokenDetected event. Events that do not carry any data, or that carry data that cannot be
+// decoded, return their EventInfo. Byte slices in the payload share their memory with Data.
+func (e *Event) Payload() any {
+	info := EventInfo{Type: e.name, Time: e.time}
+	info.Command, info.HasCommand = e.Command()
+
+	switch e.name {
+	case ApiPassword:
+		return ApiPasswordEvent{info, e.data}
+	case DeviceName:
+		return DeviceNameEvent{info, strings.TrimRight(string(e.data), "\x00")}
+	case HardwareInfo:
+		return HardwareInfoEvent{info, e.data}
+	case Error:
+		return ErrorEvent{info, e.err}
+	case TokenDetected:
+		return TokenDetectedEvent{info, e.data}
+	case TokenTagData, TokenTagDataError:
+		return TokenDataEvent{info, e.data, e.name == TokenTagData, e.err}
+	case TokenTagVersion:
+		return TokenVersionEvent{info, e.data}
+	case TokenTagSignature:
+		return TokenSignatureEvent{info, e.data}
+	case TokenTagDataSizeError:
+		return DataSizeErrorEvent{info, e.data}
+	case TokenTagWriteProgress:
+		if len(e.data) == 4 {
+			return WriteProgressEvent{info, int(e.data[0]), int(e.data[1]), int(e.data[2]), int(e.data[3])}
+		}
+	case TokenTagWriteError:
+		page := -1
+		if len(e.data) == 1 {
+			page = int(e.data[0])
+		}
+		return WriteErrorEvent{info, page, e.err}
+	case TokenTagWriteLocked, TokenTagVerifyError:
+		pages := make([]int, len(e.data))
+		for i, p := range e.data {
+			pages[i] = int(p)
+		}
+		return PagesEvent{info, pages}
+	case BankList:
+		if len(e.data) >= 2 && len(e.data) == 2+int(e.data[1])*8 {
+			banks := make([][]byte, e.data[1])
+			for i := range banks {
+				banks[i] = e.data[2+i*8 : 2+i*8+8]
+			}
+			return BankListEvent{info, int(e.data[0]), int(e.data[1]), banks}
+		}
+	case ActiveBankSet, BankCountSet, BankErased:
+		if len(e.data) == 1 {
+			return BankEvent{info, int(e.data[0])}
+		}
+	case BankData:
+		return BankDataEvent{info, e.data}
+	case BankError, CommandCancelled:
+		if len(e.data) == 1 {
+			return CommandEvent{info, ClientCommand(e.data[0])}
+		}
+	case TokenTagPages:
+		if len(e.data) >= 1 {
+			return TokenPagesEvent{info, int(e.data[0]), e.data[1:]}
+		}
+	case TokenTagPageWritten:
+		if len(e.data) == 1 {
+			return PageEvent{info, int(e.data[0])}
+		}
+	case TokenTagAuthenticated:
+		return PwdAuthEvent{info, e.data}
+	case TokenTagPageError:
+		if len(e.data) == 1 {
+			return PageErrorEvent{info, ClientCommand(e.data[0]), e.err}
+		}
+	case DeviceArrived, DeviceLeft:
+		if di, ok := DeviceInfoFromEvent(e); ok {
+			return DeviceEvent{info, di}
+		}
+	}
+
+	return info
+}
@@ -1,6 +1,10 @@
 package nfcptl
 
-import "testing"
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
 
 func TestString(t *testing.T) {
 	list := map[EventType]string{
@@ -17,3 +21,54 @@ func TestString(t *testing.T) {
 		}
 	}
 }
+
+func TestPayload(t *testing.T) {
+	errTest := errors.New("test")
+
+	cancelled := NewEvent(CommandCancelled, []byte{byte(FetchTokenData)})
+	cancelled.setCommand(FetchTokenData)
+
+	list := []struct {
+		e    *Event
+		want any
+	}{
+		{NewEvent(TokenRemoved, nil), EventInfo{}},
+		{NewEvent(DeviceName, []byte("ACR122U214\x00\x00")), DeviceNameEvent{Name: "ACR122U214"}},
+		{NewErrorEvent(Error, nil, errTest), ErrorEvent{Err: errTest}},
+		{NewEvent(TokenDetected, []byte{0x04, 0x01}), TokenDetectedEvent{UID: []byte{0x04, 0x01}}},
+		{NewEvent(TokenTagData, []byte{0x04}), TokenDataEvent{Data: []byte{0x04}, Complete: true}},
+		{NewErrorEvent(TokenTagDataError, nil, errTest), TokenDataEvent{Err: errTest}},
+		{NewEvent(TokenTagWriteProgress, []byte{0x10, 0x02, 0x86, 0x01}), WriteProgressEvent{Page: 0x10, Position: 2, Total: 0x86, Retries: 1}},
+		{NewEvent(TokenTagWriteProgress, []byte{0x10}), EventInfo{}},
+		{NewErrorEvent(TokenTagWriteError, []byte{0x84}, errTest), WriteErrorEvent{Page: 0x84, Err: errTest}},
+		{NewEvent(TokenTagWriteError, nil), WriteErrorEvent{Page: -1}},
+		{NewEvent(TokenTagWriteLocked, []byte{0x08, 0x82}), PagesEvent{Pages: []int{0x08, 0x82}}},
+		{NewEvent(BankList, []byte{0x01, 0x02, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}), BankListEvent{Active: 1, Count: 2, Banks: [][]byte{{1, 2, 3, 4, 5, 6, 7, 8}, {9, 10, 11, 12, 13, 14, 15, 16}}}},
+		{NewEvent(BankList, []byte{0x01, 0x02, 1, 2}), EventInfo{}},
+		{NewEvent(BankErased, []byte{0x03}), BankEvent{Bank: 3}},
+		{cancelled, CommandEvent{Failed: FetchTokenData}},
+		{NewEvent(TokenTagPages, []byte{0x04, 1, 2, 3, 4}), TokenPagesEvent{Page: 4, Data: []byte{1, 2, 3, 4}}},
+		{NewEvent(TokenTagPageWritten, []byte{0x10}), PageEvent{Page: 0x10}},
+		{NewEvent(TokenTagAuthenticated, []byte{0x80, 0x80}), PwdAuthEvent{Pack: []byte{0x80, 0x80}}},
+		{NewErrorEvent(TokenTagPageError, []byte{byte(WritePage)}, errTest), PageErrorEvent{Failed: WritePage, Err: errTest}},
+		{NewEvent(DeviceLeft, []byte{0x1c, 0x1a, 0x03, 0xd9, 0x01, 0x07}), DeviceEvent{Device: DeviceInfo{Vendor: VendorDatelElextronicsLtd, Product: ProductPowerSavesForAmiibo, VendorId: VIDDatelElectronicsLtd, ProductId: PIDPowerSavesForAmiibo, Bus: 1, Address: 7}}},
+	}
+
+	for _, l := range list {
+		info := EventInfo{Type: l.e.Name(), Time: l.e.Time()}
+		info.Command, info.HasCommand = l.e.Command()
+
+		// Fill in the expected EventInfo of the typed event.
+		want := reflect.New(reflect.TypeOf(l.want)).Elem()
+		want.Set(reflect.ValueOf(l.want))
+		if f := want.FieldByName("EventInfo"); f.IsValid() {
+			f.Set(reflect.ValueOf(info))
+		} else {
+			want.Set(reflect.ValueOf(info))
+		}
+
+		if got := l.e.Payload(); !reflect.DeepEqual(got, want.Interface()) {
+			t.Errorf("%s: got %+v, want %+v", l.e, got, want.Interface())
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package nfcptl
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Watch sends a DeviceArrived event for each supported device that gets attached and a DeviceLeft
+// event for each one that gets detached until the given context is done, after which the returned
+// channel is closed. The devices that are attached when calling Watch are sent as DeviceArrived
+// events right away.
+// The hotplug notifications of the operating system are used when available. Since gousb does not
+// expose the hotplug callbacks of libusb, the USB uevents of the Linux kernel libusb relies on are
+// used directly. On other platforms, or when the notifications cannot be received, the attached
+// devices are listed every interval instead.
+func Watch(ctx context.Context, interval time.Duration) <-chan *Event {
+	return watch(ctx, interval, ListDevices, hotplugNotifications)
+}
+
+// watch implements Watch using list to get the attached devices and notify to subscribe to the
+// hotplug notifications. A value is received on the channel returned by notify each time a device
+// might have been attached or detached.
+func watch(ctx context.Context, interval time.Duration, list func() ([]DeviceInfo, error), notify func(ctx context.Context) (<-chan struct{}, error)) <-chan *Event {
+	events := make(chan *Event, 10)
+
+	go func() {
+		defer close(events)
+
+		var poll <-chan time.Time
+		changed, err := notify(ctx)
+		if err != nil {
+			log.Printf("nfcptl: polling for devices every %s: %v", interval, err)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			poll = ticker.C
+		}
+
+		attached := make(map[string]DeviceInfo)
+		for {
+			if devs, err := list(); err == nil {
+				if !publishDeviceChanges(ctx, events, attached, devs) {
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-poll:
+			case _, ok := <-changed:
+				if !ok {
+					log.Printf("nfcptl: hotplug notifications stopped, polling for devices every %s", interval)
+					changed = nil
+					ticker := time.NewTicker(interval)
+					defer ticker.Stop()
+					poll = ticker.C
+				}
+			}
+		}
+	}()
+
+	return events
+}
+
+// publishDeviceChanges compares the given attached devices to the devices in the attached map and
+// sends the DeviceArrived and DeviceLeft events accordingly, updating the map. It returns false
+// when the context is done before all events could be sent.
+func publishDeviceChanges(ctx context.Context, events chan<- *Event, attached map[string]DeviceInfo, devs []DeviceInfo) bool {
+	var changes []*Event
+	found := make(map[string]bool)
+	for _, di := range devs {
+		key := di.key()
+		found[key] = true
+		if _, ok := attached[key]; !ok {
+			attached[key] = di
+			changes = append(changes, NewEvent(DeviceArrived, di.eventData()))
+		}
+	}
+	for key, di := range attached {
+		if !found[key] {
+			delete(attached, key)
+			changes = append(changes, NewEvent(DeviceLeft, di.eventData()))
+		}
+	}
+
+	for _, e := range changes {
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return true
+}
+
+// key returns a key uniquely identifying an attached device.
+func (di DeviceInfo) key() string {
+	return fmt.Sprintf("%04x:%04x@%d/%d", di.VendorId, di.ProductId, di.Bus, di.Address)
+}
+
+// eventData returns the data of the DeviceArrived and DeviceLeft events describing the device: the
+// vendor and product ID in big endian byte order followed by the bus number and the address.
+func (di DeviceInfo) eventData() []byte {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data, di.VendorId)
+	binary.BigEndian.PutUint16(data[2:], di.ProductId)
+	data[4] = byte(di.Bus)
+	data[5] = byte(di.Address)
+	return data
+}
+
+// DeviceInfoFromEvent returns the device described by a DeviceArrived or DeviceLeft event. The
+// vendor and product alias are filled in as well. The serial number is never known. It returns
+// false when the event does not describe a device.
+func DeviceInfoFromEvent(e *Event) (DeviceInfo, bool) {
+	if (e.Name() != DeviceArrived && e.Name() != DeviceLeft) || len(e.Data()) != 6 {
+		return DeviceInfo{}, false
+	}
+
+	data := e.Data()
+	di := DeviceInfo{
+		VendorId:  binary.BigEndian.Uint16(data),
+		ProductId: binary.BigEndian.Uint16(data[2:]),
+		Bus:       int(data[4]),
+		Address:   int(data[5]),
+	}
+	di.Vendor, di.Product, _ = lookupAliases(di.VendorId, di.ProductId)
+
+	return di, true
+}
@@ -0,0 +1,71 @@
+package nfcptl
+
+import (
+	"bytes"
+	"context"
+	"syscall"
+)
+
+// hotplugNotifications subscribes to the uevents the Linux kernel sends when a device is added or
+// removed, just like libusb does. A value is sent on the returned channel each time a USB device
+// has been added or removed. The channel is closed when the context is done or when receiving
+// uevents fails.
+func hotplugNotifications(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+	// Group 1 receives the uevents straight from the kernel.
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// Time out receiving regularly so that the context is checked.
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &syscall.Timeval{Sec: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer close(changed)
+		defer syscall.Close(fd)
+
+		buf := make([]byte, 8192)
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if isUSBDeviceUevent(buf[:n]) {
+				select {
+				case changed <- struct{}{}:
+				default:
+					// A rescan is pending already.
+				}
+			}
+		}
+	}()
+
+	return changed, nil
+}
+
+// isUSBDeviceUevent returns true when the given uevent announces that a USB device has been added
+// or removed. A uevent starts with the action and the device path separated by an '@', followed by
+// null terminated KEY=value pairs.
+func isUSBDeviceUevent(msg []byte) bool {
+	fields := bytes.Split(msg, []byte{0x00})
+	if !bytes.HasPrefix(fields[0], []byte("add@")) && !bytes.HasPrefix(fields[0], []byte("remove@")) {
+		return false
+	}
+	for _, f := range fields[1:] {
+		if bytes.Equal(f, []byte("DEVTYPE=usb_device")) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,27 @@
+package nfcptl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsUSBDeviceUevent(t *testing.T) {
+	uevent := func(fields ...string) []byte { return []byte(strings.Join(fields, "\x00") + "\x00") }
+
+	tests := []struct {
+		msg  []byte
+		want bool
+	}{
+		{uevent("add@/devices/pci0000:00/usb1/1-4", "ACTION=add", "SUBSYSTEM=usb", "DEVTYPE=usb_device", "PRODUCT=1c1a/3d9/100"), true},
+		{uevent("remove@/devices/pci0000:00/usb1/1-4", "ACTION=remove", "SUBSYSTEM=usb", "DEVTYPE=usb_device"), true},
+		{uevent("add@/devices/pci0000:00/usb1/1-4/1-4:1.0", "ACTION=add", "SUBSYSTEM=usb", "DEVTYPE=usb_interface"), false},
+		{uevent("bind@/devices/pci0000:00/usb1/1-4", "ACTION=bind", "SUBSYSTEM=usb", "DEVTYPE=usb_device"), false},
+		{[]byte("libudev\x00"), false},
+	}
+
+	for _, test := range tests {
+		if got := isUSBDeviceUevent(test.msg); got != test.want {
+			t.Errorf("%q: got %t, want %t", test.msg, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package nfcptl
+
+import (
+	"context"
+	"errors"
+)
+
+// hotplugNotifications is only supported on Linux, Watch falls back to polling.
+func hotplugNotifications(ctx context.Context) (<-chan struct{}, error) {
+	return nil, errors.New("hotplug notifications are not supported on this platform")
+}
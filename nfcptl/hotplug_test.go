@@ -0,0 +1,103 @@
+package nfcptl
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDevices returns a list function returning the devices set using the returned set function.
+func fakeDevices() (func() ([]DeviceInfo, error), func(devs ...DeviceInfo)) {
+	var mu sync.Mutex
+	var attached []DeviceInfo
+
+	list := func() ([]DeviceInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return attached, nil
+	}
+	set := func(devs ...DeviceInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		attached = devs
+	}
+
+	return list, set
+}
+
+// expectDeviceEvent expects the next event on the given channel to be of the given type and to
+// describe the given device.
+func expectDeviceEvent(t *testing.T, events <-chan *Event, typ EventType, want DeviceInfo) {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		if e.Name() != typ {
+			t.Fatalf("got %s, want %s", e.Name(), typ)
+		}
+		if got, _ := DeviceInfoFromEvent(e); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("got no event, want %s", typ)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	portal := DeviceInfo{Vendor: VendorDatelElextronicsLtd, Product: ProductPowerSavesForAmiibo, VendorId: VIDDatelElectronicsLtd, ProductId: PIDPowerSavesForAmiibo, Bus: 1, Address: 4}
+	other := portal
+	other.Address = 5
+	hotplug := make(chan struct{})
+
+	tests := []struct {
+		name   string
+		notify func(ctx context.Context) (<-chan struct{}, error)
+		change func()
+	}{
+		{
+			"polling",
+			func(ctx context.Context) (<-chan struct{}, error) { return nil, errors.New("not supported") },
+			func() {},
+		},
+		{
+			"hotplug",
+			func(ctx context.Context) (<-chan struct{}, error) { return hotplug, nil },
+			func() { hotplug <- struct{}{} },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list, set := fakeDevices()
+			set(portal)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			events := watch(ctx, time.Millisecond, list, test.notify)
+			expectDeviceEvent(t, events, DeviceArrived, portal)
+
+			set(other)
+			test.change()
+			expectDeviceEvent(t, events, DeviceArrived, other)
+			expectDeviceEvent(t, events, DeviceLeft, portal)
+
+			cancel()
+			for range events {
+			}
+		})
+	}
+}
+
+func TestDeviceInfoFromEvent(t *testing.T) {
+	if _, ok := DeviceInfoFromEvent(NewEvent(Disconnect, nil)); ok {
+		t.Error("got true, want false")
+	}
+
+	want := DeviceInfo{VendorId: 0x1234, ProductId: 0x5678, Bus: 2, Address: 9}
+	got, ok := DeviceInfoFromEvent(NewEvent(DeviceLeft, want.eventData()))
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v and %t, want %+v and true", got, ok, want)
+	}
+}
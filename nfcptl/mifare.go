@@ -0,0 +1,215 @@
+package nfcptl
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// MFC_AuthKeyA is the MIFARE Classic AUTH command authenticating a sector using key A.
+	MFC_AuthKeyA = 0x60
+	// MFC_AuthKeyB is the MIFARE Classic AUTH command authenticating a sector using key B.
+	MFC_AuthKeyB = 0x61
+
+	// mfc1kSize is the size of the MIFARE Classic 1K memory: 16 sectors of four blocks each.
+	mfc1kSize = 1024
+	// mfcBlockSize is the size of a single MIFARE Classic block.
+	mfcBlockSize = 16
+	// mfcUidSize is the size of the NUID of a MIFARE Classic token.
+	mfcUidSize = 4
+	// mfcRetries is the number of times a block read or write is attempted.
+	mfcRetries = 3
+)
+
+// MFC_DefaultKey is the transport key MIFARE Classic tokens ship with, for both key A and key B.
+var MFC_DefaultKey = []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// mfc1kAmiiboBlocks holds the MIFARE Classic 1K blocks used to store an amiibo image, in order.
+// The 540 byte image is stored in the first 34 data blocks, skipping the manufacturer block 0 and
+// the sector trailers. This means the image occupies sectors 0 up to and including 11 and the last
+// four bytes of the last block are padding.
+var mfc1kAmiiboBlocks = mfc1kDataBlocks((ntag215Size + mfcBlockSize - 1) / mfcBlockSize)
+
+// mfc1kDataBlocks returns the first n MIFARE Classic 1K data blocks.
+func mfc1kDataBlocks(n int) []byte {
+	var blocks []byte
+	for b := 1; b < mfc1kSize/mfcBlockSize && len(blocks) < n; b++ {
+		if !mfcIsTrailer(b) {
+			blocks = append(blocks, byte(b))
+		}
+	}
+	return blocks
+}
+
+// mfcIsTrailer returns true when the given block is a sector trailer holding the keys and access
+// bits of the sector.
+func mfcIsTrailer(block int) bool {
+	return block%4 == 3
+}
+
+// MFC1KToAmiibo extracts the 540 byte amiibo image from the given 1024 byte MIFARE Classic 1K
+// dump.
+func MFC1KToAmiibo(dump []byte) ([]byte, error) {
+	if len(dump) != mfc1kSize {
+		return nil, fmt.Errorf("nfcptl: invalid MIFARE Classic 1K dump size, got %d bytes want %d", len(dump), mfc1kSize)
+	}
+
+	data := make([]byte, len(mfc1kAmiiboBlocks)*mfcBlockSize)
+	for i, b := range mfc1kAmiiboBlocks {
+		copy(data[i*mfcBlockSize:], dump[int(b)*mfcBlockSize:int(b+1)*mfcBlockSize])
+	}
+
+	return data[:ntag215Size], nil
+}
+
+// AmiiboToMFC1K stores the given 540 byte amiibo image in a copy of the given 1024 byte MIFARE
+// Classic 1K dump and returns it. When dump is nil, a blank dump is used with all sector trailers
+// holding MFC_DefaultKey and the transport access bits.
+func AmiiboToMFC1K(data, dump []byte) ([]byte, error) {
+	if len(data) != ntag215Size {
+		return nil, fmt.Errorf("nfcptl: invalid amiibo size, got %d bytes want %d", len(data), ntag215Size)
+	}
+
+	out := make([]byte, mfc1kSize)
+	if dump == nil {
+		for b := 3; b < mfc1kSize/mfcBlockSize; b += 4 {
+			trailer := out[b*mfcBlockSize:]
+			copy(trailer, MFC_DefaultKey)
+			copy(trailer[6:], []byte{0xff, 0x07, 0x80, 0x69})
+			copy(trailer[10:], MFC_DefaultKey)
+		}
+	} else if len(dump) != mfc1kSize {
+		return nil, fmt.Errorf("nfcptl: invalid MIFARE Classic 1K dump size, got %d bytes want %d", len(dump), mfc1kSize)
+	} else {
+		copy(out, dump)
+	}
+
+	padded := make([]byte, len(mfc1kAmiiboBlocks)*mfcBlockSize)
+	copy(padded, data)
+	for i, b := range mfc1kAmiiboBlocks {
+		copy(out[int(b)*mfcBlockSize:], padded[i*mfcBlockSize:(i+1)*mfcBlockSize])
+	}
+
+	return out, nil
+}
+
+// mfcDevice is implemented by drivers for devices that are able to execute the MIFARE Classic
+// commands on the token placed on the device. It allows drivers to share the read and write
+// procedures.
+type mfcDevice interface {
+	// authenticate authenticates the sector holding the given block using the given key type,
+	// being MFC_AuthKeyA or MFC_AuthKeyB, and the six byte key.
+	authenticate(block, keyType byte, key []byte) error
+	// readBlock returns the 16 bytes of the given block.
+	readBlock(block byte) ([]byte, error)
+	// writeBlock writes 16 bytes of data to the given block.
+	writeBlock(block byte, data []byte) error
+}
+
+// mfcAuthenticate authenticates the sector holding the given block using MFC_DefaultKey as key A,
+// falling back to key B when that fails.
+func mfcAuthenticate(d mfcDevice, block byte) error {
+	err := d.authenticate(block, MFC_AuthKeyA, MFC_DefaultKey)
+	if err != nil {
+		err = d.authenticate(block, MFC_AuthKeyB, MFC_DefaultKey)
+	}
+	return err
+}
+
+// readMFC1K reads the amiibo image stored on a MIFARE Classic 1K token. Each sector is
+// authenticated before its first block is read and each read is attempted mfcRetries times.
+func readMFC1K(d mfcDevice) ([]byte, error) {
+	data := make([]byte, len(mfc1kAmiiboBlocks)*mfcBlockSize)
+	sector := -1
+	for i, b := range mfc1kAmiiboBlocks {
+		var res []byte
+		var err error
+		for r := 0; r < mfcRetries; r++ {
+			if int(b/4) != sector {
+				if err = mfcAuthenticate(d, b); err != nil {
+					continue
+				}
+				sector = int(b / 4)
+			}
+			if res, err = d.readBlock(b); err == nil {
+				break
+			}
+			// Authenticate again before retrying.
+			sector = -1
+		}
+		if err != nil {
+			return data[:ntag215Size], fmt.Errorf("failed to read block %#02x: %v", b, err)
+		}
+		if len(res) != mfcBlockSize {
+			return data[:ntag215Size], fmt.Errorf("read of block %#02x returned %d bytes", b, len(res))
+		}
+		copy(data[i*mfcBlockSize:], res)
+	}
+
+	return data[:ntag215Size], nil
+}
+
+// writeMFC1K writes the given amiibo data to a MIFARE Classic 1K token. When userdataOnly is
+// false, the full image is written. When userdataOnly is true, only the blocks holding the NTAG215
+// user data area (pages 0x04 up to and including 0x81) are written, keeping the other bytes in
+// those blocks as they are on the token. When smart is true, blocks already holding the data to
+// write are skipped. The TokenTagWriteProgress event is published for each block write attempt.
+// On failure, the block that could not be written is returned or -1 when the failure is not block
+// specific.
+func writeMFC1K(c *Client, d mfcDevice, data []byte, userdataOnly, smart bool) (int, error) {
+	if len(data) != ntag215Size {
+		return -1, fmt.Errorf("data too short, got %d bytes want %d", len(data), ntag215Size)
+	}
+
+	image := make([]byte, len(mfc1kAmiiboBlocks)*mfcBlockSize)
+	current := make([]byte, len(image))
+	first := 0
+	last := len(mfc1kAmiiboBlocks) - 1
+	if userdataOnly || smart {
+		token, err := readMFC1K(d)
+		if err != nil {
+			return -1, err
+		}
+		copy(current, token)
+	}
+	if userdataOnly {
+		copy(image, current)
+		copy(image[0x04*4:0x82*4], data[0x04*4:0x82*4])
+		first = 0x04 * 4 / mfcBlockSize
+		last = (0x82*4 - 1) / mfcBlockSize
+	} else {
+		copy(image, data)
+	}
+
+	var blocks []int
+	for i := first; i <= last; i++ {
+		if !smart || !bytes.Equal(image[i*mfcBlockSize:(i+1)*mfcBlockSize], current[i*mfcBlockSize:(i+1)*mfcBlockSize]) {
+			blocks = append(blocks, i)
+		}
+	}
+
+	sector := -1
+	for n, i := range blocks {
+		b := mfc1kAmiiboBlocks[i]
+		var err error
+		for r := 0; r < mfcRetries; r++ {
+			publishWriteProgress(c, int(b), n+1, len(blocks), r)
+			if int(b/4) != sector {
+				if err = mfcAuthenticate(d, b); err != nil {
+					continue
+				}
+				sector = int(b / 4)
+			}
+			if err = d.writeBlock(b, image[i*mfcBlockSize:(i+1)*mfcBlockSize]); err == nil {
+				break
+			}
+			// Authenticate again before retrying.
+			sector = -1
+		}
+		if err != nil {
+			return int(b), fmt.Errorf("failed to write block %#02x: %v", b, err)
+		}
+	}
+
+	return -1, nil
+}
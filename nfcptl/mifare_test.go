@@ -0,0 +1,76 @@
+package nfcptl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMfc1kAmiiboBlocks(t *testing.T) {
+	if got, want := len(mfc1kAmiiboBlocks), 34; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	want := []byte{0x01, 0x02, 0x04, 0x05, 0x06, 0x08}
+	if got := mfc1kAmiiboBlocks[:len(want)]; !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+	if got, want := mfc1kAmiiboBlocks[33], byte(0x2d); got != want {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+
+	for _, b := range mfc1kAmiiboBlocks {
+		if mfcIsTrailer(int(b)) {
+			t.Errorf("got sector trailer %#02x, want data blocks only", b)
+		}
+	}
+}
+
+func TestAmiiboToMFC1K(t *testing.T) {
+	dump, err := AmiiboToMFC1K(testToken(), nil)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if got, want := len(dump), 1024; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	// Block 1 holds the start of the image, block 3 the sector trailer.
+	if got, want := dump[16:32], testToken()[:16]; !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+	want := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x07, 0x80, 0x69, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if got := dump[48:64]; !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	got, err := MFC1KToAmiibo(dump)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if !bytes.Equal(got, testToken()) {
+		t.Errorf("got %x, want %x", got, testToken())
+	}
+
+	// An existing dump keeps its manufacturer block and trailers.
+	dump[0] = 0x5a
+	dump[63] = 0x00
+	dump, err = AmiiboToMFC1K(make([]byte, 540), dump)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if dump[0] != 0x5a || dump[63] != 0x00 || dump[16] != 0x00 {
+		t.Errorf("got %x, want manufacturer block and trailers untouched", dump[:64])
+	}
+}
+
+func TestMFC1K_InvalidSize(t *testing.T) {
+	if _, err := MFC1KToAmiibo(make([]byte, 540)); err == nil {
+		t.Error("got nil, want error")
+	}
+	if _, err := AmiiboToMFC1K(make([]byte, 1024), nil); err == nil {
+		t.Error("got nil, want error")
+	}
+	if _, err := AmiiboToMFC1K(make([]byte, 540), make([]byte, 540)); err == nil {
+		t.Error("got nil, want error")
+	}
+}
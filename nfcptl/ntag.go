@@ -0,0 +1,477 @@
+package nfcptl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/malc0mn/amiigo/amiibo"
+	"log"
+)
+
+const (
+	// NTAG_Read is the NTAG21x READ command taking the page to start reading from as argument. It
+	// returns 16 bytes being four pages.
+	NTAG_Read = 0x30
+	// NTAG_Write is the NTAG21x WRITE command taking the page and the four bytes to write as
+	// arguments.
+	NTAG_Write = 0xa2
+	// NTAG_PwdAuth is the NTAG21x PWD_AUTH command taking the four byte password as argument. It
+	// returns the two byte password acknowledge.
+	NTAG_PwdAuth = 0x1b
+	// NTAG_GetVersion is the NTAG21x GET_VERSION command. It returns eight bytes identifying the
+	// chip, for an NTAG215 being 0x00 0x04 0x04 0x02 0x01 0x00 0x11 0x03.
+	NTAG_GetVersion = 0x60
+	// NTAG_ReadSig is the NTAG21x READ_SIG command taking the address 0x00 as argument. It returns
+	// the 32 byte ECC originality signature.
+	NTAG_ReadSig = 0x3c
+
+	// ntag215Size is the size of the NTAG215 memory.
+	ntag215Size = 540
+	// ntagRetries is the number of times a page read or write is attempted.
+	ntagRetries = 3
+)
+
+var (
+	// errNTAGNoToken is returned by the page commands when there is no token on the device.
+	errNTAGNoToken = errors.New("no token present")
+	// errNTAGInvalidArgs is returned by the page commands when the command arguments are invalid.
+	errNTAGInvalidArgs = errors.New("invalid arguments")
+)
+
+// ntagDevice is implemented by drivers for devices that are able to execute the NTAG21x commands
+// on the token placed on the device. It allows drivers to share the read and write procedures.
+type ntagDevice interface {
+	// readPages executes the READ command returning the 16 bytes starting from the given page.
+	readPages(page byte) ([]byte, error)
+	// writePage executes the WRITE command writing four bytes of data to the given page.
+	writePage(page byte, data []byte) error
+	// pwdAuth executes the PWD_AUTH command returning the password acknowledge.
+	pwdAuth(pwd []byte) ([]byte, error)
+}
+
+// readNTAG215 reads the full NTAG215 memory of the token. Each read is attempted ntagRetries times.
+func readNTAG215(d ntagDevice) ([]byte, error) {
+	token := make([]byte, ntag215Size)
+	for page := 0; page < 0x88; page += 4 {
+		var res []byte
+		var err error
+		for i := 0; i < ntagRetries; i++ {
+			if res, err = d.readPages(byte(page)); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return token, fmt.Errorf("failed to read page %#02x: %v", page, err)
+		}
+		if len(res) != 16 {
+			return token, fmt.Errorf("read of page %#02x returned %d bytes", page, len(res))
+		}
+		// Note that page 0x84 contains only 12 bytes we actually need but copy is clever and will
+		// not cause a buffer overflow, which is nice.
+		copy(token[page*4:], res)
+	}
+
+	return token, nil
+}
+
+// readNTAGPages reads the given number of pages starting from the given page. Each READ command
+// returns four pages, so only the data of the requested pages is returned.
+func readNTAGPages(d ntagDevice, page byte, count int) ([]byte, error) {
+	data := make([]byte, 0, count*4+12)
+	for p := int(page); len(data) < count*4; p += 4 {
+		res, err := d.readPages(byte(p))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page %#02x: %v", p, err)
+		}
+		if len(res) != 16 {
+			return nil, fmt.Errorf("read of page %#02x returned %d bytes", p, len(res))
+		}
+		data = append(data, res...)
+	}
+
+	return data[:count*4], nil
+}
+
+// handlePageCommand executes the ReadPages, WritePage or PwdAuth command on the token and publishes
+// the TokenTagPages, TokenTagPageWritten or TokenTagAuthenticated event. When the command fails,
+// the TokenTagPageError event is published holding the ClientCommand. Unlike the token read and
+// write procedures, the commands are attempted only once. The placed argument tells whether a token
+// is present on the device at all. The given name is used to prefix log messages.
+func handlePageCommand(c *Client, d ntagDevice, name string, placed bool, cmd Command) {
+	var e *Event
+	err := errNTAGNoToken
+	if placed {
+		args := cmd.Arguments
+		switch {
+		case cmd.Command == ReadPages && len(args) == 2 && args[1] > 0 && int(args[0])+int(args[1]) <= 0x100:
+			var data []byte
+			if data, err = readNTAGPages(d, args[0], int(args[1])); err == nil {
+				e = NewEvent(TokenTagPages, append([]byte{args[0]}, data...))
+			}
+		case cmd.Command == WritePage && len(args) == 5:
+			if err = d.writePage(args[0], args[1:]); err == nil {
+				e = NewEvent(TokenTagPageWritten, args[:1])
+			}
+		case cmd.Command == PwdAuth && len(args) == 4:
+			var pack []byte
+			if pack, err = d.pwdAuth(args); err == nil {
+				e = NewEvent(TokenTagAuthenticated, pack)
+			}
+		default:
+			err = errNTAGInvalidArgs
+		}
+	}
+
+	if err != nil {
+		log.Printf("%s: %s failed: %s", name, cmd.Command, err)
+		e = NewErrorEvent(TokenTagPageError, []byte{byte(cmd.Command)}, err)
+	}
+	c.PublishEvent(e)
+}
+
+// unlockNTAG215 authenticates to the token using the password in the given amiibo data when the
+// token is password protected. A token is password protected when AUTH0, the last byte of page
+// 0x83, holds a page number within the NTAG215 memory.
+func unlockNTAG215(d ntagDevice, data []byte) error {
+	cfg, err := d.readPages(0x83)
+	if err != nil {
+		return err
+	}
+	if cfg[3] > 0x86 {
+		return nil
+	}
+
+	pack, err := d.pwdAuth(data[532:536])
+	if err != nil {
+		return fmt.Errorf("password authentication failed: %v", err)
+	}
+	if !bytes.Equal(pack, data[536:538]) {
+		return fmt.Errorf("password acknowledge mismatch, got %x want %x", pack, data[536:538])
+	}
+
+	return nil
+}
+
+// fetchNTAG215 reads the token and publishes the TokenTagData event or the TokenTagDataError event
+// when the token could not be read. The given name is used to prefix log messages.
+func fetchNTAG215(c *Client, d ntagDevice, name string) {
+	token, err := readNTAG215(d)
+	if err != nil {
+		log.Printf("%s: %s", name, err)
+		c.PublishEvent(NewErrorEvent(TokenTagDataError, token, err))
+		return
+	}
+
+	if c.Debug() {
+		log.Printf("%s: full token data:", name)
+		log.Println(hex.Dump(token))
+	}
+	c.PublishEvent(NewEvent(TokenTagData, token))
+}
+
+// ntag215WriteOrder returns the pages to write to an NTAG215 token in the order they must be
+// written. When userdataOnly is true, these are pages 0x04 up to and including 0x81 (the NTAG215
+// user data area). Otherwise, these are all 135 pages with the last page written first and the
+// first page written last, just like the original software does.
+func ntag215WriteOrder(userdataOnly bool) []int {
+	var pages []int
+	if userdataOnly {
+		for page := 0x04; page <= 0x81; page++ {
+			pages = append(pages, page)
+		}
+		return pages
+	}
+
+	pages = append(pages, 0x86)
+	for page := 0x01; page <= 0x85; page++ {
+		pages = append(pages, page)
+	}
+	return append(pages, 0x00)
+}
+
+// ntag215ProvisionOrder returns the pages to write to a blank NTAG215 token when provisioning it,
+// in the order they must be written: the data pages 0x03 up to and including 0x81, then PWD and
+// PACK, then the configuration pages and finally the static and dynamic lock bytes. The UID pages
+// 0x00 and 0x01 are factory programmed and are never written.
+func ntag215ProvisionOrder() []int {
+	var pages []int
+	for page := 0x03; page <= 0x81; page++ {
+		pages = append(pages, page)
+	}
+	return append(pages, 0x85, 0x86, 0x83, 0x84, 0x02, 0x82)
+}
+
+// ntag215Pages returns the pages written using the given WriteTokenData mode in the order they
+// must be written.
+func ntag215Pages(mode byte) []int {
+	if mode&WriteProvision != 0 {
+		return ntag215ProvisionOrder()
+	}
+	return ntag215WriteOrder(mode&WriteUserData != 0)
+}
+
+// ntag215UIDChanges returns the factory programmed pages 0x00 and 0x01 of the given token data that
+// differ from the given amiibo data, together with page 0x02 when BCC1 differs. A blank token can
+// only be provisioned using amiibo data holding its UID.
+func ntag215UIDChanges(token, data []byte) []byte {
+	var changed []byte
+	for page := 0x00; page <= 0x01; page++ {
+		if !bytes.Equal(token[page*4:page*4+4], data[page*4:page*4+4]) {
+			changed = append(changed, byte(page))
+		}
+	}
+	if token[8] != data[8] {
+		changed = append(changed, 0x02)
+	}
+	return changed
+}
+
+// ntag215Tag returns the given NTAG215 token data as an amiibo.NTAG215 to analyse it.
+func ntag215Tag(token []byte) *amiibo.NTAG215 {
+	var data [amiibo.NTAG215Size]byte
+	copy(data[:], token)
+	return amiibo.NewNTAG215(data)
+}
+
+// ntag215LockedPages returns which pages of the given NTAG215 token data are locked for writing as
+// determined by amiibo.NTAG215.LockedPages.
+func ntag215LockedPages(token []byte) [amiibo.NTAG215Pages]bool {
+	return ntag215Tag(token).LockedPages()
+}
+
+// readNTAG215Locks reads the lock bytes and configuration pages of the token using the given read
+// function, followed by the locked pages among the given pages. The returned token data only holds
+// the pages that have been read, all other pages are zero. This is all ntag215LockedChanges needs,
+// at a fraction of the reads needed for the full token.
+func readNTAG215Locks(read func(page byte) ([]byte, error), pages []int) ([]byte, error) {
+	token := make([]byte, ntag215Size)
+	var done [0x88 / 4]bool
+	readAt := func(page int) error {
+		page -= page % 4
+		if done[page/4] {
+			return nil
+		}
+		res, err := read(byte(page))
+		if err != nil {
+			return fmt.Errorf("failed to read page %#02x: %v", page, err)
+		}
+		if len(res) != 16 {
+			return fmt.Errorf("read of page %#02x returned %d bytes", page, len(res))
+		}
+		copy(token[page*4:], res)
+		done[page/4] = true
+		return nil
+	}
+
+	for _, page := range []int{0x02, 0x82, 0x84} {
+		if err := readAt(page); err != nil {
+			return token, err
+		}
+	}
+	locked := ntag215LockedPages(token)
+	for _, page := range pages {
+		if !locked[page] {
+			continue
+		}
+		if err := readAt(page); err != nil {
+			return token, err
+		}
+	}
+
+	return token, nil
+}
+
+// ntag215LockedChanges returns the given pages that are locked on the token while the data differs
+// from the data on the token as determined by amiibo.NTAG215.LockedChanges, as the payload of the
+// TokenTagWriteLocked event.
+func ntag215LockedChanges(token, data []byte, pages []int) []byte {
+	var changes []byte
+	for _, page := range ntag215Tag(token).LockedChanges(data, pages) {
+		changes = append(changes, byte(page))
+	}
+
+	return changes
+}
+
+// ntag215ChangedPages returns the given pages, in the same order, for which the data differs from
+// the data on the token. Note that a token reads PWD and PACK as zero, so these pages are always
+// considered to be changed unless the data holds zero as well.
+func ntag215ChangedPages(token, data []byte, pages []int) []int {
+	var changed []int
+	for _, page := range pages {
+		i := page * 4
+		if !bytes.Equal(token[i:i+4], data[i:i+4]) {
+			changed = append(changed, page)
+		}
+	}
+
+	return changed
+}
+
+// ntag215Mismatches returns the given pages for which the given token data, as read after a write,
+// differs from the data that should have been written. The PWD and PACK pages are skipped since a
+// token always reads these as zero.
+func ntag215Mismatches(token, data []byte, pages []int) []byte {
+	var mismatches []byte
+	for _, page := range pages {
+		if page >= 0x85 {
+			continue
+		}
+		i := page * 4
+		if !bytes.Equal(token[i:i+4], data[i:i+4]) {
+			mismatches = append(mismatches, byte(page))
+		}
+	}
+
+	return mismatches
+}
+
+// verifyWrite compares the given token data, as read after a write, to the data that should have
+// been written to the given pages. It ends the write by publishing the TokenTagWriteFinish event
+// when they match or the TokenTagVerifyError event when they differ. The given name is used to
+// prefix log messages.
+func verifyWrite(c *Client, name string, token, data []byte, pages []int) {
+	if mismatches := ntag215Mismatches(token, data, pages); mismatches != nil {
+		log.Printf("%s: write verification failed, pages %x differ", name, mismatches)
+		c.PublishEvent(NewEvent(TokenTagVerifyError, mismatches))
+		return
+	}
+	log.Printf("%s: write verified", name)
+	c.PublishEvent(NewEvent(TokenTagWriteFinish, nil))
+}
+
+// publishWriteProgress publishes the TokenTagWriteProgress event for the given page being the n-th
+// page, one based, of the total number of pages to write and attempted for the given number of
+// retries.
+func publishWriteProgress(c *Client, page, n, total, retries int) {
+	c.PublishEvent(NewEvent(TokenTagWriteProgress, []byte{byte(page), byte(n), byte(total), byte(retries)}))
+}
+
+// writeNTAG215 writes the given amiibo data to the token just like the stm32f0 driver does and
+// publishes the same events. The mode is one of the write modes of the WriteTokenData command. For
+// a full write, all 540 bytes will be written to the token, the last page first and the first page
+// last. For a user data write, only pages 0x04 up to and including 0x81 (the NTAG215 user data
+// area) will be written. In smart mode, the token is read first and only the pages that differ are
+// written. In provisioning mode, the pages are written in the order of ntag215ProvisionOrder and
+// the write is refused when the UID of the token differs from the one in the data. The
+// TokenTagWriteProgress event is published for each page write attempt. When the token is password
+// protected, the password in the given data is used to authenticate first. The write is refused
+// using the TokenTagWriteLocked event when a locked page would have to change. The placed argument
+// tells whether a token is present on the device at all.
+// After a successful write, the token is read again and published using the TokenTagData event. In
+// verify or provisioning mode, the TokenTagWriteFinish event is only published once the token data
+// read back matches, otherwise the write ends with the TokenTagVerifyError event.
+// The given name is used to prefix log messages.
+func writeNTAG215(c *Client, d ntagDevice, name string, placed bool, data []byte, mode byte) {
+	got := len(data)
+	want := ntag215Size
+	if got != want {
+		log.Printf("%s: data too short, got %d bytes want %d", name, got, want)
+		c.PublishEvent(NewEvent(TokenTagDataSizeError, data))
+		return
+	}
+
+	userdataOnly := mode&WriteUserData != 0
+	provision := mode&WriteProvision != 0
+	smart := mode&WriteSmart != 0 && !provision
+	msg := "full"
+	if userdataOnly {
+		msg = "user"
+	}
+	if provision {
+		msg = "provisioning"
+	}
+	if smart {
+		msg = "smart " + msg
+	}
+
+	if c.Debug() {
+		log.Printf("%s: %s token data to be written:", name, msg)
+		log.Println(hex.Dump(data))
+	}
+
+	log.Printf("%s: starting %s token data write procedure", name, msg)
+	c.PublishEvent(NewEvent(TokenTagWriteStart, nil))
+
+	if !placed {
+		log.Printf("%s: write failed, no token present", name)
+		c.PublishEvent(NewEvent(TokenTagWriteError, nil))
+		return
+	}
+
+	if err := unlockNTAG215(d, data); err != nil {
+		log.Printf("%s: %s", name, err)
+		c.PublishEvent(NewErrorEvent(TokenTagWriteError, nil, err))
+		return
+	}
+
+	// Make sure the write is possible before writing anything. In smart mode the full token is
+	// needed anyway.
+	pages := ntag215Pages(mode)
+	var token []byte
+	var err error
+	if smart {
+		token, err = readNTAG215(d)
+	} else {
+		token, err = readNTAG215Locks(d.readPages, pages)
+	}
+	if err != nil {
+		log.Printf("%s: %s", name, err)
+		c.PublishEvent(NewErrorEvent(TokenTagWriteError, nil, err))
+		return
+	}
+	locked := ntag215LockedChanges(token, data, pages)
+	if provision {
+		locked = append(ntag215UIDChanges(token, data), locked...)
+	}
+	if locked != nil {
+		log.Printf("%s: write refused, locked pages %x would have to change", name, locked)
+		c.PublishEvent(NewEvent(TokenTagWriteLocked, locked))
+		return
+	}
+	if smart {
+		pages = ntag215ChangedPages(token, data, pages)
+		log.Printf("%s: %d pages differ from the token", name, len(pages))
+	}
+
+	for n, page := range pages {
+		if provision && page == 0x84 {
+			// AUTH0 has just been written: authenticate using the new password before writing the
+			// remaining pages.
+			if err = unlockNTAG215(d, data); err != nil {
+				log.Printf("%s: %s", name, err)
+				c.PublishEvent(NewErrorEvent(TokenTagWriteError, []byte{byte(page)}, err))
+				return
+			}
+		}
+		for i := 0; i < ntagRetries; i++ {
+			publishWriteProgress(c, page, n+1, len(pages), i)
+			// byte(page) conversion is safe here since we stick to NTAG215 pages
+			if err = d.writePage(byte(page), data[page*4:page*4+4]); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("%s: failed to write page %#02x: %v", name, page, err)
+			c.PublishEvent(NewErrorEvent(TokenTagWriteError, []byte{byte(page)}, err))
+			return
+		}
+	}
+
+	log.Printf("%s: successfully finished write procedure", name)
+
+	if mode&(WriteVerify|WriteProvision) == 0 {
+		c.PublishEvent(NewEvent(TokenTagWriteFinish, nil))
+		fetchNTAG215(c, d, name)
+		return
+	}
+
+	if token, err = readNTAG215(d); err != nil {
+		log.Printf("%s: %s", name, err)
+		c.PublishEvent(NewErrorEvent(TokenTagDataError, token, err))
+		return
+	}
+	verifyWrite(c, name, token, data, ntag215Pages(mode))
+	c.PublishEvent(NewEvent(TokenTagData, token))
+}
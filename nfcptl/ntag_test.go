@@ -0,0 +1,176 @@
+package nfcptl
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNtag215WriteOrder(t *testing.T) {
+	pages := ntag215WriteOrder(false)
+	if got, want := len(pages), 0x87; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	if got, want := pages[:3], []int{0x86, 0x01, 0x02}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+	if got, want := pages[len(pages)-2:], []int{0x85, 0x00}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+
+	pages = ntag215WriteOrder(true)
+	if got, want := len(pages), 0x7e; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	if got, want := []int{pages[0], pages[len(pages)-1]}, []int{0x04, 0x81}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+}
+
+func TestNtag215ProvisionOrder(t *testing.T) {
+	pages := ntag215ProvisionOrder()
+	if got, want := len(pages), 0x85; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+	if got, want := []int{pages[0], pages[0x7e]}, []int{0x03, 0x81}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+	if got, want := pages[0x7f:], []int{0x85, 0x86, 0x83, 0x84, 0x02, 0x82}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+
+	if got, want := ntag215Pages(WriteProvision|WriteUserData), pages; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+}
+
+func TestNtag215UIDChanges(t *testing.T) {
+	token := testToken()
+	data := testToken()
+	if got := ntag215UIDChanges(token, data); got != nil {
+		t.Errorf("got %#02x, want nil", got)
+	}
+
+	data[1] = 0xff
+	data[8] = 0xff
+	if got, want := ntag215UIDChanges(token, data), []byte{0x00, 0x02}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+}
+
+// retailToken returns the token data of an NTAG215 locked like a retail amiibo, shared with the
+// lock tests of the amiibo package: pages 0x03, 0x0d up to and including 0x1f and the
+// configuration pages are locked.
+func retailToken(t *testing.T) []byte {
+	token, err := os.ReadFile("../amiibo/testdata/retail_ntag215.bin")
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	return token
+}
+
+func TestNtag215LockedPages(t *testing.T) {
+	locked := ntag215LockedPages(retailToken(t))
+	var got []int
+	for page, l := range locked {
+		if l {
+			got = append(got, page)
+		}
+	}
+
+	want := []int{0x03, 0x0d, 0x0e, 0x0f}
+	for page := 0x10; page <= 0x1f; page++ {
+		want = append(want, page)
+	}
+	want = append(want, 0x83, 0x84)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+}
+
+func TestNtag215ChangedPages(t *testing.T) {
+	token := make([]byte, 540)
+	token[0x82*4] = 0x80 // Locks pages 0x80 and 0x81.
+
+	data := make([]byte, 540)
+	data[0x82*4] = 0x80
+	data[0x04*4] = 0x01
+	data[0x86*4] = 0x01
+	data[0x00*4+3] = 0x01
+
+	changed := ntag215ChangedPages(token, data, ntag215WriteOrder(false))
+	if want := []int{0x86, 0x04, 0x00}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("got %#02x, want %#02x", changed, want)
+	}
+	if locked := ntag215LockedChanges(token, data, ntag215WriteOrder(false)); locked != nil {
+		t.Errorf("got %#02x, want nil", locked)
+	}
+
+	data[0x81*4+2] = 0x01
+	changed = ntag215ChangedPages(token, data, ntag215WriteOrder(true))
+	if want := []int{0x04, 0x81}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("got %#02x, want %#02x", changed, want)
+	}
+	if got, want := ntag215LockedChanges(token, data, ntag215WriteOrder(true)), []byte{0x81}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+}
+
+func TestNtag215LockedChanges(t *testing.T) {
+	token := retailToken(t)
+	data := append([]byte{}, token...)
+	for _, page := range []int{0x03, 0x04, 0x10, 0x20, 0x83} {
+		data[page*4] ^= 0xff
+	}
+
+	if got, want := ntag215LockedChanges(token, data, ntag215WriteOrder(true)), []byte{0x10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+	if got, want := ntag215LockedChanges(token, data, ntag215WriteOrder(false)), []byte{0x03, 0x10, 0x83}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+}
+
+func TestReadNTAG215Locks(t *testing.T) {
+	token := testToken()
+	var reads []byte
+	read := func(page byte) ([]byte, error) {
+		reads = append(reads, page)
+		res := make([]byte, 16)
+		copy(res, token[int(page)*4:])
+		return res, nil
+	}
+
+	// The test token has pages 0x03, 0x08, 0x09, 0x0b and 0x40 up to 0x4f locked.
+	got, err := readNTAG215Locks(read, ntag215WriteOrder(true))
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+	if want := []byte{0x00, 0x80, 0x84, 0x08, 0x40, 0x44, 0x48, 0x4c}; !bytes.Equal(reads, want) {
+		t.Errorf("got %#02x, want %#02x", reads, want)
+	}
+	if want := make([]byte, 16); !bytes.Equal(got[0x04*4:0x08*4], want) {
+		t.Errorf("got %x, want %x", got[0x04*4:0x08*4], want)
+	}
+	if locked := ntag215LockedChanges(got, token, ntag215WriteOrder(true)); locked != nil {
+		t.Errorf("got %#02x, want nil", locked)
+	}
+}
+
+func TestNtag215Mismatches(t *testing.T) {
+	data := testToken()
+	token := readTokenData(data)
+	if got := ntag215Mismatches(token, data, ntag215WriteOrder(false)); got != nil {
+		t.Errorf("got %#02x, want nil", got)
+	}
+
+	token[0x04*4] ^= 0xff
+	token[0x82*4+1] ^= 0xff
+	if got, want := ntag215Mismatches(token, data, ntag215WriteOrder(false)), []byte{0x04, 0x82}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+	if got, want := ntag215Mismatches(token, data, ntag215WriteOrder(true)), []byte{0x04}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#02x, want %#02x", got, want)
+	}
+}
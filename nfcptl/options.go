@@ -0,0 +1,95 @@
+package nfcptl
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotConfigurable is returned by Client.Configure when the driver does not implement the
+// Configurable interface.
+var ErrNotConfigurable = errors.New("nfcptl: driver is not configurable")
+
+// Configurable is an optional interface a Driver can implement to allow its behavior to be tuned
+// using typed options.
+type Configurable interface {
+	// Configure applies the given options in order. It returns an ErrUnsupportedOption error for
+	// an option the driver does not support. It MUST be called before connecting.
+	Configure(opts ...Option) error
+}
+
+// Option is a typed driver option passed to Configurable.Configure.
+type Option interface {
+	// OptionName returns the name of the option, used in error messages.
+	OptionName() string
+}
+
+// Optimised makes the driver communicate with the device as efficiently as possible instead of
+// mimicking the original software as closely as possible.
+type Optimised bool
+
+func (Optimised) OptionName() string { return "optimised" }
+
+// PollInterval sets the interval at which the driver polls the device for a token. Shorter
+// intervals make token detection faster.
+type PollInterval time.Duration
+
+func (PollInterval) OptionName() string { return "poll interval" }
+
+func (p PollInterval) String() string { return time.Duration(p).String() }
+
+// RemovalThreshold sets the number of consecutive failed token polls after which the token is
+// considered to be removed from the device.
+type RemovalThreshold int
+
+func (RemovalThreshold) OptionName() string { return "removal threshold" }
+
+// ReadRetries sets the number of times reading a page is retried before the read fails.
+type ReadRetries int
+
+func (ReadRetries) OptionName() string { return "read retries" }
+
+// WriteRetries sets the number of times writing a page is retried before the write fails.
+type WriteRetries int
+
+func (WriteRetries) OptionName() string { return "write retries" }
+
+// ExperimentalMFC enables reading and writing MIFARE Classic 1K tokens on devices of which the
+// MIFARE Classic support has not been verified on real hardware yet. Use at your own risk!
+type ExperimentalMFC bool
+
+func (ExperimentalMFC) OptionName() string { return "experimental MIFARE Classic" }
+
+// ErrUnsupportedOption defines the error structure returned when a driver does not support an
+// Option.
+type ErrUnsupportedOption struct {
+	Option Option
+}
+
+// Error implements the error interface
+func (e ErrUnsupportedOption) Error() string {
+	return fmt.Sprintf("nfcptl: unsupported option %s", e.Option.OptionName())
+}
+
+// ErrInvalidOption defines the error structure returned when the value of an Option is out of
+// range for the driver.
+type ErrInvalidOption struct {
+	Option Option
+}
+
+// Error implements the error interface
+func (e ErrInvalidOption) Error() string {
+	return fmt.Sprintf("nfcptl: invalid value %v for option %s", e.Option, e.Option.OptionName())
+}
+
+// Configure applies the given options to the driver of the client. ErrNotConfigurable is returned
+// when the driver does not implement the Configurable interface. It MUST be called before
+// connecting.
+func (c *Client) Configure(opts ...Option) error {
+	d, ok := c.driver.(Configurable)
+	if !ok {
+		return ErrNotConfigurable
+	}
+
+	return d.Configure(opts...)
+}
@@ -1,5 +1,15 @@
 package nfcptl
 
+import (
+	"errors"
+	"github.com/google/gousb"
+	"strings"
+	"syscall"
+)
+
+// ErrDeviceGone can be returned by a Protocol when the device has been detached.
+var ErrDeviceGone = errors.New("nfcptl: device is gone")
+
 // Protocol represents the Protocol layer used by the client.
 type Protocol interface {
 	// Connect connects to the device.
@@ -11,3 +21,34 @@ type Protocol interface {
 	// Write writes the given data to the underlying Protocol.
 	Write(p []byte) (int, error)
 }
+
+// IsDeviceGone returns true when the given error returned by a Protocol means that the device has
+// been detached, e.g. because its cable was yanked. Drivers MUST then call Client.DeviceLost.
+func IsDeviceGone(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrDeviceGone) || errors.Is(err, gousb.ErrorNoDevice) || errors.Is(err, gousb.TransferNoDevice) || errors.Is(err, syscall.ENODEV) {
+		return true
+	}
+
+	// A Replay only knows the message of a recorded error.
+	msg := err.Error()
+	for _, gone := range []error{ErrDeviceGone, gousb.ErrorNoDevice, gousb.TransferNoDevice} {
+		if strings.Contains(msg, gone.Error()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ProtocolDriver is implemented by drivers that communicate with the device through a Protocol. It
+// allows replacing the Protocol, e.g. by a Recorder or a Replay.
+type ProtocolDriver interface {
+	// Protocol returns the Protocol the driver is using.
+	Protocol() Protocol
+	// SetProtocol replaces the Protocol the driver is using. It returns an error when the driver
+	// cannot work with the given Protocol. It MUST be called before connecting.
+	SetProtocol(p Protocol) error
+}
@@ -0,0 +1,434 @@
+package nfcptl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A recording is a plain text file holding one line per Read or Write done on a Protocol. Lines
+// starting with '#' are comments. Lines starting with '@' hold a setting of the recorded device.
+// All other lines hold a packet: the time since the connection was established in seconds, the
+// direction being R for Read and W for Write and the data in hexadecimal notation. When the Read or
+// Write returned an error, the direction is followed by an exclamation mark and the data is
+// followed by the error message:
+//
+//	# nfcptl recording of datel/ps4amiibo (1c1a:03d9)
+//	@ max_packet_size 64
+//	@ poll_interval 1ms
+//	0.000534 W 02cdcdcd...
+//	0.001412 R 00004e46432d506f7274616c...
+//	0.002011 W! 12cdcdcd... libusb: no device [code -4]
+const (
+	settingMaxPacketSize = "max_packet_size"
+	settingPollInterval  = "poll_interval"
+
+	// replayPollInterval is the poll interval used by a Replay when the recording does not hold one.
+	replayPollInterval = time.Millisecond
+)
+
+// ErrNoProtocol is returned when trying to record or replay the traffic of a driver that does not
+// communicate with the device through a Protocol.
+var ErrNoProtocol = errors.New("nfcptl: driver does not use a Protocol")
+
+// Direction indicates whether a Packet was read from or written to the device.
+type Direction byte
+
+const (
+	DirectionRead  Direction = 'R'
+	DirectionWrite Direction = 'W'
+)
+
+// Packet describes a single Read or Write done on a Protocol.
+type Packet struct {
+	Time      time.Duration // Time holds the time since the connection was established.
+	Direction Direction     // Direction holds the direction of the data.
+	Data      []byte        // Data holds the data that was read or written.
+	Err       string        // Err holds the message of the error returned, empty on success.
+}
+
+// String returns the packet as a line in a recording, without the trailing newline.
+func (p Packet) String() string {
+	if p.Err != "" {
+		return fmt.Sprintf("%.6f %c! %x %s", p.Time.Seconds(), p.Direction, p.Data, p.Err)
+	}
+	return fmt.Sprintf("%.6f %c %x", p.Time.Seconds(), p.Direction, p.Data)
+}
+
+// Recording holds a recorded Protocol session.
+type Recording struct {
+	MaxPacketSize int           // MaxPacketSize holds the maximum packet size of a USB device.
+	PollInterval  time.Duration // PollInterval holds the poll interval of a USB device.
+	Packets       []Packet      // Packets holds all packets in the order they were recorded.
+}
+
+// ReadRecording parses a recording.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	rec := &Recording{}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var err error
+		if line[0] == '@' {
+			err = rec.parseSetting(line[1:])
+		} else {
+			var p Packet
+			if p, err = parsePacket(line); err == nil {
+				rec.Packets = append(rec.Packets, p)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("nfcptl: recording line %d: %v", n, err)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("nfcptl: reading recording: %v", err)
+	}
+
+	return rec, nil
+}
+
+// parseSetting parses a setting line without the leading '@'.
+func (rec *Recording) parseSetting(line string) error {
+	f := strings.Fields(line)
+	if len(f) != 2 {
+		return fmt.Errorf("invalid setting %q", line)
+	}
+
+	var err error
+	switch f[0] {
+	case settingMaxPacketSize:
+		rec.MaxPacketSize, err = strconv.Atoi(f[1])
+	case settingPollInterval:
+		rec.PollInterval, err = time.ParseDuration(f[1])
+	default:
+		err = fmt.Errorf("unknown setting %s", f[0])
+	}
+
+	return err
+}
+
+// parsePacket parses a packet line.
+func parsePacket(line string) (Packet, error) {
+	var p Packet
+
+	f := strings.SplitN(line, " ", 4)
+	if len(f) < 2 {
+		return p, fmt.Errorf("invalid packet %q", line)
+	}
+
+	secs, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return p, fmt.Errorf("invalid time %q", f[0])
+	}
+	p.Time = time.Duration(secs * float64(time.Second))
+
+	dir := strings.TrimSuffix(f[1], "!")
+	if dir != string(DirectionRead) && dir != string(DirectionWrite) {
+		return p, fmt.Errorf("invalid direction %q", f[1])
+	}
+	p.Direction = Direction(dir[0])
+
+	if len(f) > 2 {
+		if p.Data, err = hex.DecodeString(f[2]); err != nil {
+			return p, fmt.Errorf("invalid data: %v", err)
+		}
+	}
+
+	if isErr := dir != f[1]; isErr {
+		p.Err = "unknown error"
+		if len(f) > 3 {
+			p.Err = f[3]
+		}
+	}
+
+	return p, nil
+}
+
+// Recorder implements the Protocol interface by wrapping another Protocol and recording every Read
+// and Write to the given writer. It also implements the USBProtocol and UARTProtocol interfaces by
+// passing calls on to the wrapped Protocol, allowing it to be used with any driver.
+type Recorder struct {
+	p Protocol
+	w io.Writer
+
+	mu    sync.Mutex
+	start time.Time
+	err   error
+}
+
+// NewRecorder returns a Recorder recording all traffic of the given Protocol to w.
+func NewRecorder(p Protocol, w io.Writer) *Recorder {
+	return &Recorder{p: p, w: w}
+}
+
+// Connect connects the wrapped Protocol and writes the recording header.
+func (r *Recorder) Connect(c *Client) error {
+	if err := r.p.Connect(c); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.start = time.Now()
+	r.writeLine(fmt.Sprintf("# nfcptl recording of %s/%s (%04x:%04x) started %s", c.va, c.pa, c.VendorId(), c.ProductId(), r.start.Format(time.RFC3339)))
+	if usb, ok := r.p.(USBProtocol); ok {
+		r.writeLine(fmt.Sprintf("@ %s %d", settingMaxPacketSize, usb.MaxPacketSize()))
+		r.writeLine(fmt.Sprintf("@ %s %s", settingPollInterval, usb.PollInterval()))
+	}
+
+	return nil
+}
+
+func (r *Recorder) Disconnect() error {
+	return r.p.Disconnect()
+}
+
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.p.Read(p)
+	r.record(DirectionRead, p[:n], err)
+	return n, err
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	n, err := r.p.Write(p)
+	r.record(DirectionWrite, p, err)
+	return n, err
+}
+
+// SetIdle passes the call on to the wrapped Protocol when it is a USBProtocol.
+func (r *Recorder) SetIdle(val, idx uint16) {
+	if usb, ok := r.p.(USBProtocol); ok {
+		usb.SetIdle(val, idx)
+	}
+}
+
+// PollInterval passes the call on to the wrapped Protocol when it is a USBProtocol.
+func (r *Recorder) PollInterval() time.Duration {
+	if usb, ok := r.p.(USBProtocol); ok {
+		return usb.PollInterval()
+	}
+	return replayPollInterval
+}
+
+// MaxPacketSize passes the call on to the wrapped Protocol when it is a USBProtocol.
+func (r *Recorder) MaxPacketSize() int {
+	if usb, ok := r.p.(USBProtocol); ok {
+		return usb.MaxPacketSize()
+	}
+	return 0
+}
+
+// Speed passes the call on to the wrapped Protocol when it is a UARTProtocol.
+func (r *Recorder) Speed(s int) error {
+	if uart, ok := r.p.(UARTProtocol); ok {
+		return uart.Speed(s)
+	}
+	return nil
+}
+
+// Err returns the first error encountered writing the recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// record writes a packet to the recording.
+func (r *Recorder) record(dir Direction, data []byte, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := Packet{Time: time.Since(r.start), Direction: dir, Data: data}
+	if err != nil {
+		p.Err = err.Error()
+	}
+	r.writeLine(p.String())
+}
+
+// writeLine writes a single line to the recording. The caller MUST hold the lock.
+func (r *Recorder) writeLine(line string) {
+	if r.err != nil {
+		return
+	}
+	_, r.err = io.WriteString(r.w, line+"\n")
+}
+
+// ErrReplayMismatch defines the error structure returned by a Replay when the driver does not
+// behave as recorded.
+type ErrReplayMismatch struct {
+	Index     int       // Index holds the index of the expected packet in the recording.
+	Want      Packet    // Want holds the expected packet.
+	Direction Direction // Direction holds the direction of the call done by the driver.
+	Data      []byte    // Data holds the data written by the driver.
+}
+
+// Error implements the error interface
+func (e ErrReplayMismatch) Error() string {
+	if e.Direction == DirectionRead {
+		return fmt.Sprintf("nfcptl: replay packet %d: got %c, want %c %x", e.Index, e.Direction, e.Want.Direction, e.Want.Data)
+	}
+	return fmt.Sprintf("nfcptl: replay packet %d: got %c %x, want %c %x", e.Index, e.Direction, e.Data, e.Want.Direction, e.Want.Data)
+}
+
+// Replay implements the Protocol interface by feeding a recorded session back to the driver. Each
+// Write must match the next packet in the recording and each Read returns the next packet. It also
+// implements the USBProtocol and UARTProtocol interfaces so it can be used with any driver.
+// Once all packets have been replayed, Read and Write return io.EOF.
+type Replay struct {
+	// Realtime makes the Replay wait for the recorded time of each packet before returning it.
+	Realtime bool
+
+	rec *Recording
+
+	mu    sync.Mutex
+	pos   int
+	start time.Time
+	err   error
+	done  chan struct{}
+}
+
+// NewReplay returns a Replay for the given recording.
+func NewReplay(rec *Recording) *Replay {
+	return &Replay{
+		rec:  rec,
+		done: make(chan struct{}),
+	}
+}
+
+func (r *Replay) Connect(c *Client) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.start = time.Now()
+	if len(r.rec.Packets) == 0 {
+		r.finish()
+	}
+
+	return nil
+}
+
+func (r *Replay) Disconnect() error {
+	return nil
+}
+
+func (r *Replay) Read(p []byte) (int, error) {
+	pk, err := r.next(DirectionRead, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(p, pk.Data)
+	if pk.Err != "" {
+		return n, errors.New(pk.Err)
+	}
+
+	return n, nil
+}
+
+func (r *Replay) Write(p []byte) (int, error) {
+	pk, err := r.next(DirectionWrite, p)
+	if err != nil {
+		return 0, err
+	}
+
+	if pk.Err != "" {
+		return 0, errors.New(pk.Err)
+	}
+
+	return len(p), nil
+}
+
+// SetIdle does nothing.
+func (r *Replay) SetIdle(val, idx uint16) {}
+
+// PollInterval returns the recorded poll interval.
+func (r *Replay) PollInterval() time.Duration {
+	if r.rec.PollInterval <= 0 {
+		return replayPollInterval
+	}
+	return r.rec.PollInterval
+}
+
+// MaxPacketSize returns the recorded maximum packet size.
+func (r *Replay) MaxPacketSize() int {
+	return r.rec.MaxPacketSize
+}
+
+// Speed does nothing.
+func (r *Replay) Speed(s int) error {
+	return nil
+}
+
+// Done returns a channel that is closed when all packets have been replayed or the driver did not
+// behave as recorded.
+func (r *Replay) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns an ErrReplayMismatch error when the driver did not behave as recorded.
+func (r *Replay) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// next returns the next packet of the recording after checking it matches the call made by the
+// driver.
+func (r *Replay) next(dir Direction, data []byte) (Packet, error) {
+	r.mu.Lock()
+
+	if r.err != nil {
+		r.mu.Unlock()
+		return Packet{}, r.err
+	}
+	if r.pos >= len(r.rec.Packets) {
+		r.mu.Unlock()
+		return Packet{}, io.EOF
+	}
+
+	pk := r.rec.Packets[r.pos]
+	if pk.Direction != dir || (dir == DirectionWrite && !bytes.Equal(pk.Data, data)) {
+		r.err = &ErrReplayMismatch{Index: r.pos, Want: pk, Direction: dir, Data: data}
+		r.finish()
+		r.mu.Unlock()
+		return Packet{}, r.err
+	}
+
+	r.pos++
+	if r.pos == len(r.rec.Packets) {
+		r.finish()
+	}
+	wait := time.Until(r.start.Add(pk.Time))
+	r.mu.Unlock()
+
+	if r.Realtime && wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return pk, nil
+}
+
+// finish closes the done channel. The caller MUST hold the lock.
+func (r *Replay) finish() {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+}
@@ -0,0 +1,198 @@
+package nfcptl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cannedProtocol is a Protocol returning canned replies to each Read.
+type cannedProtocol struct {
+	replies [][]byte
+	written [][]byte
+}
+
+func (p *cannedProtocol) Connect(c *Client) error { return nil }
+func (p *cannedProtocol) Disconnect() error       { return nil }
+func (p *cannedProtocol) Read(b []byte) (int, error) {
+	if len(p.replies) == 0 {
+		return 0, errors.New("no device")
+	}
+	n := copy(b, p.replies[0])
+	p.replies = p.replies[1:]
+	return n, nil
+}
+func (p *cannedProtocol) Write(b []byte) (int, error) {
+	p.written = append(p.written, b)
+	return len(b), nil
+}
+
+// loadRecording reads the given recording from the testdata directory.
+func loadRecording(t *testing.T, name string) *Recording {
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rec, err := ReadRecording(f)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	return rec
+}
+
+func TestPacket_String(t *testing.T) {
+	p := Packet{Time: 1500 * time.Microsecond, Direction: DirectionWrite, Data: []byte{0x02, 0xcd}}
+	got := p.String()
+	want := "0.001500 W 02cd"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	p.Direction = DirectionRead
+	p.Err = "libusb: no device [code -4]"
+	got = p.String()
+	want = "0.001500 R! 02cd libusb: no device [code -4]"
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestReadRecording(t *testing.T) {
+	in := `# comment
+@ max_packet_size 64
+@ poll_interval 1ms
+
+0.000500 W 02cd
+0.001000 R 0000
+0.001500 R! 0102 libusb: no device [code -4]
+0.002000 W!
+`
+	rec, err := ReadRecording(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	if rec.MaxPacketSize != 64 {
+		t.Errorf("got %d, want 64", rec.MaxPacketSize)
+	}
+	if rec.PollInterval != time.Millisecond {
+		t.Errorf("got %s, want 1ms", rec.PollInterval)
+	}
+
+	want := []Packet{
+		{Time: 500 * time.Microsecond, Direction: DirectionWrite, Data: []byte{0x02, 0xcd}},
+		{Time: time.Millisecond, Direction: DirectionRead, Data: []byte{0x00, 0x00}},
+		{Time: 1500 * time.Microsecond, Direction: DirectionRead, Data: []byte{0x01, 0x02}, Err: "libusb: no device [code -4]"},
+		{Time: 2 * time.Millisecond, Direction: DirectionWrite, Err: "unknown error"},
+	}
+	if len(rec.Packets) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(rec.Packets), len(want))
+	}
+	for i, p := range rec.Packets {
+		if p.String() != want[i].String() {
+			t.Errorf("got %s, want %s", p, want[i])
+		}
+	}
+
+	for _, in := range []string{"@ speed 9600", "0.1 X 00", "abc W 00", "0.1 W 0g"} {
+		if _, err := ReadRecording(strings.NewReader(in)); err == nil {
+			t.Errorf("got nil, want error for %q", in)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	c, err := NewClient(VendorDatelElextronicsLtd, ProductPowerSavesForAmiibo, false)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	buf := &bytes.Buffer{}
+	r := NewRecorder(&cannedProtocol{replies: [][]byte{{0x00, 0x00, 0x4e}}}, buf)
+	if err := r.Connect(c); err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	r.Write([]byte{0x02, 0xcd})
+	b := make([]byte, 4)
+	r.Read(b)
+	r.Read(b)
+
+	if err := r.Err(); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+
+	rec, err := ReadRecording(buf)
+	if err != nil {
+		t.Fatalf("got %s, want nil", err)
+	}
+
+	want := []Packet{
+		{Direction: DirectionWrite, Data: []byte{0x02, 0xcd}},
+		{Direction: DirectionRead, Data: []byte{0x00, 0x00, 0x4e}},
+		{Direction: DirectionRead, Err: "no device"},
+	}
+	if len(rec.Packets) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(rec.Packets), len(want))
+	}
+	for i, p := range rec.Packets {
+		if p.Direction != want[i].Direction || !bytes.Equal(p.Data, want[i].Data) || p.Err != want[i].Err {
+			t.Errorf("got %s, want %s", p, want[i])
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	rec := &Recording{Packets: []Packet{
+		{Direction: DirectionWrite, Data: []byte{0x02}},
+		{Direction: DirectionRead, Data: []byte{0x00, 0x00}},
+		{Direction: DirectionRead, Err: "no device"},
+	}}
+
+	r := NewReplay(rec)
+	r.Connect(nil)
+
+	if n, err := r.Write([]byte{0x02}); n != 1 || err != nil {
+		t.Errorf("got %d,%v, want 1,<nil>", n, err)
+	}
+	b := make([]byte, 4)
+	if n, err := r.Read(b); n != 2 || err != nil {
+		t.Errorf("got %d,%v, want 2,<nil>", n, err)
+	}
+	if _, err := r.Read(b); err == nil || err.Error() != "no device" {
+		t.Errorf("got %v, want no device", err)
+	}
+
+	select {
+	case <-r.Done():
+	default:
+		t.Error("replay not done, want done")
+	}
+	if _, err := r.Read(b); err != io.EOF {
+		t.Errorf("got %v, want %s", err, io.EOF)
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("got %s, want nil", err)
+	}
+
+	r = NewReplay(rec)
+	r.Connect(nil)
+	_, err := r.Write([]byte{0x03})
+	if _, ok := err.(*ErrReplayMismatch); !ok {
+		t.Fatalf("got %v, want ErrReplayMismatch", err)
+	}
+	want := "nfcptl: replay packet 0: got W 03, want W 02"
+	if err.Error() != want {
+		t.Errorf("got %s, want %s", err, want)
+	}
+	if r.Err() != err {
+		t.Errorf("got %v, want %s", r.Err(), err)
+	}
+}
@@ -4,9 +4,18 @@ import (
 	"github.com/pkg/term"
 )
 
+// UARTProtocol defines the interface for Protocols used by UART drivers.
+type UARTProtocol interface {
+	Protocol
+	// Speed sets the baud rate of the serial port.
+	Speed(s int) error
+}
+
+// Serial defines the setup struct returned by UART drivers. The values returned by the driver are
+// defaults which can be overridden using Client.SetSerial.
 type Serial struct {
-	Port string
-	Baud int
+	Port string // Port holds the path of the serial port, e.g. /dev/ttyUSB0.
+	Baud int    // Baud holds the baud rate to use.
 }
 
 // UART implements the Protocol interface to allow drivers to support UART devices.
@@ -31,7 +40,9 @@ func (uart *UART) Connect(c *Client) error {
 
 func (uart *UART) Disconnect() error {
 	if uart.prt != nil {
-		return uart.prt.Close()
+		err := uart.prt.Close()
+		uart.prt = nil
+		return err
 	}
 
 	return nil
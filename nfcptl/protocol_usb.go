@@ -23,6 +23,17 @@ type DeviceSetup struct {
 	OutEndpoint int
 }
 
+// USBProtocol defines the interface for Protocols used by USB drivers.
+type USBProtocol interface {
+	Protocol
+	// SetIdle sends SET_IDLE control request to the device.
+	SetIdle(val, idx uint16)
+	// PollInterval returns the poll interval for the device.
+	PollInterval() time.Duration
+	// MaxPacketSize returns the maximum packet size the device will accept.
+	MaxPacketSize() int
+}
+
 // USB implements the Protocol interface to allow drivers to support USB devices.
 type USB struct {
 	ctx   *gousb.Context     // The active context
@@ -42,7 +53,12 @@ func (usb *USB) Connect(c *Client) error {
 		//usb.ctx.Debug(4)
 	}
 
-	usb.dev, err = usb.ctx.OpenDeviceWithVIDPID(gousb.ID(c.VendorId()), gousb.ID(c.ProductId()))
+	target := c.Target()
+	if target.Serial == "" && target.Bus == 0 {
+		usb.dev, err = usb.ctx.OpenDeviceWithVIDPID(gousb.ID(c.VendorId()), gousb.ID(c.ProductId()))
+	} else {
+		usb.dev, err = usb.openTarget(c.VendorId(), c.ProductId(), target)
+	}
 	if err != nil {
 		return fmt.Errorf("usb: could not open device: %v", err)
 	}
@@ -92,21 +108,62 @@ func (usb *USB) Connect(c *Client) error {
 	return nil
 }
 
+// openTarget opens the device with the given vendor and product ID that matches the target's serial
+// number and/or bus and address. All other devices that have been opened in the process are closed
+// again.
+func (usb *USB) openTarget(vid, pid uint16, target DeviceInfo) (*gousb.Device, error) {
+	devs, err := usb.ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return matchesTarget(desc, vid, pid, target)
+	})
+
+	var found *gousb.Device
+	for _, dev := range devs {
+		if found == nil {
+			if serial, _ := dev.SerialNumber(); target.Serial == "" || serial == target.Serial {
+				found = dev
+				continue
+			}
+		}
+		dev.Close()
+	}
+
+	if found == nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// matchesTarget returns true when the device described by desc has the given vendor and product ID
+// and is located at the bus and address of the target. The bus and address are not checked when
+// the target's bus is zero.
+func matchesTarget(desc *gousb.DeviceDesc, vid, pid uint16, target DeviceInfo) bool {
+	if uint16(desc.Vendor) != vid || uint16(desc.Product) != pid {
+		return false
+	}
+
+	return target.Bus == 0 || (desc.Bus == target.Bus && desc.Address == target.Address)
+}
+
 func (usb *USB) Disconnect() error {
 	if usb.iface != nil {
 		usb.iface.Close()
+		usb.iface = nil
 	}
 
 	if usb.cfg != nil {
 		usb.cfg.Close()
+		usb.cfg = nil
 	}
 
 	if usb.dev != nil {
 		usb.dev.Close()
+		usb.dev = nil
 	}
 
 	if usb.ctx != nil {
 		usb.ctx.Close()
+		usb.ctx = nil
 	}
 
 	return nil
@@ -138,3 +195,42 @@ func (usb *USB) PollInterval() time.Duration {
 func (usb *USB) MaxPacketSize() int {
 	return usb.in.Desc.MaxPacketSize
 }
+
+// attachedDevices returns a DeviceInfo struct for each attached USB device for which match returns
+// true. Matching devices are opened briefly to read the serial number string.
+func attachedDevices(match func(vid, pid uint16) bool) ([]DeviceInfo, error) {
+	ctx := gousb.NewContext()
+	defer ctx.Close()
+
+	var infos []DeviceInfo
+	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		if !match(uint16(desc.Vendor), uint16(desc.Product)) {
+			return false
+		}
+		infos = append(infos, DeviceInfo{
+			VendorId:  uint16(desc.Vendor),
+			ProductId: uint16(desc.Product),
+			Bus:       desc.Bus,
+			Address:   desc.Address,
+			Port:      desc.Port,
+			Path:      usbPortPath(desc.Path),
+		})
+		return true
+	})
+	// OpenDevices also returns an error when one of the matching devices could not be opened, in
+	// which case we simply cannot read its serial number.
+	if err != nil && len(infos) == 0 {
+		return nil, fmt.Errorf("usb: could not enumerate devices: %v", err)
+	}
+
+	for _, dev := range devs {
+		for i := range infos {
+			if infos[i].Bus == dev.Desc.Bus && infos[i].Address == dev.Desc.Address {
+				infos[i].Serial, _ = dev.SerialNumber()
+			}
+		}
+		dev.Close()
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,31 @@
+package nfcptl
+
+import (
+	"github.com/google/gousb"
+	"testing"
+)
+
+func TestMatchesTarget(t *testing.T) {
+	desc := &gousb.DeviceDesc{Vendor: 0x1c1a, Product: 0x03d9, Bus: 1, Address: 7}
+
+	tests := []struct {
+		vid    uint16
+		pid    uint16
+		target DeviceInfo
+		want   bool
+	}{
+		{vid: 0x1c1a, pid: 0x03d9, target: DeviceInfo{}, want: true},
+		{vid: 0x1c1a, pid: 0x03d9, target: DeviceInfo{Serial: "0123456789AB"}, want: true},
+		{vid: 0x1c1a, pid: 0x03d9, target: DeviceInfo{Bus: 1, Address: 7}, want: true},
+		{vid: 0x1c1a, pid: 0x03d9, target: DeviceInfo{Bus: 1, Address: 8}, want: false},
+		{vid: 0x1c1a, pid: 0x03d9, target: DeviceInfo{Bus: 2, Address: 7}, want: false},
+		{vid: 0x5c60, pid: 0xdead, target: DeviceInfo{}, want: false},
+	}
+
+	for _, tst := range tests {
+		got := matchesTarget(desc, tst.vid, tst.pid, tst.target)
+		if got != tst.want {
+			t.Errorf("matchesTarget() for %04x:%04x and target %v returned %v, want %v", tst.vid, tst.pid, tst.target, got, tst.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package nfcptl
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// serverHelloTimeout is the time a connecting remote has to authenticate.
+	serverHelloTimeout = 10 * time.Second
+	// serverBuffer is the number of events buffered for each remote. It allows all events of a
+	// full write to be buffered when the network is slower than the portal.
+	serverBuffer = 1024
+	// serverHelloSize is the maximum size of the hello sent by a remote that did not authenticate
+	// yet.
+	serverHelloSize = 1024
+	// serverMessageSize is the maximum size of a message sent by an authenticated remote. It
+	// allows the arguments of the largest command, a WriteBank, to be sent.
+	serverMessageSize = 16 * 1024
+)
+
+var (
+	// ErrNoToken is returned by NewServer when no auth token is given.
+	ErrNoToken = errors.New("nfcptl: an auth token is required to serve a portal")
+	// ErrInvalidToken is returned by the Remote driver when the server refused the auth token.
+	ErrInvalidToken = errors.New("nfcptl: the server refused the auth token")
+	// errMessageTooLong is returned when a remote sends a message exceeding the allowed size.
+	errMessageTooLong = errors.New("nfcptl: message too long")
+)
+
+// remoteMessage is a single line of JSON exchanged between a Server and the Remote driver. The
+// remote starts by sending a hello holding the auth token to which the server replies with the
+// vendor and product it is serving or with an error, after which the connection is closed. Once
+// authenticated, the remote sends commands and the server sends all events published by the
+// client it is serving.
+type remoteMessage struct {
+	// Hello and its reply.
+	Token     string `json:"token,omitempty"`
+	Vendor    string `json:"vendor,omitempty"`
+	Product   string `json:"product,omitempty"`
+	VendorId  uint16 `json:"vendor_id,omitempty"`
+	ProductId uint16 `json:"product_id,omitempty"`
+
+	// Commands.
+	Command   *ClientCommand `json:"command,omitempty"`
+	Arguments []byte         `json:"arguments,omitempty"`
+
+	// Events.
+	Event EventType      `json:"event,omitempty"`
+	Data  []byte         `json:"data,omitempty"`
+	Time  int64          `json:"time,omitempty"` // Unix time in nanoseconds.
+	Cause *ClientCommand `json:"cause,omitempty"`
+
+	// Error is set in the hello reply when authentication failed and in events holding an error.
+	Error string `json:"error,omitempty"`
+}
+
+// Server exposes a connected Client over the network, allowing the portal to be used from another
+// machine through the Remote driver. Each remote must authenticate using the auth token the server
+// was created with. An authenticated remote receives all events published by the client and can
+// send it any command. Multiple remotes can be connected at the same time, they all observe the
+// same portal.
+// The connection is not encrypted: the server SHOULD only listen on a trusted network or on a
+// loopback address to be reached through a tunnel such as SSH port forwarding.
+// The served client never blocks on its Events channel, so the caller does not need to drain it.
+// Each remote has its own buffer: a remote that does not keep up loses the oldest events, except
+// for DeviceLeft and Disconnect.
+type Server struct {
+	c     *Client
+	token string
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// NewServer returns a Server exposing the given client. ErrNoToken is returned when token is
+// empty.
+func NewServer(c *Client, token string) (*Server, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	return &Server{
+		c:     c,
+		token: token,
+		conns: make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Serve accepts connections on the given listener until it is closed, handling each connection in
+// its own goroutine. All connections are closed when the served client shuts down. Serve always
+// returns a non-nil error: the error returned by the listener.
+func (s *Server) Serve(l net.Listener) error {
+	go func() {
+		<-s.c.Terminate()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle authenticates the remote on the given connection, after which it forwards the events of
+// the client to the remote and the commands of the remote to the client until either side is
+// done.
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	s.mu.Lock()
+	if s.c.ctx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	enc := json.NewEncoder(conn)
+	r := &messageReader{r: conn}
+	dec := json.NewDecoder(r)
+
+	var hello remoteMessage
+	conn.SetReadDeadline(time.Now().Add(serverHelloTimeout))
+	r.limit(serverHelloSize)
+	if err := dec.Decode(&hello); err != nil {
+		log.Printf("nfcptl: server: no hello from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hello.Token), []byte(s.token)) != 1 {
+		log.Printf("nfcptl: server: %s sent an invalid auth token", conn.RemoteAddr())
+		enc.Encode(remoteMessage{Error: ErrInvalidToken.Error()})
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	events, unsubscribe := s.c.subscribe(serverBuffer)
+	defer unsubscribe()
+
+	if err := enc.Encode(remoteMessage{
+		Vendor:    s.c.va,
+		Product:   s.c.pa,
+		VendorId:  s.c.VendorId(),
+		ProductId: s.c.ProductId(),
+	}); err != nil {
+		return
+	}
+	log.Printf("nfcptl: server: %s connected", conn.RemoteAddr())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.readCommands(conn, r, dec)
+	}()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(eventToMessage(e)); err != nil {
+				log.Printf("nfcptl: server: sending event to %s: %s", conn.RemoteAddr(), err)
+				return
+			}
+		case <-done:
+			log.Printf("nfcptl: server: %s disconnected", conn.RemoteAddr())
+			return
+		}
+	}
+}
+
+// readCommands sends the commands received from the remote to the client until the connection is
+// closed, the remote sends a message that is too long or the client shuts down. Unknown commands
+// are dropped.
+func (s *Server) readCommands(conn net.Conn, r *messageReader, dec *json.Decoder) {
+	for {
+		var m remoteMessage
+		r.limit(serverMessageSize)
+		if err := dec.Decode(&m); err != nil {
+			if errors.Is(err, errMessageTooLong) {
+				log.Printf("nfcptl: server: %s sent a message that is too long", conn.RemoteAddr())
+			}
+			return
+		}
+		if m.Command == nil {
+			continue
+		}
+		if *m.Command > PwdAuth {
+			log.Printf("nfcptl: server: %s sent unknown command %d", conn.RemoteAddr(), *m.Command)
+			continue
+		}
+
+		if s.c.Debug() {
+			log.Printf("nfcptl: server: %s sent command %s", conn.RemoteAddr(), *m.Command)
+		}
+		if err := s.c.SendCommandContext(context.Background(), Command{Command: *m.Command, Arguments: m.Arguments}); err != nil {
+			return
+		}
+	}
+}
+
+// messageReader limits the number of bytes that can be read from a connection to decode a single
+// message, so a remote cannot make the server buffer an endless line. Since the decoder reads
+// ahead, the limit is approximate.
+type messageReader struct {
+	r io.Reader
+	n int
+}
+
+// limit allows n more bytes to be read.
+func (m *messageReader) limit(n int) {
+	m.n = n
+}
+
+// Read implements the io.Reader interface returning errMessageTooLong once the limit is reached.
+func (m *messageReader) Read(p []byte) (int, error) {
+	if m.n <= 0 {
+		return 0, errMessageTooLong
+	}
+	if len(p) > m.n {
+		p = p[:m.n]
+	}
+	n, err := m.r.Read(p)
+	m.n -= n
+
+	return n, err
+}
+
+// eventToMessage converts the given event to its remoteMessage.
+func eventToMessage(e *Event) remoteMessage {
+	m := remoteMessage{
+		Event: e.name,
+		Data:  e.data,
+		Time:  e.time.UnixNano(),
+	}
+	if cmd, ok := e.Command(); ok {
+		m.Cause = &cmd
+	}
+	if e.err != nil {
+		m.Error = e.err.Error()
+	}
+
+	return m
+}
+
+// messageToEvent converts the given remoteMessage to the event it holds.
+func messageToEvent(m remoteMessage) *Event {
+	e := &Event{
+		name: m.Event,
+		data: m.Data,
+		time: time.Unix(0, m.Time),
+	}
+	if m.Cause != nil {
+		e.setCommand(*m.Cause)
+	}
+	if m.Error != "" {
+		e.err = errors.New(m.Error)
+	}
+
+	return e
+}
@@ -2,24 +2,40 @@ package nfcptl
 
 const (
 	// Vendor aliases
+	VendorAdvancedCardSystems = "acs"
 	VendorDatelElextronicsLtd = "datel"
 	VendorMaxlander           = "maxlander"
+	VendorNXPSemiconductors   = "nxp"
+	VendorRemote              = "remote"
 	VendorSiliconLabs         = "silabs"
+	VendorVirtual             = "virtual"
 
 	// Vendor IDs
-	VIDDatelElectronicsLtd uint16 = 0x1c1a
+	VIDAdvancedCardSystems uint16 = 0x072f
+	VIDDatelElectronicsLtd        = 0x1c1a
 	VIDMaxlander                  = 0x5c60
+	VIDNXPSemiconductors          = 0x1fc9
+	VIDRemote                     = 0x0000
 	VIDSiliconLabs                = 0x10c4
+	VIDVirtual                    = 0x0000
 
 	// Product aliases
+	ProductACR122U             = "acr122u"
 	ProductPowerSavesForAmiibo = "ps4amiibo"
 	ProductMaxLander           = "maxlander"
 	ProductN2EliteUSB          = "n2eliteusb"
+	ProductPN532               = "pn532"
+	ProductServer              = "server"
+	ProductEmulator            = "emulator"
 
 	// Product IDs
-	PIDPowerSavesForAmiibo uint16 = 0x03d9
+	PIDACR122U             uint16 = 0x2200
+	PIDPowerSavesForAmiibo        = 0x03d9
 	PIDMaxLander                  = 0xdead
 	PIDCP210xUARTBridge           = 0xea60
+	PIDPN532                      = 0x0000 // The PN532 is connected through a USB to UART bridge.
+	PIDEmulator                   = 0x0000
+	PIDServer                     = 0x0000 // The portal is exposed by a Server on another machine.
 )
 
 // Vendor describes a vendor and its products as supported by the Driver.
@@ -15,3 +15,27 @@ func TestDatelValues(t *testing.T) {
 		t.Errorf("VendorDatelElextronicsLtd value was %s, want %s", VendorDatelElextronicsLtd, wantAlias)
 	}
 }
+
+func TestAdvancedCardSystemsValues(t *testing.T) {
+	wantVid := uint16(0x072f)
+	if VIDAdvancedCardSystems != wantVid {
+		t.Errorf("VIDAdvancedCardSystems value was %#04x, want %#04x", VIDAdvancedCardSystems, wantVid)
+	}
+
+	wantAlias := "acs"
+	if VendorAdvancedCardSystems != wantAlias {
+		t.Errorf("VendorAdvancedCardSystems value was %s, want %s", VendorAdvancedCardSystems, wantAlias)
+	}
+}
+
+func TestNXPSemiconductorsValues(t *testing.T) {
+	wantVid := uint16(0x1fc9)
+	if VIDNXPSemiconductors != wantVid {
+		t.Errorf("VIDNXPSemiconductors value was %#04x, want %#04x", VIDNXPSemiconductors, wantVid)
+	}
+
+	wantAlias := "nxp"
+	if VendorNXPSemiconductors != wantAlias {
+		t.Errorf("VendorNXPSemiconductors value was %s, want %s", VendorNXPSemiconductors, wantAlias)
+	}
+}